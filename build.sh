@@ -3,13 +3,16 @@ set -e
 
 cd ${GOPATH}/src/github.com/AliyunContainerService/flexvolume/
 GIT_SHA=`git rev-parse --short HEAD || echo "HEAD"`
+BUILD_DATE=`date -u +%Y-%m-%dT%H:%M:%SZ`
+VERSION_PKG="github.com/AliyunContainerService/flexvolume/provider/utils"
+LD_FLAGS="-X ${VERSION_PKG}.GITCOMMIT=${GIT_SHA} -X ${VERSION_PKG}.BUILDDATE=${BUILD_DATE}"
 
 
 export GOARCH="amd64"
 export GOOS="linux"
 if [[ "$(uname -s)" == "Linux" ]];then
-	CGO_ENABLED=1 go build -tags 'netgo' --ldflags '-extldflags "-static"' -o flexvolume-linux 
+	CGO_ENABLED=1 go build -tags 'netgo' --ldflags "${LD_FLAGS} -extldflags \"-static\"" -o flexvolume-linux
 else
-	CGO_ENABLED=0 go build -o flexvolume-linux 
+	CGO_ENABLED=0 go build --ldflags "${LD_FLAGS}" -o flexvolume-linux
 fi
 
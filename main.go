@@ -2,10 +2,20 @@ package main
 
 import (
 	"fmt"
+	"github.com/AliyunContainerService/flexvolume/provider/audit"
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
 	driver "github.com/AliyunContainerService/flexvolume/provider/driver"
+	"github.com/AliyunContainerService/flexvolume/provider/journal"
 	utils "github.com/AliyunContainerService/flexvolume/provider/utils"
 	"os"
 	"strings"
+
+	// volume drivers register themselves in init()
+	_ "github.com/AliyunContainerService/flexvolume/provider/cpfs"
+	_ "github.com/AliyunContainerService/flexvolume/provider/disk"
+	_ "github.com/AliyunContainerService/flexvolume/provider/nas"
+	_ "github.com/AliyunContainerService/flexvolume/provider/oss"
 )
 
 // Expect to support K8s and Swarm platform
@@ -13,10 +23,12 @@ import (
 // Under swarm, plugin will be running always, and communicate with docker by socket.
 func main() {
 
-	// get the environment of platform
-	platform := os.Getenv("ACS_PLATFORM")
-	if platform == "swarm" {
-		driver.RunningInSwarm()
+	// get the platform of flexvolume.conf or environment
+	if config.Get().Platform == config.PLATFORM_SWARM {
+		if err := driver.RunningInSwarm(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 	} else {
 		driver.RunK8sAction()
 	}
@@ -24,6 +36,10 @@ func main() {
 
 // check running environment and print help
 func init() {
+	// swarm plugin running as daemon without arguments
+	if len(os.Args) == 1 && config.Get().Platform == config.PLATFORM_SWARM {
+		return
+	}
 	if len(os.Args) == 1 {
 		utils.Usage()
 		os.Exit(0)
@@ -31,7 +47,44 @@ func init() {
 
 	argsOne := strings.ToLower(os.Args[1])
 	if argsOne == "--version" || argsOne == "version" || argsOne == "-v" {
-		fmt.Printf(utils.PluginVersion())
+		fmt.Print(driver.VersionInfo(len(os.Args) > 2 && os.Args[2] == "--json"))
+		os.Exit(0)
+	}
+
+	if argsOne == "drivers" {
+		fmt.Print(driver.DriversInfo(len(os.Args) > 2 && os.Args[2] == "--names"))
+		os.Exit(0)
+	}
+
+	if argsOne == "audit" {
+		if err := audit.Query(os.Args[2:], os.Stdout); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if argsOne == "config" {
+		if err := config.Show(os.Args[2:], os.Stdout); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if argsOne == "credentials" {
+		if err := credentials.Show(os.Args[2:], os.Stdout); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if argsOne == "journal" {
+		if err := journal.Query(os.Args[2:], os.Stdout); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
 
@@ -58,26 +58,37 @@ fi
 
 restart_kubelet="false"
 
-install_disk() {
-    # first install
-    if [ ! -f "/host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/disk" ];then
-        mkdir -p /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/
-        cp /acs/flexvolume /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/disk
-        chmod 755 /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/disk
-
-    # update status
-    else
-        oldmd5=`md5sum /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/disk | awk '{print $1}'`
+FLEXVOLUME_DIR="/usr/libexec/kubernetes/kubelet-plugins/volume/exec"
+FLEXVOLUME_BIN="/usr/libexec/kubernetes/alicloud/flexvolume"
+FLEXVOLUME_CONF="/etc/kubernetes/flexvolume.conf"
+FLEXVOLUME_CA="/etc/kubernetes/flexvolume-ca.crt"
+
+# install the binary once, every driver is a symlink to it
+install_binary() {
+    if [ -f "/host${FLEXVOLUME_BIN}" ]; then
+        oldmd5=`md5sum /host${FLEXVOLUME_BIN} | awk '{print $1}'`
         newmd5=`md5sum /acs/flexvolume | awk '{print $1}'`
-
-        # update disk bianary
-        if [ "$oldmd5" != "$newmd5" ]; then
-            rm -rf /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/disk
-            cp /acs/flexvolume /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/disk
-            chmod 755 /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/disk
-
+        if [ "$oldmd5" = "$newmd5" ]; then
+            return
         fi
     fi
+    mkdir -p /host/usr/libexec/kubernetes/alicloud/
+    cp /acs/flexvolume /host${FLEXVOLUME_BIN}.tmp
+    chmod 755 /host${FLEXVOLUME_BIN}.tmp
+    mv /host${FLEXVOLUME_BIN}.tmp /host${FLEXVOLUME_BIN}
+}
+
+# link driver to the binary, the binary copied by old version is replaced
+install_driver() {
+    name=$1
+    mkdir -p /host${FLEXVOLUME_DIR}/alicloud~${name}/
+    if [ "`readlink /host${FLEXVOLUME_DIR}/alicloud~${name}/${name}`" != "${FLEXVOLUME_BIN}" ]; then
+        ln -sf ${FLEXVOLUME_BIN} /host${FLEXVOLUME_DIR}/alicloud~${name}/${name}
+    fi
+}
+
+install_disk() {
+    install_driver disk
 
     # generate disk config for Apsara Stack
     if [ "$ACCESS_KEY_ID" != "" ] && [ "$ACCESS_KEY_SECRET" != "" ] && [ "$ECS_ENDPOINT" != "" ]; then
@@ -108,24 +119,7 @@ install_nas() {
     #    fi
     #fi
 
-    # first install
-    if [ ! -f "/host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/nas" ];then
-        mkdir -p /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/
-        cp /acs/flexvolume /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/nas
-        chmod 755 /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/nas
-
-    # update nas
-    else
-        oldmd5=`md5sum /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/nas | awk '{print $1}'`
-        newmd5=`md5sum /acs/flexvolume | awk '{print $1}'`
-
-        # install a new bianary
-        if [ "$oldmd5" != "$newmd5" ]; then
-            rm -rf /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/nas
-            cp /acs/flexvolume /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/nas
-            chmod 755 /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/nas
-        fi
-    fi
+    install_driver nas
 
 }
 
@@ -176,25 +170,7 @@ install_oss() {
     fi
 
 
-    # first install OSS
-    if [ ! -f "/host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss" ];then
-        mkdir -p /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/
-        cp /acs/flexvolume /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss
-        chmod 755 /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss
-
-    # update oss
-    else
-        oldmd5=`md5sum /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss | awk '{print $1}'`
-        newmd5=`md5sum /acs/flexvolume | awk '{print $1}'`
-
-        # install a new bianary
-        if [ "$oldmd5" != "$newmd5" ]; then
-            rm -rf /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss
-            cp /acs/flexvolume /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss
-            chmod 755 /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss
-
-        fi
-    fi
+    install_driver oss
 
     # generate oss ak
     if [ "$ACCESS_KEY_ID" != "" ] && [ "$ACCESS_KEY_SECRET" != "" ]; then
@@ -266,25 +242,7 @@ install_cpfs() {
         fi
     fi
 
-    # first install cpfs
-    if [ ! -f "/host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~cpfs/cpfs" ];then
-        mkdir -p /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~cpfs/
-        cp /acs/flexvolume /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~cpfs/cpfs
-        chmod 755 /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~cpfs/cpfs
-
-    # upgrade cpfs
-    else
-        oldmd5=`md5sum /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~cpfs/cpfs | awk '{print $1}'`
-        newmd5=`md5sum /acs/flexvolume | awk '{print $1}'`
-
-        # install a new bianary
-        if [ "$oldmd5" != "$newmd5" ]; then
-            rm -rf /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~cpfs/cpfs
-            cp /acs/flexvolume /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~cpfs/cpfs
-            chmod 755 /host/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~cpfs/cpfs
-
-        fi
-    fi
+    install_driver cpfs
 }
 
 # if kubelet not disable controller, exit
@@ -301,20 +259,56 @@ if [ "$enableADController" = "true" ]; then
   echo "kubelet not running in: enable-controller-attach-detach=false, mount maybe failed"
 fi
 
-# install plugins
-if [ "$ACS_DISK" = "true" ]; then
-  install_disk
-fi
-if [ "$ACS_OSS" = "true" ]; then
-  install_oss
-fi
-if [ "$ACS_NAS" = "true" ]; then
-  install_nas
-fi
+# set the key of flexvolume.conf, the plugins called by kubelet have no env of this pod
+set_config() {
+    key=$1
+    value=$2
+    mkdir -p /host/etc/kubernetes/
+    touch /host${FLEXVOLUME_CONF}
+    sed -i "/^${key}:/d" /host${FLEXVOLUME_CONF}
+    echo "${key}: \"${value}\"" >> /host${FLEXVOLUME_CONF}
+}
 
-if [ "$ACS_CPFS" = "true" ]; then
-  install_cpfs
-fi
+# the transport of private cloud: CA_BUNDLE is the certificates file mounted in this pod
+install_transport() {
+    if [ "$CA_BUNDLE" != "" ]; then
+        mkdir -p /host/etc/kubernetes/
+        cp ${CA_BUNDLE} /host${FLEXVOLUME_CA}
+        set_config ca_bundle ${FLEXVOLUME_CA}
+    fi
+    if [ "$HTTP_PROXY" != "" ]; then
+        set_config http_proxy "$HTTP_PROXY"
+    fi
+    if [ "$HTTPS_PROXY" != "" ]; then
+        set_config https_proxy "$HTTPS_PROXY"
+    fi
+    if [ "$NO_PROXY" != "" ]; then
+        set_config no_proxy "$NO_PROXY"
+    fi
+    if [ "$TLS_MIN_VERSION" != "" ]; then
+        set_config tls_min_version "$TLS_MIN_VERSION"
+    fi
+    if [ "$REQUEST_TIMEOUT" != "" ]; then
+        set_config request_timeout "$REQUEST_TIMEOUT"
+    fi
+}
+
+# install plugins, driver is enabled by ACS_<DRIVER>=true
+install_binary
+install_transport
+for driver in `/acs/flexvolume drivers --names`; do
+  upper=`echo ${driver} | tr 'a-z' 'A-Z'`
+  eval enabled=\$ACS_${upper}
+  if [ "$enabled" != "true" ]; then
+    continue
+  fi
+  # driver with host dependencies has its own installer
+  if type install_${driver} > /dev/null 2>&1; then
+    install_${driver}
+  else
+    install_driver ${driver}
+  fi
+done
 
 
 ## monitoring should be here
@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+// AUDIT_FILE is the node local journal of volume operations, one json record per line
+const AUDIT_FILE = "/var/log/alicloud/flexvolume_audit.jsonl"
+
+// REDACTED replace the sensitive option values
+const REDACTED = "******"
+
+// the option keys contain these words are sensitive
+var sensitiveKeys = []string{"secret", "password", "token", "akid", "accesskey"}
+
+// Record is one plugin call in the journal
+type Record struct {
+	Time       time.Time              `json:"time"`
+	OpID       string                 `json:"op_id"`
+	Node       string                 `json:"node"`
+	Driver     string                 `json:"driver"`
+	Verb       string                 `json:"verb"`
+	Volume     string                 `json:"volume,omitempty"`
+	Pod        string                 `json:"pod_uid,omitempty"`
+	Args       []string               `json:"args,omitempty"`
+	Options    map[string]interface{} `json:"options,omitempty"`
+	Result     utils.Result           `json:"result"`
+	ExitCode   int                    `json:"exit_code"`
+	DurationMs int64                  `json:"duration_ms"`
+}
+
+// SanitizeOptions return the options with sensitive values redacted
+func SanitizeOptions(options map[string]interface{}) map[string]interface{} {
+	sanitized := map[string]interface{}{}
+	for key, value := range options {
+		sanitized[key] = value
+		lowKey := strings.ToLower(key)
+		for _, word := range sensitiveKeys {
+			if strings.Contains(lowKey, word) {
+				sanitized[key] = REDACTED
+				break
+			}
+		}
+	}
+	return sanitized
+}
+
+// Append write the record to the end of journal, concurrent plugin calls are serialized by flock
+func Append(file string, record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Filter select the records, empty field matches all
+type Filter struct {
+	Volume string
+	Pod    string
+	Driver string
+	Since  time.Time
+	Until  time.Time
+}
+
+// Match check the record is selected by filter
+func (f *Filter) Match(record *Record) bool {
+	if f.Volume != "" && record.Volume != f.Volume && !containsArg(record, f.Volume) {
+		return false
+	}
+	if f.Pod != "" && record.Pod != f.Pod {
+		return false
+	}
+	if f.Driver != "" && record.Driver != f.Driver {
+		return false
+	}
+	if !f.Since.IsZero() && record.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && record.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// disk is looked up by disk id, which is in the options or args
+func containsArg(record *Record, value string) bool {
+	for _, option := range record.Options {
+		if option == value {
+			return true
+		}
+	}
+	for _, arg := range record.Args {
+		if arg == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Backups return the rotated journals of file, oldest first: flexvolume_audit-<time>.jsonl[.gz]
+func Backups(file string) []string {
+	ext := filepath.Ext(file)
+	files, _ := filepath.Glob(strings.TrimSuffix(file, ext) + "-*" + ext + "*")
+	backups := []string{}
+	for _, backup := range files {
+		if strings.HasSuffix(backup, ext) || strings.HasSuffix(backup, ext+".gz") {
+			backups = append(backups, backup)
+		}
+	}
+	// the timestamp in name is sortable
+	sort.Strings(backups)
+	return backups
+}
+
+// ReadAll return the records of the rotated journals and the journal, missing files are skipped
+func ReadAll(file string, filter *Filter) ([]Record, error) {
+	records := []Record{}
+	found := false
+	for _, name := range append(Backups(file), file) {
+		selected, err := Read(name, filter)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		records = append(records, selected...)
+	}
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: file, Err: os.ErrNotExist}
+	}
+	return records, nil
+}
+
+// Read return the records selected by filter in journal order, broken lines are skipped
+func Read(file string, filter *Filter) ([]Record, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	records := []Record{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if filter.Match(&record) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
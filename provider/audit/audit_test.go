@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+func TestAppendAndQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.jsonl")
+
+	now := time.Now()
+	records := []*Record{
+		{Time: now.Add(-2 * time.Hour), Driver: "disk", Verb: "attach", Volume: "pv1", Options: map[string]interface{}{"volumeId": "d-1"}, Result: utils.Succeed()},
+		{Time: now.Add(-time.Minute), Driver: "nas", Verb: "mount", Volume: "pv2", Pod: "uid-2", Result: utils.Fail("mount failed"), ExitCode: 1},
+		{Time: now, Driver: "disk", Verb: "detach", Volume: "pv1", Args: []string{"d-1", "node1"}, Result: utils.Succeed()},
+	}
+	for _, record := range records {
+		if err := Append(file, record); err != nil {
+			t.Fatalf("append error: %v", err)
+		}
+	}
+
+	cases := map[string]int{
+		"--volume d-1":              2,
+		"--volume pv1 --since 1h":   1,
+		"--pod uid-2":               1,
+		"--driver disk --until 30m": 1,
+		"--driver oss":              0,
+		"--since " + now.Add(time.Hour).Format(time.RFC3339): 0,
+	}
+	for args, expect := range cases {
+		out := &bytes.Buffer{}
+		if err := Query(append([]string{"--file", file}, strings.Fields(args)...), out); err != nil {
+			t.Fatalf("%s: query error: %v", args, err)
+		}
+		if lines := strings.Count(out.String(), "\n"); lines != expect {
+			t.Errorf("%s: expect %d records, got %d: %s", args, expect, lines, out.String())
+		}
+	}
+}
+
+func TestSanitizeOptions(t *testing.T) {
+	options := SanitizeOptions(map[string]interface{}{"akId": "id", "akSecret": "secret", "kubernetes.io/secret/akSecret": "c2VjcmV0", "bucket": "oss"})
+	if options["akId"] != REDACTED || options["akSecret"] != REDACTED || options["kubernetes.io/secret/akSecret"] != REDACTED {
+		t.Errorf("sensitive options not redacted: %v", options)
+	}
+	if options["bucket"] != "oss" {
+		t.Errorf("options should be kept: %v", options)
+	}
+}
+
+func TestReadAllRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "flexvolume_audit.jsonl")
+
+	// the oldest journal is compressed, the newer one is not, and the current one is the latest
+	Append(filepath.Join(dir, "flexvolume_audit-2019-01-02-00:00:00.000.jsonl"), &Record{Verb: "mount", Volume: "pv1"})
+	Append(file, &Record{Verb: "unmount", Volume: "pv1"})
+	old := &bytes.Buffer{}
+	writer := gzip.NewWriter(old)
+	line, _ := json.Marshal(&Record{Verb: "attach", Volume: "pv1"})
+	writer.Write(append(line, '\n'))
+	writer.Close()
+	ioutil.WriteFile(filepath.Join(dir, "flexvolume_audit-2019-01-01-00:00:00.000.jsonl.gz"), old.Bytes(), 0600)
+
+	records, err := ReadAll(file, &Filter{Volume: "pv1"})
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	verbs := []string{}
+	for _, record := range records {
+		verbs = append(verbs, record.Verb)
+	}
+	if strings.Join(verbs, ",") != "attach,mount,unmount" {
+		t.Errorf("expect records in journal order, got %v", verbs)
+	}
+	if _, err := ReadAll(filepath.Join(dir, "missing.jsonl"), &Filter{}); !os.IsNotExist(err) {
+		t.Errorf("expect not exist error, got %v", err)
+	}
+}
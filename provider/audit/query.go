@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Query run the audit subcommand, print the selected records as json lines:
+// flexvolume audit --volume d-xxx --since 24h
+func Query(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.SetOutput(out)
+	file := flags.String("file", AUDIT_FILE, "audit journal file, the rotated journals of it are read too")
+	filter := &Filter{}
+	flags.StringVar(&filter.Volume, "volume", "", "volume name or disk id")
+	flags.StringVar(&filter.Pod, "pod", "", "pod uid")
+	flags.StringVar(&filter.Driver, "driver", "", "driver name, disk, nas, oss or cpfs")
+	since := flags.String("since", "", "start time in RFC3339, or duration before now like 2h")
+	until := flags.String("until", "", "end time in RFC3339, or duration before now like 30m")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if filter.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+
+	records, err := ReadAll(*file, filter)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	for i := range records {
+		if err := encoder.Encode(&records[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parse time in RFC3339 or duration before now, empty for zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("Illegal time: %s, expect RFC3339 or duration", value)
+}
@@ -0,0 +1,510 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/metrics"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// const values for the node config of flexvolume
+const (
+	// CONFIG_FILE is read by plugins on host, and by monitor under /host
+	CONFIG_FILE      = "/etc/kubernetes/flexvolume.conf"
+	HOST_CONFIG_FILE = "/host/etc/kubernetes/flexvolume.conf"
+	// ENV_PREFIX env override the key in file: FLEXVOLUME_LOG_LEVEL=debug
+	ENV_PREFIX = "FLEXVOLUME_"
+	// monitor check the file changes in the period
+	RELOAD_PERIOD = 10 * time.Second
+
+	TIMEOUT_SUFFIX = "_timeout"
+	MB_SIZE        = 1024 * 1024
+)
+
+// default values of config
+const (
+	DEFAULT_CALL_TIMEOUT          = 100 * time.Second
+	DEFAULT_LOG_DIR               = "/var/log/alicloud"
+	DEFAULT_LOG_MAX_SIZE_MB       = 2
+	DEFAULT_LOG_MAX_BACKUPS       = 5
+	DEFAULT_LOG_MAX_AGE_DAYS      = 7
+	DEFAULT_METRICS_FILE          = metrics.METRICS_FILE
+	DEFAULT_DISK_POLL_INTERVAL    = 2 * time.Second
+	DEFAULT_DISK_POLL_TIMES       = 15
+	DEFAULT_NAS_SLOT_TABLE        = 128
+	DEFAULT_MONITOR_INTERVAL      = 60 * time.Second
+	DEFAULT_METADATA_URL          = "http://100.100.100.200"
+	DEFAULT_METADATA_TIMEOUT      = 2 * time.Second
+	DEFAULT_METADATA_RETRIES      = 3
+	DEFAULT_METADATA_CACHE_TTL    = 10 * time.Minute
+	DEFAULT_REQUEST_TIMEOUT       = 60 * time.Second
+	PLATFORM_KUBERNETES           = "kubernetes"
+	PLATFORM_SWARM                = "swarm"
+	LEGACY_FIX_ORPHANED_POD_ISSUE = "fix_orphaned_pod"
+)
+
+// DEFAULT_CPFS_TUNABLES are set by lctl after cpfs is mounted
+var DEFAULT_CPFS_TUNABLES = []string{"osc.*.max_rpcs_in_flight=128", "osc.*.max_pages_per_rpc=256", "mdc.*.max_rpcs_in_flight=256", "mdc.*.max_mod_rpcs_in_flight=128"}
+
+// MONITOR_DRIVERS are the drivers installed on host and checked by monitor
+var MONITOR_DRIVERS = []string{"disk", "nas", "oss"}
+
+// ENDPOINT_SERVICES are the cloud services of endpoints
+var ENDPOINT_SERVICES = []string{"ecs", "nas", "oss", "sts"}
+
+// Config is the node config of flexvolume in yaml, the keys of the former "key: value" file are kept:
+//
+//	timeout: 100s
+//	disk_attach_timeout: 60s
+//	log_level: debug
+//	fix_orphaned_pod: true
+//
+// The former env variables ACS_PLATFORM, ECS_ENDPOINT, FIX_ISSUES and ACS_DISK/ACS_NAS/ACS_OSS
+// are the defaults of the keys, and FLEXVOLUME_<KEY> env variables override the file.
+type Config struct {
+	// Timeout is the deadline of plugin calls, "<driver>_timeout" and "<driver>_<verb>_timeout" keys are in Timeouts
+	Timeout  Duration            `yaml:"timeout"`
+	Timeouts map[string]Duration `yaml:"-"`
+
+	LogDir        string `yaml:"log_dir"`
+	LogFormat     string `yaml:"log_format"`
+	LogLevel      string `yaml:"log_level"`
+	LogSink       string `yaml:"log_sink"`
+	LogMaxSizeMB  int    `yaml:"log_max_size_mb"`
+	LogMaxBackups int    `yaml:"log_max_backups"`
+	LogMaxAgeDays int    `yaml:"log_max_age_days"`
+	LogCompress   bool   `yaml:"log_compress"`
+	MetricsFile   string `yaml:"metrics_file"`
+
+	Platform    string `yaml:"platform"`
+	EcsEndpoint string `yaml:"ecs_endpoint"`
+	// Endpoints override the endpoints of services: {ecs: ecs-vpc.cn-hangzhou.aliyuncs.com}
+	Endpoints map[string]string `yaml:"endpoints"`
+
+	DiskPollInterval    Duration `yaml:"disk_poll_interval"`
+	DiskPollTimes       int      `yaml:"disk_poll_times"`
+	NasSlotTableEntries int      `yaml:"nas_slot_table_entries"`
+	CpfsTunables        []string `yaml:"cpfs_tunables"`
+
+	MonitorDrivers  []string `yaml:"monitor_drivers"`
+	MonitorInterval Duration `yaml:"monitor_interval"`
+	FixOrphanedPod  bool     `yaml:"fix_orphaned_pod"`
+
+	// MetadataURL is the ecs metadata server, MetadataFile is the static metadata used before the server
+	MetadataURL      string   `yaml:"metadata_url"`
+	MetadataFile     string   `yaml:"metadata_file"`
+	MetadataTimeout  Duration `yaml:"metadata_timeout"`
+	MetadataRetries  int      `yaml:"metadata_retries"`
+	MetadataCacheTTL Duration `yaml:"metadata_cache_ttl"`
+
+	// the transport of cloud api and metadata, for the private cloud with own ca and proxy
+	CABundle       string   `yaml:"ca_bundle"`
+	HTTPProxy      string   `yaml:"http_proxy"`
+	HTTPSProxy     string   `yaml:"https_proxy"`
+	NoProxy        string   `yaml:"no_proxy"`
+	TLSMinVersion  string   `yaml:"tls_min_version"`
+	RequestTimeout Duration `yaml:"request_timeout"`
+}
+
+// Duration is written as 90s, 2m, or seconds
+type Duration time.Duration
+
+// UnmarshalYAML parse the duration or seconds
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	duration, err := ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalYAML write the duration string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// ParseDuration parse duration like 90s, 2m, or seconds
+func ParseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Default return the config without file, the former env variables are applied
+func Default() *Config {
+	cfg := &Config{
+		Timeout:             Duration(DEFAULT_CALL_TIMEOUT),
+		Timeouts:            map[string]Duration{},
+		LogDir:              DEFAULT_LOG_DIR,
+		LogMaxSizeMB:        DEFAULT_LOG_MAX_SIZE_MB,
+		LogMaxBackups:       DEFAULT_LOG_MAX_BACKUPS,
+		LogMaxAgeDays:       DEFAULT_LOG_MAX_AGE_DAYS,
+		LogCompress:         true,
+		MetricsFile:         DEFAULT_METRICS_FILE,
+		Platform:            PLATFORM_KUBERNETES,
+		Endpoints:           map[string]string{},
+		DiskPollInterval:    Duration(DEFAULT_DISK_POLL_INTERVAL),
+		DiskPollTimes:       DEFAULT_DISK_POLL_TIMES,
+		NasSlotTableEntries: DEFAULT_NAS_SLOT_TABLE,
+		CpfsTunables:        append([]string{}, DEFAULT_CPFS_TUNABLES...),
+		MonitorDrivers:      []string{},
+		MonitorInterval:     Duration(DEFAULT_MONITOR_INTERVAL),
+		MetadataURL:         DEFAULT_METADATA_URL,
+		MetadataTimeout:     Duration(DEFAULT_METADATA_TIMEOUT),
+		MetadataRetries:     DEFAULT_METADATA_RETRIES,
+		MetadataCacheTTL:    Duration(DEFAULT_METADATA_CACHE_TTL),
+		RequestTimeout:      Duration(DEFAULT_REQUEST_TIMEOUT),
+	}
+
+	if os.Getenv("ACS_PLATFORM") == PLATFORM_SWARM {
+		cfg.Platform = PLATFORM_SWARM
+	}
+	cfg.EcsEndpoint = os.Getenv("ECS_ENDPOINT")
+	for _, issue := range strings.Split(strings.ToLower(os.Getenv("FIX_ISSUES")), ",") {
+		if issue == LEGACY_FIX_ORPHANED_POD_ISSUE {
+			cfg.FixOrphanedPod = true
+		}
+	}
+	for _, driver := range MONITOR_DRIVERS {
+		if os.Getenv("ACS_"+strings.ToUpper(driver)) == "true" {
+			cfg.MonitorDrivers = append(cfg.MonitorDrivers, driver)
+		}
+	}
+	return cfg
+}
+
+// Load read the config file over the defaults, and override it by env.
+// The config is always usable: the illegal values are replaced by default and returned as error.
+func Load(file string) (*Config, error) {
+	raw, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		raw, err = nil, nil
+	}
+	if err != nil {
+		cfg, parseErr := Parse(nil)
+		if parseErr != nil {
+			return cfg, fmt.Errorf("%s; %s", err.Error(), parseErr.Error())
+		}
+		return cfg, err
+	}
+	return Parse(raw)
+}
+
+// Parse the yaml config over the defaults, and override it by env
+func Parse(raw []byte) (*Config, error) {
+	cfg := Default()
+	errs := []string{}
+
+	keys := map[string]interface{}{}
+	if err := yaml.Unmarshal(raw, &keys); err != nil {
+		legacy, ok := parseLegacy(raw)
+		if !ok {
+			errs = append(errs, "parse config error: "+err.Error())
+		}
+		keys = legacy
+	}
+	known := fieldKeys()
+	for key, value := range keys {
+		lowKey := strings.ToLower(key)
+		if _, ok := known[lowKey]; ok {
+			if err := cfg.set(lowKey, value); err != nil {
+				errs = append(errs, err.Error())
+			}
+		} else if strings.HasSuffix(lowKey, TIMEOUT_SUFFIX) {
+			if err := cfg.setTimeout(lowKey, fmt.Sprint(value)); err != nil {
+				errs = append(errs, err.Error())
+			}
+		} else {
+			errs = append(errs, "unknown key: "+key)
+		}
+	}
+	errs = append(errs, cfg.applyEnv()...)
+	errs = append(errs, cfg.validate()...)
+	return cfg, joinErrors(errs)
+}
+
+// parseLegacy parse the former "key:value" lines without the space after colon, not a yaml mapping,
+// eg: fix_orphaned_pod:true. The values are parsed as yaml scalars.
+func parseLegacy(raw []byte) (map[string]interface{}, bool) {
+	keys := map[string]interface{}{}
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return map[string]interface{}{}, false
+		}
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(strings.TrimSpace(parts[1])), &value); err != nil {
+			return map[string]interface{}{}, false
+		}
+		keys[key] = value
+	}
+	return keys, true
+}
+
+// set the field of key with the yaml value, the field is kept if illegal
+func (c *Config) set(key string, value interface{}) error {
+	field := reflect.ValueOf(c).Elem().FieldByName(fieldKeys()[key])
+	raw, err := yaml.Marshal(value)
+	if err == nil {
+		parsed := reflect.New(field.Type())
+		if err = yaml.Unmarshal(raw, parsed.Interface()); err == nil {
+			field.Set(parsed.Elem())
+			return nil
+		}
+	}
+	return fmt.Errorf("illegal %s: %v, %s", key, value, err.Error())
+}
+
+func (c *Config) setTimeout(key, value string) error {
+	timeout, err := ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("illegal %s: %s", key, value)
+	}
+	c.Timeouts[strings.TrimSuffix(key, TIMEOUT_SUFFIX)] = Duration(timeout)
+	return nil
+}
+
+// applyEnv override the keys by FLEXVOLUME_<KEY> env variables
+func (c *Config) applyEnv() []string {
+	errs := []string{}
+	known := fieldKeys()
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], ENV_PREFIX) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(parts[0], ENV_PREFIX))
+		var err error
+		if _, ok := known[key]; ok {
+			var value interface{}
+			if err = yaml.Unmarshal([]byte(parts[1]), &value); err == nil {
+				err = c.set(key, value)
+			}
+		} else if strings.HasSuffix(key, TIMEOUT_SUFFIX) {
+			err = c.setTimeout(key, parts[1])
+		} else {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, "env "+parts[0]+": "+err.Error())
+		}
+	}
+	return errs
+}
+
+// validate replace the illegal values by default
+func (c *Config) validate() []string {
+	errs := []string{}
+	def := Default()
+	check := func(ok bool, key string, value interface{}, reset func()) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf("illegal %s: %v, use default", key, value))
+			reset()
+		}
+	}
+
+	check(c.Timeout > 0, "timeout", time.Duration(c.Timeout), func() { c.Timeout = def.Timeout })
+	check(inList(strings.ToLower(c.LogFormat), "", "text", "json"), "log_format", c.LogFormat, func() { c.LogFormat = def.LogFormat })
+	_, err := log.ParseLevel(c.LogLevel)
+	check(c.LogLevel == "" || err == nil, "log_level", c.LogLevel, func() { c.LogLevel = def.LogLevel })
+	check(inList(strings.ToLower(c.LogSink), "", "stderr", "syslog"), "log_sink", c.LogSink, func() { c.LogSink = def.LogSink })
+	check(c.LogDir != "", "log_dir", c.LogDir, func() { c.LogDir = def.LogDir })
+	check(c.LogMaxSizeMB > 0, "log_max_size_mb", c.LogMaxSizeMB, func() { c.LogMaxSizeMB = def.LogMaxSizeMB })
+	check(c.LogMaxBackups >= 0, "log_max_backups", c.LogMaxBackups, func() { c.LogMaxBackups = def.LogMaxBackups })
+	check(c.LogMaxAgeDays >= 0, "log_max_age_days", c.LogMaxAgeDays, func() { c.LogMaxAgeDays = def.LogMaxAgeDays })
+	check(c.MetricsFile != "", "metrics_file", c.MetricsFile, func() { c.MetricsFile = def.MetricsFile })
+	check(inList(c.Platform, PLATFORM_KUBERNETES, PLATFORM_SWARM), "platform", c.Platform, func() { c.Platform = def.Platform })
+	for service := range c.Endpoints {
+		check(inList(service, ENDPOINT_SERVICES...), "endpoints", service, func() { c.Endpoints = def.Endpoints })
+	}
+	check(c.DiskPollInterval > 0, "disk_poll_interval", time.Duration(c.DiskPollInterval), func() { c.DiskPollInterval = def.DiskPollInterval })
+	check(c.DiskPollTimes > 0, "disk_poll_times", c.DiskPollTimes, func() { c.DiskPollTimes = def.DiskPollTimes })
+	check(c.NasSlotTableEntries >= 2 && c.NasSlotTableEntries <= 65536, "nas_slot_table_entries", c.NasSlotTableEntries, func() { c.NasSlotTableEntries = def.NasSlotTableEntries })
+	for _, tunable := range c.CpfsTunables {
+		check(strings.Count(tunable, "=") == 1, "cpfs_tunables", tunable, func() { c.CpfsTunables = def.CpfsTunables })
+	}
+	for _, driver := range c.MonitorDrivers {
+		check(inList(driver, MONITOR_DRIVERS...), "monitor_drivers", driver, func() { c.MonitorDrivers = def.MonitorDrivers })
+	}
+	check(time.Duration(c.MonitorInterval) >= time.Second, "monitor_interval", time.Duration(c.MonitorInterval), func() { c.MonitorInterval = def.MonitorInterval })
+	check(strings.HasPrefix(c.MetadataURL, "http://") || strings.HasPrefix(c.MetadataURL, "https://"), "metadata_url", c.MetadataURL, func() { c.MetadataURL = def.MetadataURL })
+	check(c.MetadataTimeout > 0, "metadata_timeout", time.Duration(c.MetadataTimeout), func() { c.MetadataTimeout = def.MetadataTimeout })
+	check(c.MetadataRetries >= 0, "metadata_retries", c.MetadataRetries, func() { c.MetadataRetries = def.MetadataRetries })
+	check(c.MetadataCacheTTL >= 0, "metadata_cache_ttl", time.Duration(c.MetadataCacheTTL), func() { c.MetadataCacheTTL = def.MetadataCacheTTL })
+	check(inList(c.TLSMinVersion, "", "1.0", "1.1", "1.2"), "tls_min_version", c.TLSMinVersion, func() { c.TLSMinVersion = def.TLSMinVersion })
+	check(c.RequestTimeout > 0, "request_timeout", time.Duration(c.RequestTimeout), func() { c.RequestTimeout = def.RequestTimeout })
+	for _, proxy := range []string{c.HTTPProxy, c.HTTPSProxy} {
+		_, err := url.Parse(proxy)
+		check(err == nil, "proxy", proxy, func() { c.HTTPProxy, c.HTTPSProxy = def.HTTPProxy, def.HTTPSProxy })
+	}
+	return errs
+}
+
+// CallTimeout return the deadline of the call, the most specific key wins:
+// <driver>_<verb>_timeout, <driver>_timeout, timeout.
+func (c *Config) CallTimeout(driver, verb string) time.Duration {
+	for _, key := range []string{driver + "_" + verb, driver} {
+		if timeout, ok := c.Timeouts[strings.ToLower(key)]; ok {
+			return time.Duration(timeout)
+		}
+	}
+	return time.Duration(c.Timeout)
+}
+
+// Monitored check the driver is checked by monitor
+func (c *Config) Monitored(driver string) bool {
+	return inList(driver, c.MonitorDrivers...)
+}
+
+// String print the config in yaml, the timeouts of driver and verb are flatten as in file
+func (c *Config) String() string {
+	raw, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	keys := []string{}
+	for key := range c.Timeouts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		raw = append(raw, []byte(key+TIMEOUT_SUFFIX+": "+time.Duration(c.Timeouts[key]).String()+"\n")...)
+	}
+	return string(raw)
+}
+
+// fieldKeys map the yaml keys to the fields of Config
+func fieldKeys() map[string]string {
+	keys := map[string]string{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("yaml"); key != "" && key != "-" {
+			keys[key] = t.Field(i).Name
+		}
+	}
+	return keys
+}
+
+func inList(value string, list ...string) bool {
+	for _, item := range list {
+		if value == item {
+			return true
+		}
+	}
+	return false
+}
+
+func joinErrors(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// the config of process, loaded from file once, and reloaded by Watch.
+// loadErr is the error of loading, logged by the caller after the log file is set.
+var (
+	mutex   sync.RWMutex
+	file    = CONFIG_FILE
+	current *Config
+	loadErr error
+)
+
+// SetFile change the config file of process, monitor read the host file
+func SetFile(path string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	file, current, loadErr = path, nil, nil
+}
+
+// Get return the config of process, it never logs as the plugin output is parsed by kubelet,
+// the errors of loading are returned by LoadError
+func Get() *Config {
+	mutex.RLock()
+	cfg := current
+	mutex.RUnlock()
+	if cfg != nil {
+		return cfg
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if current == nil {
+		current, loadErr = Load(file)
+	}
+	return current
+}
+
+// LoadError return the error of loading the config of process, nil if it is loaded without error
+func LoadError() error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if loadErr == nil {
+		return nil
+	}
+	return fmt.Errorf("Config %s: %s", file, loadErr.Error())
+}
+
+// Set replace the config of process, used by tests
+func Set(cfg *Config) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	current, loadErr = cfg, nil
+}
+
+// Watch reload the config when the file is changed, and call onChange with the new config.
+// It is run by the monitor, plugin processes load the config once for a call.
+func Watch(period time.Duration, onChange func(cfg *Config)) {
+	mutex.RLock()
+	path := file
+	mutex.RUnlock()
+	lastMod := modTime(path)
+	for {
+		time.Sleep(period)
+		mod := modTime(path)
+		if mod.Equal(lastMod) {
+			continue
+		}
+		lastMod = mod
+		cfg, err := Load(path)
+		if err != nil {
+			log.Warnf("Config %s: %s", path, err.Error())
+		}
+		log.Infof("Config %s is reloaded", path)
+		Set(cfg)
+		if onChange != nil {
+			onChange(cfg)
+		}
+	}
+}
+
+// modTime of the file, zero if not exist
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
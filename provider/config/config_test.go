@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallTimeout(t *testing.T) {
+	cfg, err := Parse([]byte("fix_orphaned_pod: true\ntimeout: 80\ndisk_timeout: 90s\ndisk_attach_timeout: 1m\nnas_timeout: illegal\n"))
+	if err == nil || !strings.Contains(err.Error(), "illegal nas_timeout") {
+		t.Errorf("expect illegal nas_timeout, got: %v", err)
+	}
+
+	cases := map[string]time.Duration{
+		"disk attach": time.Minute,
+		"disk detach": 90 * time.Second,
+		"nas mount":   80 * time.Second,
+		"oss mount":   80 * time.Second,
+	}
+	for call, expect := range cases {
+		parts := strings.Split(call, " ")
+		if timeout := cfg.CallTimeout(parts[0], parts[1]); timeout != expect {
+			t.Errorf("%s: expect timeout %s, got %s", call, expect, timeout)
+		}
+	}
+	if timeout := Default().CallTimeout("disk", "attach"); timeout != DEFAULT_CALL_TIMEOUT {
+		t.Errorf("expect default timeout, got %s", timeout)
+	}
+}
+
+func TestParse(t *testing.T) {
+	raw := `
+log_format: json
+log_max_backups: -1
+log_compress: false
+disk_poll_interval: 500ms
+nas_slot_table_entries: 1
+cpfs_tunables: ["osc.*.max_rpcs_in_flight=64"]
+monitor_drivers: [disk, nas]
+unknown_key: 1
+`
+	cfg, err := Parse([]byte(raw))
+	if err == nil {
+		t.Fatalf("expect errors of illegal values")
+	}
+	for _, msg := range []string{"illegal log_max_backups", "illegal nas_slot_table_entries", "unknown key: unknown_key"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expect error %q in: %s", msg, err.Error())
+		}
+	}
+	if cfg.LogFormat != "json" || cfg.LogCompress || time.Duration(cfg.DiskPollInterval) != 500*time.Millisecond {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if cfg.LogMaxBackups != DEFAULT_LOG_MAX_BACKUPS || cfg.NasSlotTableEntries != DEFAULT_NAS_SLOT_TABLE {
+		t.Errorf("illegal values should be default: %+v", cfg)
+	}
+	if len(cfg.CpfsTunables) != 1 || !cfg.Monitored("nas") || cfg.Monitored("oss") {
+		t.Errorf("unexpected lists: %+v", cfg)
+	}
+
+	// the former "key:value" lines of flexvolume.conf
+	cfg, err = Parse([]byte("fix_orphaned_pod:true\n"))
+	if err != nil || !cfg.FixOrphanedPod {
+		t.Errorf("expect legacy fix_orphaned_pod, got %+v, %v", cfg, err)
+	}
+	cfg, err = Parse([]byte("# legacy\nfix_orphaned_pod:false\ntimeout: 80s\n"))
+	if err != nil || cfg.FixOrphanedPod || cfg.Timeout != Duration(80*time.Second) {
+		t.Errorf("expect legacy lines mixed with yaml, got %+v, %v", cfg, err)
+	}
+
+	// the former file is not yaml, all keys are default
+	cfg, err = Parse([]byte("timeout 80\n: :\n"))
+	if err == nil || cfg.Timeout != Duration(DEFAULT_CALL_TIMEOUT) {
+		t.Errorf("expect parse error and default config, got %+v, %v", cfg, err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	envs := map[string]string{
+		"FIX_ISSUES":                     "fix_orphaned_pod",
+		"ACS_DISK":                       "true",
+		"FLEXVOLUME_LOG_LEVEL":           "debug",
+		"FLEXVOLUME_DISK_ATTACH_TIMEOUT": "30s",
+		"FLEXVOLUME_DISK_POLL_TIMES":     "zero",
+	}
+	for key, value := range envs {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	cfg, err := Parse([]byte("log_level: info\nfix_orphaned_pod: false\n"))
+	if err == nil || !strings.Contains(err.Error(), "FLEXVOLUME_DISK_POLL_TIMES") {
+		t.Errorf("expect illegal env, got: %v", err)
+	}
+	// the former env is default of file, and the FLEXVOLUME_ env override the file
+	if cfg.FixOrphanedPod || !cfg.Monitored("disk") || cfg.LogLevel != "debug" || cfg.DiskPollTimes != DEFAULT_DISK_POLL_TIMES {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if timeout := cfg.CallTimeout("disk", "attach"); timeout != 30*time.Second {
+		t.Errorf("expect timeout of env, got %s", timeout)
+	}
+	if cfg, _ := Parse(nil); !cfg.FixOrphanedPod {
+		t.Errorf("expect fix_orphaned_pod of FIX_ISSUES")
+	}
+}
+
+func TestShow(t *testing.T) {
+	file, err := ioutil.TempFile("", "flexvolume.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("timeout: 90s\ndisk_attach_timeout: 60\n")
+	file.Close()
+
+	out := &bytes.Buffer{}
+	if err := Show([]string{"--file", file.Name()}, out); err != nil {
+		t.Fatal(err)
+	}
+	// the printed config is loaded again as the same
+	cfg, err := Parse(out.Bytes())
+	if err != nil {
+		t.Fatalf("parse printed config %s: %s", out.String(), err)
+	}
+	if cfg.Timeout != Duration(90*time.Second) || cfg.CallTimeout("disk", "attach") != time.Minute {
+		t.Errorf("unexpected printed config: %s", out.String())
+	}
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Show run the config subcommand, print the effective config with the defaults and env applied,
+// and return the errors of the file: flexvolume config --file /etc/kubernetes/flexvolume.conf
+func Show(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	flags.SetOutput(out)
+	file := flags.String("file", CONFIG_FILE, "config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := Load(*file)
+	fmt.Fprint(out, cfg.String())
+	if err != nil {
+		return fmt.Errorf("config %s: %s", *file, err.Error())
+	}
+	return nil
+}
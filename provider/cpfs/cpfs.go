@@ -17,14 +17,19 @@ limitations under the License.
 package cpfs
 
 import (
+	"context"
 	"errors"
-	"fmt"
-	log "github.com/sirupsen/logrus"
-        "github.com/AliyunContainerService/flexvolume/provider/utils"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/locking"
+	"github.com/AliyunContainerService/flexvolume/provider/mountinfo"
+	"github.com/AliyunContainerService/flexvolume/provider/registry"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	log "github.com/sirupsen/logrus"
 )
 
 type CpfsOptions struct {
@@ -33,13 +38,57 @@ type CpfsOptions struct {
 	SubPath    string `json:"subPath"`
 	Options    string `json:"options"`
 	VolumeName string `json:"kubernetes.io/pvOrVolumeName"`
+	ReadWrite  string `json:"kubernetes.io/readwrite"`
+	readOnly   bool
 }
 
 const (
 	CPFS_TEMP_MNTPath = "/mnt/acs_mnt/k8s_cpfs/"
 )
 
+// cpfs is not attachable, and not support relabel/chown
+var Capabilities = utils.Capabilities{
+	Attach:          false,
+	SELinuxRelabel:  false,
+	SupportsMetrics: true,
+	FSGroup:         false,
+}
+
+// register cpfs driver
+func init() {
+	registry.Register(registry.Driver{
+		Name:         "cpfs",
+		Capabilities: Capabilities,
+		Options: []registry.Option{
+			{Name: "server", Required: true, Description: "cpfs mount target"},
+			{Name: "fileSystem", Required: true, Description: "cpfs filesystem name"},
+			{Name: "subPath", Default: "/", Description: "sub directory in cpfs, created if not exist"},
+			{Name: "options", Description: "extra lustre mount options"},
+			{Name: "kubernetes.io/readwrite", Default: utils.READ_WRITE, Description: "access mode passed by kubelet, ro mounts the lustre read only"},
+		},
+		New: func() registry.Plugin { return &CpfsPlugin{} },
+	})
+}
+
 type CpfsPlugin struct {
+	exec      utils.Executor
+	mountInfo string
+}
+
+// run the commands of plugin, default run on host
+func (p *CpfsPlugin) executor() utils.Executor {
+	if p.exec == nil {
+		p.exec = utils.NewExecutor()
+	}
+	return p.exec
+}
+
+// mountinfo file of plugin, default is the mount namespace of plugin
+func (p *CpfsPlugin) mountInfoFile() string {
+	if p.mountInfo == "" {
+		return mountinfo.SELF_MOUNTINFO
+	}
+	return p.mountInfo
 }
 
 func (p *CpfsPlugin) NewOptions() interface{} {
@@ -47,21 +96,23 @@ func (p *CpfsPlugin) NewOptions() interface{} {
 }
 
 // support volume metric
-func (p *CpfsPlugin) Init() utils.Result {
-	return utils.Succeed()
+func (p *CpfsPlugin) Init(ctx context.Context) utils.Result {
+	return utils.SucceedWithCapabilities(Capabilities)
 }
 
 // cpfs support mount and umount
-func (p *CpfsPlugin) Mount(opts interface{}, mountPath string) utils.Result {
+func (p *CpfsPlugin) Mount(ctx context.Context, opts interface{}, mountPath string) utils.Result {
 	log.Infof("Cpfs Volume Mount: %s", strings.Join(os.Args, ","))
 
 	opt := opts.(*CpfsOptions)
 	if err := p.checkOptions(opt); err != nil {
 		log.Errorf("Cpfs, Options is illegal: %s", err.Error())
-		utils.FinishError("Cpfs, Options is illegal: " + err.Error())
+		return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "Cpfs, Options is illegal: "+err.Error())
 	}
 
-	if utils.IsMounted(mountPath) {
+	if mounted, err := utils.CheckMountMode(p.mountInfoFile(), mountPath, opt.readOnly); err != nil {
+		return utils.FailWithError(err, utils.CODE_CPFS_MOUNT_FAILED, "Cpfs, Mount failed: "+err.Error())
+	} else if mounted {
 		log.Infof("Cpfs, Mount Path Already Mounted, path: %s", mountPath)
 		return utils.Result{Status: "Success"}
 	}
@@ -69,99 +120,119 @@ func (p *CpfsPlugin) Mount(opts interface{}, mountPath string) utils.Result {
 	// Create Mount Path
 	if err := utils.CreateDest(mountPath); err != nil {
 		log.Errorf("Cpfs, Mount error with create Path fail: %s", mountPath)
-		utils.FinishError("Cpfs, Mount error with create Path fail: " + mountPath)
+		return utils.FailWithCode(utils.CODE_MOUNT_FAILED, "Cpfs, Mount error with create Path fail: "+mountPath)
 	}
 
 	// Do mount
-	mntCmd := fmt.Sprintf("mount -t lustre %s:/%s%s %s", opt.Server, opt.FileSystem, opt.SubPath, mountPath)
-	if opt.Options != "" {
-		mntCmd = fmt.Sprintf("mount -t lustre -o %s %s:/%s%s %s", opt.Options, opt.Server, opt.FileSystem, opt.SubPath, mountPath)
+	mntArgs := []string{"-t", "lustre"}
+	mntOptions := opt.Options
+	if opt.readOnly {
+		mntOptions = strings.TrimPrefix(mntOptions+","+utils.READ_ONLY, ",")
 	}
-	_, err := utils.Run(mntCmd)
+	if mntOptions != "" {
+		mntArgs = append(mntArgs, "-o", mntOptions)
+	}
+	mntCmd := utils.NewCommand("mount", append(mntArgs, opt.Server+":/"+opt.FileSystem+opt.SubPath, mountPath)...)
+	_, _, err := p.executor().Execute(ctx, mntCmd)
 	if err != nil {
 		if opt.SubPath != "" && opt.SubPath != "/" && strings.Contains(err.Error(), "No such file or directory") {
-			p.createCpfsSubDir(opt)
-			if _, err := utils.Run(mntCmd); err != nil {
-				utils.FinishError("Cpfs, Mount Cpfs sub directory fail: " + err.Error())
+			if err := p.createCpfsSubDir(ctx, opt); err != nil {
+				return utils.FailWithCode(utils.CODE_CPFS_MOUNT_FAILED, "Cpfs, Create sub directory fail: "+err.Error())
+			}
+			if _, _, err := p.executor().Execute(ctx, mntCmd); err != nil {
+				return utils.FailWithCode(utils.CODE_CPFS_MOUNT_FAILED, "Cpfs, Mount Cpfs sub directory fail: "+err.Error())
 			}
 		} else {
-			utils.FinishError("Cpfs, Mount cpfs fail: " + err.Error())
+			return utils.FailWithCode(utils.CODE_CPFS_MOUNT_FAILED, "Cpfs, Mount cpfs fail: "+err.Error())
 		}
 	}
 
 	// check mount
-	if !utils.IsMounted(mountPath) {
-		utils.FinishError("Check mount fail after mount:" + mountPath + ", with Command: " + mntCmd)
+	if !utils.IsMounted(p.mountInfoFile(), mountPath) {
+		return utils.FailWithCode(utils.CODE_CPFS_MOUNT_FAILED, "Check mount fail after mount:"+mountPath+", with Command: "+mntCmd.String())
 	}
 
-	doCpfsConfig()
-	log.Infof("CPFS Mount success on: " + mountPath + ", with Command: " + mntCmd)
+	p.doCpfsConfig(ctx)
+	log.Infof("CPFS Mount success on: %s, with Command: %s", mountPath, mntCmd)
 	return utils.Result{Status: "Success"}
 }
 
-func doCpfsConfig() {
-	configCmd := fmt.Sprintf("lctl set_param osc.*.max_rpcs_in_flight=128;lctl set_param osc.*.max_pages_per_rpc=256;lctl set_param mdc.*.max_rpcs_in_flight=256;lctl set_param mdc.*.max_mod_rpcs_in_flight=128")
-	if _, err := utils.Run(configCmd); err != nil {
-		log.Errorf("Cpfs, doCpfsConfig fail with error: %s", err.Error())
+func (p *CpfsPlugin) doCpfsConfig(ctx context.Context) {
+	for _, param := range config.Get().CpfsTunables {
+		if _, err := utils.Run(ctx, p.executor(), "lctl", "set_param", param); err != nil {
+			log.Errorf("Cpfs, doCpfsConfig fail with error: %s", err.Error())
+		}
 	}
 }
 
 // 1. mount to /mnt/acs_mnt/k8s_cpfs/temp first
 // 2. run mkdir for sub directory
 // 3. umount the tmep directory
-func (p *CpfsPlugin) createCpfsSubDir(opt *CpfsOptions) {
+func (p *CpfsPlugin) createCpfsSubDir(ctx context.Context, opt *CpfsOptions) error {
+	// the temp path is shared by the pods of volume, wait for the other creating
+	lock, err := locking.Node(ctx, "cpfs-subdir-"+opt.VolumeName)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	// step 1: create mount path
 	rootTempPath := filepath.Join(CPFS_TEMP_MNTPath, opt.VolumeName)
 	if err := utils.CreateDest(rootTempPath); err != nil {
-		utils.FinishError("Create Cpfs temp Directory err: " + err.Error())
+		return errors.New("Create Cpfs temp Directory err: " + err.Error())
 	}
-	if utils.IsMounted(rootTempPath) {
-		utils.Umount(rootTempPath)
+	if utils.IsMounted(p.mountInfoFile(), rootTempPath) {
+		utils.Umount(ctx, p.executor(), rootTempPath)
 	}
 
 	// step 2: do mount
-	mntCmd := fmt.Sprintf("mount -t lustre %s:/%s %s", opt.Server, opt.FileSystem, rootTempPath)
-	_, err := utils.Run(mntCmd)
+	_, err = utils.Run(ctx, p.executor(), "mount", "-t", "lustre", opt.Server+":/"+opt.FileSystem, rootTempPath)
 	if err != nil {
-		utils.FinishError("CreateCpfsSubDir, Mount to temp directory fail: " + err.Error())
+		return errors.New("CreateCpfsSubDir, Mount to temp directory fail: " + err.Error())
 	}
+
+	// step 3: umount after create, even if mkdir failed
+	defer utils.Umount(ctx, p.executor(), rootTempPath)
+
 	subPath := path.Join(rootTempPath, opt.SubPath)
 	if err := utils.CreateDest(subPath); err != nil {
-		utils.FinishError("CreateCpfsSubDir, Create Sub Directory err: " + err.Error())
+		return errors.New("CreateCpfsSubDir, Create Sub Directory err: " + err.Error())
 	}
-
-	// step 3: umount after create
-	utils.Umount(rootTempPath)
 	log.Infof("Create Sub Directory success for volume: %s, subpath: %s", opt.VolumeName, filepath.Join(opt.FileSystem, opt.SubPath))
+	return nil
 }
 
-func (p *CpfsPlugin) Unmount(mountPoint string) utils.Result {
+func (p *CpfsPlugin) Unmount(ctx context.Context, mountPoint string) utils.Result {
 	log.Infof("Cpfs Volume Umount: %s", strings.Join(os.Args, ","))
 
-	if !utils.IsMounted(mountPoint) {
+	if !utils.IsMounted(p.mountInfoFile(), mountPoint) {
 		log.Infof("Path not mounted, skipped: %s", mountPoint)
 		return utils.Succeed()
 	}
 
-	umntCmd := fmt.Sprintf("umount %s", mountPoint)
-	if _, err := utils.Run(umntCmd); err != nil {
-		utils.FinishError("Cpfs, Umount Cpfs Fail: " + err.Error())
+	umntCmd := utils.NewCommand("umount", mountPoint)
+	if _, _, err := p.executor().Execute(ctx, umntCmd); err != nil {
+		return utils.FailWithCode(utils.CODE_UNMOUNT_FAILED, "Cpfs, Umount Cpfs Fail: "+err.Error())
 	}
 
 	log.Infof("Umount Cpfs Successful: %s, with command: %s", mountPoint, umntCmd)
 	return utils.Succeed()
 }
 
-func (p *CpfsPlugin) Attach(opts interface{}, nodeName string) utils.Result {
+func (p *CpfsPlugin) Attach(ctx context.Context, opts interface{}, nodeName string) utils.Result {
+	return utils.NotSupport()
+}
+
+func (p *CpfsPlugin) Isattached(ctx context.Context, opts interface{}, nodeName string) utils.Result {
 	return utils.NotSupport()
 }
 
-func (p *CpfsPlugin) Detach(device string, nodeName string) utils.Result {
+func (p *CpfsPlugin) Detach(ctx context.Context, device string, nodeName string) utils.Result {
 	return utils.NotSupport()
 }
 
 // Support
-func (p *CpfsPlugin) Getvolumename(opts interface{}) utils.Result {
+func (p *CpfsPlugin) Getvolumename(ctx context.Context, opts interface{}) utils.Result {
 	opt := opts.(*CpfsOptions)
 	return utils.Result{
 		Status:     "Success",
@@ -170,12 +241,17 @@ func (p *CpfsPlugin) Getvolumename(opts interface{}) utils.Result {
 }
 
 // Not Support
-func (p *CpfsPlugin) Waitforattach(devicePath string, opts interface{}) utils.Result {
+func (p *CpfsPlugin) Waitforattach(ctx context.Context, devicePath string, opts interface{}) utils.Result {
+	return utils.NotSupport()
+}
+
+// Not Support
+func (p *CpfsPlugin) Mountdevice(ctx context.Context, mountPath, devicePath string, opts interface{}) utils.Result {
 	return utils.NotSupport()
 }
 
 // Not Support
-func (p *CpfsPlugin) Mountdevice(mountPath string, opts interface{}) utils.Result {
+func (p *CpfsPlugin) Unmountdevice(ctx context.Context, mountPath string) utils.Result {
 	return utils.NotSupport()
 }
 
@@ -200,16 +276,21 @@ func (p *CpfsPlugin) checkOptions(opt *CpfsOptions) error {
 	}
 
 	opt.Options = strings.TrimSpace(opt.Options)
+
+	readOnly, err := utils.IsReadOnly(opt.ReadWrite)
+	if err != nil {
+		return errors.New("CPFS: " + err.Error())
+	}
+	opt.readOnly = readOnly
 	return nil
 }
 
-// Not Support
-func (p *CpfsPlugin) ExpandVolume(opt interface{}, devicePath, newSize, oldSize string) utils.Result {
-	return utils.NotSupport()
+// cpfs capacity is not limited by volume, nothing to do
+func (p *CpfsPlugin) ExpandVolume(ctx context.Context, opt interface{}, devicePath, newSize, oldSize string) utils.Result {
+	return utils.Succeed()
 }
 
-
-// Not Support
-func (p *CpfsPlugin) ExpandFS(opt interface{}, devicePath, deviceMountPath, newSize, oldSize string) utils.Result {
-	return utils.NotSupport()
+// cpfs capacity is not limited by volume, nothing to do
+func (p *CpfsPlugin) ExpandFS(ctx context.Context, opt interface{}, devicePath, deviceMountPath, newSize, oldSize string) utils.Result {
+	return utils.Succeed()
 }
@@ -0,0 +1,47 @@
+package cpfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+func TestMountArgs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cpfs")
+	defer os.RemoveAll(dir)
+	mountInfo := filepath.Join(dir, "mountinfo")
+	ioutil.WriteFile(mountInfo, nil, 0644)
+
+	// the mount is recorded in mountinfo like the kernel
+	executor := &utils.FakeExecutor{Handler: func(cmd utils.Command) (string, string, error) {
+		if cmd.Name == "mount" {
+			target := strings.Replace(cmd.Args[len(cmd.Args)-1], " ", "\\040", -1)
+			ioutil.WriteFile(mountInfo, []byte("40 22 0:45 / "+target+" ro shared:20 - lustre cpfs ro\n"), 0644)
+		}
+		return "", "", nil
+	}}
+	plugin := &CpfsPlugin{exec: executor, mountInfo: mountInfo}
+
+	// the hostile values reach mount as single arguments, no shell is involved
+	mountPath := filepath.Join(dir, "pods", "vol $(id)")
+	opt := &CpfsOptions{Server: "cpfs.example.com@tcp", FileSystem: "fs", SubPath: "a; rm -rf /", Options: "flock,$(id)", ReadWrite: utils.READ_ONLY}
+	if result := plugin.Mount(context.Background(), opt, mountPath); result.Status != "Success" {
+		t.Fatalf("mount failed: %s", result.Message)
+	}
+
+	mount := executor.Commands[0]
+	expect := []string{"-t", "lustre", "-o", "flock,$(id),ro", "cpfs.example.com@tcp:/fs/a; rm -rf /", mountPath}
+	if mount.Name != "mount" || strings.Join(mount.Args, "\x00") != strings.Join(expect, "\x00") {
+		t.Errorf("mount command: %q %q, expect: %q", mount.Name, mount.Args, expect)
+	}
+	for _, cmd := range executor.Commands[1:] {
+		if cmd.Name != "lctl" {
+			t.Errorf("unexpected command: %s", cmd)
+		}
+	}
+}
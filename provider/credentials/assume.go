@@ -0,0 +1,156 @@
+package credentials
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denverdino/aliyungo/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values of the assumed role
+const (
+	STS_API_VERSION = "2015-04-01"
+	// the assumed token is valid for one hour, and refreshed STS_REFRESH_BEFORE it expires
+	ASSUME_ROLE_DURATION = time.Hour
+	DEFAULT_SESSION_NAME = "alicloud-flexvolume"
+	ASSUMED_CACHE_PREFIX = "assumed-"
+)
+
+var (
+	roleArnPattern     = regexp.MustCompile(`^acs:ram::[0-9]+:role/[\w.@-]+$`)
+	sessionNamePattern = regexp.MustCompile(`^[\w.@-]{2,64}$`)
+)
+
+// Refresher renew the assumed tokens saved for the consumer not calling sts itself, eg: the passwd dir of ossfs.
+// The files are under root, the monitor refresh the files of host under /host.
+type Refresher func(ctx context.Context, root string)
+
+var (
+	refreshMutex sync.RWMutex
+	refreshers   = map[string]Refresher{}
+)
+
+// RegisterRefresher set the refresher of driver, called in init() of the driver package
+func RegisterRefresher(driver string, refresher Refresher) {
+	refreshMutex.Lock()
+	defer refreshMutex.Unlock()
+	refreshers[driver] = refresher
+}
+
+// Refresh call the refreshers of drivers, called by monitor in every loop
+func Refresh(ctx context.Context, root string) {
+	refreshMutex.RLock()
+	defer refreshMutex.RUnlock()
+	for _, refresher := range refreshers {
+		refresher(ctx, root)
+	}
+}
+
+// Role is the ram role of another account, the volume in it is operated by the assumed credential
+type Role struct {
+	Arn         string `json:"roleArn"`
+	SessionName string `json:"roleSessionName,omitempty"`
+}
+
+// Session return the session name, default DEFAULT_SESSION_NAME
+func (r Role) Session() string {
+	if r.SessionName == "" {
+		return DEFAULT_SESSION_NAME
+	}
+	return r.SessionName
+}
+
+// Validate check the arn is like acs:ram::<account id>:role/<role name>, and the session name is accepted by sts
+func (r Role) Validate() error {
+	if !roleArnPattern.MatchString(r.Arn) {
+		return fmt.Errorf("illegal roleArn %q, expect acs:ram::<account id>:role/<role name>", r.Arn)
+	}
+	if !sessionNamePattern.MatchString(r.Session()) {
+		return fmt.Errorf("illegal roleSessionName %q, expect 2-64 letters, digits or .@-_", r.SessionName)
+	}
+	return nil
+}
+
+// assumeRoleArgs and assumeRoleResponse are the AssumeRole api of sts
+type assumeRoleArgs struct {
+	RoleArn         string
+	RoleSessionName string
+	DurationSeconds int
+}
+
+// baseIdentity identify the base credential of the assumed token: the access key,
+// or the source for the sts token of node as its access key is changed by refresh
+func baseIdentity(base *Credential) string {
+	if base.SecurityToken != "" {
+		return "sts:" + strings.TrimSuffix(base.Source, CACHED_SUFFIX)
+	}
+	return "ak:" + base.AccessKeyID
+}
+
+type assumeRoleResponse struct {
+	common.Response
+	Credentials struct {
+		AccessKeyId     string
+		AccessKeySecret string
+		SecurityToken   string
+		Expiration      time.Time
+	}
+}
+
+// AssumeRole return the sts token of role, assumed by the base credential through the sts endpoint.
+// The token is cached on disk per base and role until it is near expiration, like the token of instance ram role,
+// so the role assumed by the access key of a volume is never used by the volumes of other access keys.
+func AssumeRole(ctx context.Context, base *Credential, role Role, endpoint string) (*Credential, error) {
+	if err := role.Validate(); err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(baseIdentity(base) + "/" + role.Arn + "/" + role.Session()))
+	file := filepath.Join(stsCacheDir, ASSUMED_CACHE_PREFIX+hex.EncodeToString(sum[:])+".json")
+	source := "role " + role.Arn + " assumed by " + base.Source
+	if cred := readToken(file); cred != nil && time.Until(cred.Expiration) > STS_REFRESH_BEFORE {
+		cred.Source = source + CACHED_SUFFIX
+		return cred, nil
+	}
+
+	client := &common.Client{}
+	client.Init(endpoint, STS_API_VERSION, base.AccessKeyID, base.AccessKeySecret)
+	client.SetSecurityToken(base.SecurityToken)
+	resp := &assumeRoleResponse{}
+	args := &assumeRoleArgs{RoleArn: role.Arn, RoleSessionName: role.Session(), DurationSeconds: int(ASSUME_ROLE_DURATION / time.Second)}
+	// the sdk client has no context, the call is abandoned when the deadline exceeded
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Invoke("AssumeRole", args, resp)
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("assume role %s error: sts not responded: %s", role.Arn, ctx.Err())
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("assume role %s error: %s", role.Arn, err.Error())
+		}
+	}
+	if resp.Credentials.AccessKeyId == "" || resp.Credentials.SecurityToken == "" {
+		return nil, fmt.Errorf("assume role %s error: no sts token in response %s", role.Arn, resp.RequestId)
+	}
+
+	cred := &Credential{
+		AccessKeyID:     resp.Credentials.AccessKeyId,
+		AccessKeySecret: resp.Credentials.AccessKeySecret,
+		SecurityToken:   resp.Credentials.SecurityToken,
+		Expiration:      resp.Credentials.Expiration,
+	}
+	if err := writeToken(stsCacheDir, file, cred); err != nil {
+		log.Warnf("Credentials, cache the token of role %s error: %s", role.Arn, err.Error())
+	}
+	cred.Source = source
+	return cred, nil
+}
@@ -0,0 +1,212 @@
+package credentials
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// const values of the credential files
+const (
+	// AK_DIR has the global files akId/akSecret, and the files of driver, eg: diskAkId/diskAkSecret
+	AK_DIR               = "/etc/.volumeak"
+	USER_AKID            = AK_DIR + "/akId"
+	USER_AKSECRET        = AK_DIR + "/akSecret"
+	ENCODED_CLOUD_CONFIG = "/etc/kubernetes/cloud-config.alicloud"
+	CLOUD_CONFIG         = "/etc/kubernetes/cloud-config"
+)
+
+// Credential is the access key used by the cloud api, the sts token has security token and expiration
+type Credential struct {
+	AccessKeyID     string    `json:"accessKeyId"`
+	AccessKeySecret string    `json:"accessKeySecret"`
+	SecurityToken   string    `json:"securityToken,omitempty"`
+	Expiration      time.Time `json:"expiration,omitempty"`
+	// Source is the name of the source in chain
+	Source string `json:"-"`
+}
+
+// String describe the credential with the secrets redacted
+func (c *Credential) String() string {
+	desc := fmt.Sprintf("accessKeyId: %s, accessKeySecret: %s", Redact(c.AccessKeyID), Redact(c.AccessKeySecret))
+	if c.SecurityToken != "" {
+		desc += fmt.Sprintf(", securityToken: %s, expiration: %s", Redact(c.SecurityToken), c.Expiration.Format(time.RFC3339))
+	}
+	return desc
+}
+
+// Redact keep the head and tail of the access key id to identify it, and hide the others
+func Redact(value string) string {
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:4] + strings.Repeat("*", len(value)-8) + value[len(value)-4:]
+}
+
+// Source provide the credential in chain
+type Source interface {
+	// Name describe the source in logs and the credentials subcommand
+	Name() string
+	// Retrieve return nil if the source is not configured, and the error if it is configured but broken
+	Retrieve(ctx context.Context) (*Credential, error)
+}
+
+// Options select the sources of chain for the call
+type Options struct {
+	// Root is the root of files, the daemon read the files of host under /host
+	Root string
+	// Driver select the files of driver, eg: /etc/.volumeak/diskAkId
+	Driver string
+	// AccessKeyID and AccessKeySecret are the per-volume secret
+	AccessKeyID     string
+	AccessKeySecret string
+	// NoToken skip the ram role for the consumer not supporting sts token, eg: the passwd file of ossfs
+	NoToken bool
+}
+
+// Chain return the sources in order: per-volume secret, driver file, global file, cloud-config, ram role
+func Chain(opts Options) []Source {
+	root := func(file string) string {
+		return filepath.Join("/", opts.Root, file)
+	}
+	sources := []Source{&staticSource{name: "volume secret", id: opts.AccessKeyID, secret: opts.AccessKeySecret}}
+	if opts.Driver != "" {
+		sources = append(sources, &fileSource{idFile: root(filepath.Join(AK_DIR, opts.Driver+"AkId")), secretFile: root(filepath.Join(AK_DIR, opts.Driver+"AkSecret"))})
+	}
+	sources = append(sources,
+		&fileSource{idFile: root(USER_AKID), secretFile: root(USER_AKSECRET)},
+		&cloudConfigSource{file: root(ENCODED_CLOUD_CONFIG), encoded: true},
+		&cloudConfigSource{file: root(CLOUD_CONFIG)},
+	)
+	if !opts.NoToken {
+		sources = append(sources, &ramRoleSource{cacheDir: stsCacheDir})
+	}
+	return sources
+}
+
+// Resolve return the credential of the first configured source in chain,
+// a broken source fail the chain instead of falling back to the next one silently.
+func Resolve(ctx context.Context, opts Options) (*Credential, error) {
+	names := []string{}
+	for _, source := range Chain(opts) {
+		cred, err := source.Retrieve(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("credential of %s error: %s", source.Name(), err.Error())
+		}
+		if cred != nil {
+			if cred.Source == "" {
+				cred.Source = source.Name()
+			}
+			log.Debugf("Credentials, use %s, %s", cred.Source, cred)
+			return cred, nil
+		}
+		names = append(names, source.Name())
+	}
+	return nil, fmt.Errorf("no credential is configured in: %s", strings.Join(names, ", "))
+}
+
+// staticSource is the access key given by the caller
+type staticSource struct {
+	name   string
+	id     string
+	secret string
+}
+
+func (s *staticSource) Name() string {
+	return s.name
+}
+
+func (s *staticSource) Retrieve(ctx context.Context) (*Credential, error) {
+	if s.id == "" || s.secret == "" {
+		return nil, nil
+	}
+	return &Credential{AccessKeyID: s.id, AccessKeySecret: s.secret}, nil
+}
+
+// fileSource is the access key in the files of id and secret
+type fileSource struct {
+	idFile     string
+	secretFile string
+}
+
+func (s *fileSource) Name() string {
+	return "file " + s.idFile
+}
+
+func (s *fileSource) Retrieve(ctx context.Context) (*Credential, error) {
+	if !exists(s.idFile) || !exists(s.secretFile) {
+		return nil, nil
+	}
+	id, err := ioutil.ReadFile(s.idFile)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ioutil.ReadFile(s.secretFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{AccessKeyID: strings.TrimSpace(string(id)), AccessKeySecret: strings.TrimSpace(string(secret))}, nil
+}
+
+// cloudConfig is the config of cloud controller manager, the ak is encoded in cloud-config.alicloud
+type cloudConfig struct {
+	Global struct {
+		KubernetesClusterTag string
+		AccessKeyID          string `json:"accessKeyID"`
+		AccessKeySecret      string `json:"accessKeySecret"`
+		Region               string `json:"region"`
+	}
+}
+
+// cloudConfigSource is the access key of cloud controller manager
+type cloudConfigSource struct {
+	file    string
+	encoded bool
+}
+
+func (s *cloudConfigSource) Name() string {
+	return "cloud-config " + s.file
+}
+
+func (s *cloudConfigSource) Retrieve(ctx context.Context) (*Credential, error) {
+	if !exists(s.file) {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &cloudConfig{}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse json error: %s", err.Error())
+	}
+	id, secret := cfg.Global.AccessKeyID, cfg.Global.AccessKeySecret
+	if id == "" || secret == "" {
+		return nil, nil
+	}
+	if s.encoded {
+		rawID, err := b64.StdEncoding.DecodeString(id)
+		if err != nil {
+			return nil, fmt.Errorf("decode accessKeyID error: %s", err.Error())
+		}
+		rawSecret, err := b64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, fmt.Errorf("decode accessKeySecret error: %s", err.Error())
+		}
+		id, secret = string(rawID), string(rawSecret)
+	}
+	return &Credential{AccessKeyID: strings.TrimSpace(id), AccessKeySecret: strings.TrimSpace(secret)}, nil
+}
+
+func exists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
@@ -0,0 +1,224 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/metadata"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for file, content := range files {
+		file = filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	ctx := context.Background()
+	opts := Options{Root: root, Driver: "disk", NoToken: true}
+
+	if _, err := Resolve(ctx, opts); err == nil || !strings.Contains(err.Error(), "no credential") {
+		t.Errorf("expect no credential, got %v", err)
+	}
+
+	// the encoded cloud-config is used before the plain one
+	writeFiles(t, root, map[string]string{
+		CLOUD_CONFIG:         `{"Global": {"accessKeyID": "plain-id", "accessKeySecret": "plain-secret"}}`,
+		ENCODED_CLOUD_CONFIG: `{"Global": {"accessKeyID": "ZW5jb2RlZC1pZA==", "accessKeySecret": "c2VjcmV0"}}`,
+	})
+	if cred, err := Resolve(ctx, opts); err != nil || cred.AccessKeyID != "encoded-id" || cred.Source != "cloud-config "+filepath.Join(root, ENCODED_CLOUD_CONFIG) {
+		t.Errorf("expect encoded cloud-config, got %v, %v", cred, err)
+	}
+
+	// the files are used before cloud-config, the driver file first
+	writeFiles(t, root, map[string]string{USER_AKID: "global-id\n", USER_AKSECRET: "global-secret\n"})
+	if cred, err := Resolve(ctx, opts); err != nil || cred.AccessKeyID != "global-id" || cred.AccessKeySecret != "global-secret" {
+		t.Errorf("expect global file, got %v, %v", cred, err)
+	}
+	writeFiles(t, root, map[string]string{AK_DIR + "/diskAkId": "disk-id", AK_DIR + "/diskAkSecret": "disk-secret"})
+	if cred, err := Resolve(ctx, opts); err != nil || cred.AccessKeyID != "disk-id" {
+		t.Errorf("expect driver file, got %v, %v", cred, err)
+	}
+	nasOpts := opts
+	nasOpts.Driver = "nas"
+	if cred, err := Resolve(ctx, nasOpts); err != nil || cred.AccessKeyID != "global-id" {
+		t.Errorf("expect global file for other driver, got %v, %v", cred, err)
+	}
+
+	// the volume secret wins
+	opts.AccessKeyID, opts.AccessKeySecret = "volume-id", "volume-secret"
+	if cred, err := Resolve(ctx, opts); err != nil || cred.AccessKeyID != "volume-id" || cred.Source != "volume secret" {
+		t.Errorf("expect volume secret, got %v, %v", cred, err)
+	}
+
+	// the broken source fail the chain
+	writeFiles(t, root, map[string]string{ENCODED_CLOUD_CONFIG: "not json"})
+	if _, err := Resolve(ctx, nasOpts); err != nil {
+		t.Errorf("cloud-config is not used after the global file: %v", err)
+	}
+	os.Remove(filepath.Join(root, USER_AKID))
+	if _, err := Resolve(ctx, nasOpts); err == nil || !strings.Contains(err.Error(), "parse json error") {
+		t.Errorf("expect error of broken cloud-config, got %v", err)
+	}
+}
+
+func TestRamRole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetCacheDir(dir)
+	defer SetCacheDir(STS_CACHE_DIR)
+
+	requests := int32(0)
+	expiration := time.Now().Add(time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if strings.HasSuffix(r.URL.Path, metadata.RAM_ROLE_TAG) {
+			w.Write([]byte("role"))
+			return
+		}
+		fmt.Fprintf(w, `{"AccessKeyId":"STS.id-%d","AccessKeySecret":"secret","SecurityToken":"token","Expiration":"%s","Code":"Success"}`,
+			n, expiration.UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+	metadata.Set(metadata.NewHTTP(server.URL, time.Second, 0))
+	defer metadata.Set(nil)
+
+	// the token is cached
+	for i := 0; i < 2; i++ {
+		cred, err := Resolve(context.Background(), Options{Root: dir})
+		if err != nil || cred.AccessKeyID != "STS.id-2" || cred.SecurityToken != "token" {
+			t.Fatalf("expect sts token, got %v, %v", cred, err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expect token cached, got %d requests", requests)
+	}
+
+	// the token is refreshed before expiration
+	expiration = time.Now().Add(STS_REFRESH_BEFORE + time.Hour)
+	cache := filepath.Join(dir, STS_CACHE_FILE)
+	raw, _ := ioutil.ReadFile(cache)
+	soon := time.Now().Add(STS_REFRESH_BEFORE / 2).UTC().Format(time.RFC3339)
+	raw = []byte(strings.Replace(string(raw), `"expiration":"`, `"expiration":"`+soon+`","old":"`, 1))
+	ioutil.WriteFile(cache, raw, 0600)
+	if cred, err := Resolve(context.Background(), Options{Root: dir}); err != nil || cred.AccessKeyID != "STS.id-4" {
+		t.Errorf("expect refreshed token, got %v, %v", cred, err)
+	}
+
+	// the secrets are redacted
+	out := &bytes.Buffer{}
+	if err := Show([]string{"--root", dir, "--driver", "disk"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "ram role (cached): used") || strings.Contains(out.String(), "STS.id") || strings.Contains(out.String(), "secret,") || strings.Contains(out.String(), ": token") {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetCacheDir(dir)
+	defer SetCacheDir(STS_CACHE_DIR)
+
+	// the access keys of node and volume with their sts token
+	bases := map[string]string{"node-id": "node-token", "volume-a": "", "volume-b": ""}
+	requests := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		query := r.URL.Query()
+		token, ok := bases[query.Get("AccessKeyId")]
+		if query.Get("Action") != "AssumeRole" || !ok || query.Get("SecurityToken") != token {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"Code":"InvalidParameter","Message":"unexpected query %s"}`, r.URL.RawQuery)
+			return
+		}
+		if query.Get("RoleArn") != "acs:ram::1234:role/storage" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"Code":"NoPermission","Message":"You are not authorized to do this action."}`)
+			return
+		}
+		fmt.Fprintf(w, `{"RequestId":"req","Credentials":{"AccessKeyId":"STS.assumed-%d","AccessKeySecret":"secret","SecurityToken":"token-%s","Expiration":"%s"}}`,
+			n, query.Get("RoleSessionName"), time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	base := &Credential{AccessKeyID: "node-id", AccessKeySecret: "node-secret", SecurityToken: "node-token", Source: "ram role"}
+	role := Role{Arn: "acs:ram::1234:role/storage"}
+
+	// the token is cached per role and session
+	for i := 0; i < 2; i++ {
+		cred, err := AssumeRole(ctx, base, role, server.URL)
+		if err != nil || cred.AccessKeyID != "STS.assumed-1" || cred.SecurityToken != "token-"+DEFAULT_SESSION_NAME {
+			t.Fatalf("expect assumed token, got %v, %v", cred, err)
+		}
+	}
+	role.SessionName = "team-a"
+	if cred, err := AssumeRole(ctx, base, role, server.URL); err != nil || cred.SecurityToken != "token-team-a" {
+		t.Errorf("expect token of session, got %v, %v", cred, err)
+	}
+	if requests != 2 {
+		t.Errorf("expect token cached, got %d requests", requests)
+	}
+
+	// the token is cached per base, the rotated token of node keep the cache
+	rotated := &Credential{AccessKeyID: "node-id-2", SecurityToken: "node-token-2", Source: "ram role (cached)"}
+	if cred, err := AssumeRole(ctx, rotated, role, server.URL); err != nil || cred.SecurityToken != "token-team-a" || requests != 2 {
+		t.Errorf("expect token cached for the node, got %v, %v, %d requests", cred, err, requests)
+	}
+	for i, id := range []string{"volume-a", "volume-b"} {
+		volume := &Credential{AccessKeyID: id, AccessKeySecret: "secret", Source: "volume secret"}
+		cred, err := AssumeRole(ctx, volume, role, server.URL)
+		if err != nil || cred.AccessKeyID != fmt.Sprintf("STS.assumed-%d", i+3) {
+			t.Errorf("expect token assumed by %s, got %v, %v", id, cred, err)
+		}
+	}
+
+	// the role is validated before calling sts, and the error of sts is returned
+	for _, illegal := range []Role{{Arn: "storage"}, {Arn: role.Arn, SessionName: "a b"}} {
+		if _, err := AssumeRole(ctx, base, illegal, server.URL); err == nil || !strings.Contains(err.Error(), "illegal") {
+			t.Errorf("expect illegal role %+v, got %v", illegal, err)
+		}
+	}
+	if _, err := AssumeRole(ctx, base, Role{Arn: "acs:ram::1234:role/other"}, server.URL); err == nil || !strings.Contains(err.Error(), "NoPermission") {
+		t.Errorf("expect sts error, got %v", err)
+	}
+
+	// the call deadline is carried into sts
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer slow.Close()
+	deadline, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := AssumeRole(deadline, base, Role{Arn: "acs:ram::1234:role/slow"}, slow.URL); err == nil || !strings.Contains(err.Error(), "not responded") {
+		t.Errorf("expect sts deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package credentials
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Show run the credentials subcommand, print the sources in chain and which one is used,
+// with the secrets redacted: flexvolume credentials --driver disk
+func Show(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("credentials", flag.ContinueOnError)
+	flags.SetOutput(out)
+	opts := Options{}
+	flags.StringVar(&opts.Driver, "driver", "", "driver of the credential files, eg: disk")
+	flags.StringVar(&opts.Root, "root", "", "root of the credential files, eg: /host in the monitor container")
+	flags.BoolVar(&opts.NoToken, "no-token", false, "skip the ram role as ossfs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var used *Credential
+	for _, source := range Chain(opts) {
+		if used != nil {
+			fmt.Fprintf(out, "%s: skipped\n", source.Name())
+			continue
+		}
+		cred, err := source.Retrieve(context.Background())
+		switch {
+		case err != nil:
+			fmt.Fprintf(out, "%s: error: %s\n", source.Name(), err.Error())
+			return fmt.Errorf("credential of %s error: %s", source.Name(), err.Error())
+		case cred == nil:
+			fmt.Fprintf(out, "%s: not configured\n", source.Name())
+		default:
+			name := source.Name()
+			if cred.Source != "" {
+				name = cred.Source
+			}
+			fmt.Fprintf(out, "%s: used, %s\n", name, cred)
+			used = cred
+		}
+	}
+	if used == nil {
+		return fmt.Errorf("no credential is configured")
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/metadata"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values of the sts token cache
+const (
+	// STS_CACHE_DIR is on tmpfs of host, the token is shared by the plugin processes of node
+	STS_CACHE_DIR  = "/var/run/alicloud/credentials"
+	STS_CACHE_FILE = "ram-role.json"
+	// CACHED_SUFFIX mark the source of the token read from cache
+	CACHED_SUFFIX = " (cached)"
+	// the token is refreshed before it expires, longer than the ecs client refresh period of daemon
+	STS_REFRESH_BEFORE = 15 * time.Minute
+)
+
+// stsCacheDir is the dir of token cache
+var stsCacheDir = STS_CACHE_DIR
+
+// SetCacheDir change the dir of token cache, used by tests
+func SetCacheDir(dir string) {
+	stsCacheDir = dir
+}
+
+// ramRoleSource is the sts token of the ram role attached to the instance, cached on disk until it is near expiration
+type ramRoleSource struct {
+	cacheDir string
+}
+
+func (s *ramRoleSource) Name() string {
+	return "ram role"
+}
+
+func (s *ramRoleSource) Retrieve(ctx context.Context) (*Credential, error) {
+	file := filepath.Join(s.cacheDir, STS_CACHE_FILE)
+	if cred := readToken(file); cred != nil && time.Until(cred.Expiration) > STS_REFRESH_BEFORE {
+		cred.Source = s.Name() + CACHED_SUFFIX
+		return cred, nil
+	}
+
+	auth, err := metadata.RoleToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cred := &Credential{
+		AccessKeyID:     auth.AccessKeyId,
+		AccessKeySecret: auth.AccessKeySecret,
+		SecurityToken:   auth.SecurityToken,
+		Expiration:      auth.Expiration,
+	}
+	if err := writeToken(s.cacheDir, file, cred); err != nil {
+		log.Warnf("Credentials, cache sts token error: %s", err.Error())
+	}
+	cred.Source = s.Name()
+	return cred, nil
+}
+
+// readToken return nil if the cache is missing or broken
+func readToken(file string) *Credential {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	cred := &Credential{}
+	if err := json.Unmarshal(raw, cred); err != nil || cred.AccessKeyID == "" {
+		return nil
+	}
+	return cred
+}
+
+// writeToken replace the cache by rename, only root can read the token
+func writeToken(dir, file string, cred *Credential) error {
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, STS_CACHE_FILE+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(raw)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
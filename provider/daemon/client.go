@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	"github.com/denverdino/aliyungo/common"
+)
+
+// client side timeouts, ping is short as the plugin falls back to run in process
+const (
+	DIAL_TIMEOUT = time.Second
+	PING_TIMEOUT = 2 * time.Second
+)
+
+// socket the client connect to, replaced in tests
+var socketFile = SOCKET_FILE
+
+// UnavailableError is returned when the daemon can not be connected, the caller should run in process
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return "daemon is unavailable: " + e.Err.Error()
+}
+
+// IsUnavailable check the error is returned as the daemon is not running
+func IsUnavailable(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialer := &net.Dialer{Timeout: DIAL_TIMEOUT}
+				return dialer.DialContext(ctx, "unix", socketFile)
+			},
+		},
+	}
+}
+
+// Ping check the daemon is serving, return the build info of the daemon
+func Ping(ctx context.Context) (utils.VersionInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, PING_TIMEOUT)
+	defer cancel()
+	info := utils.VersionInfo{}
+	err := Call(ctx, PING_PATH, nil, &info)
+	return info, err
+}
+
+// Call send the request to the path of daemon, and decode the response.
+// The ecs errors are restored as *common.Error, others are *utils.CodeError.
+func Call(ctx context.Context, path string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://daemon"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := newHTTPClient().Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &UnavailableError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &UnavailableError{Err: fmt.Errorf("path %s is not served", path)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		errResp := &errorResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(errResp); err != nil {
+			return fmt.Errorf("daemon %s return %s", path, resp.Status)
+		}
+		if errResp.EcsCode != "" {
+			ecsErr := &common.Error{StatusCode: errResp.StatusCode}
+			ecsErr.Code = errResp.EcsCode
+			ecsErr.Message = errResp.Message
+			ecsErr.RequestId = errResp.RequestId
+			return ecsErr
+		}
+		return utils.NewCodeError(errResp.Code, errResp.Message)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	"github.com/denverdino/aliyungo/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values for the node daemon, the socket dir is mounted from host to the monitoring pod
+const (
+	SOCKET_FILE = "/var/run/alicloud/flexvolume.sock"
+	PING_PATH   = "/ping"
+	HOST_ROOT   = "/host"
+)
+
+// Handler serve one daemon request, decode read the json request body
+type Handler func(ctx context.Context, decode func(v interface{}) error) (interface{}, error)
+
+var (
+	mutex    sync.RWMutex
+	handlers = map[string]Handler{}
+)
+
+// errorResponse carry the error of handler to client, ecs errors keep the api error code
+type errorResponse struct {
+	Code       utils.ErrorCode `json:"code"`
+	Message    string          `json:"message"`
+	EcsCode    string          `json:"ecsCode,omitempty"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	RequestId  string          `json:"requestId,omitempty"`
+}
+
+// Handle register the handler of path, called in init() of the driver package.
+// Panic if the path is registered twice.
+func Handle(path string, handler Handler) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := handlers[path]; ok || path == PING_PATH {
+		panic(fmt.Sprintf("daemon: path %s registered twice", path))
+	}
+	handlers[path] = handler
+}
+
+// Serve listen on the unix socket and serve the registered handlers until error
+func Serve(socket string) error {
+	if err := utils.CreateDest(filepath.Dir(socket)); err != nil {
+		return err
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	// only root, as kubelet, call the daemon
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	log.Infof("Daemon, Listening on: %s", socket)
+	return http.Serve(listener, handler())
+}
+
+func handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PING_PATH, func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, http.StatusOK, utils.BuildInfo())
+	})
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for path, handle := range handlers {
+		mux.HandleFunc(path, wrap(path, handle))
+	}
+	return mux
+}
+
+// wrap run the handler with the request context, which is cancelled when the client is gone
+func wrap(path string, handle Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decode := func(v interface{}) error {
+			return json.NewDecoder(r.Body).Decode(v)
+		}
+		resp, err := handle(r.Context(), decode)
+		if err != nil {
+			log.Errorf("Daemon, %s failed: %s", path, err.Error())
+			writeResponse(w, http.StatusInternalServerError, newErrorResponse(err))
+			return
+		}
+		writeResponse(w, http.StatusOK, resp)
+	}
+}
+
+func newErrorResponse(err error) *errorResponse {
+	resp := &errorResponse{Code: utils.ErrorCodeOf(err, utils.CODE_INTERNAL), Message: err.Error()}
+	if ecsErr, ok := err.(*common.Error); ok {
+		resp.EcsCode = ecsErr.Code
+		resp.Message = ecsErr.Message
+		resp.StatusCode = ecsErr.StatusCode
+		resp.RequestId = ecsErr.RequestId
+	}
+	return resp
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Daemon, Write response error: %s", err.Error())
+	}
+}
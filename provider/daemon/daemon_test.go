@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	"github.com/denverdino/aliyungo/common"
+)
+
+type echoRequest struct {
+	Name string `json:"name"`
+}
+
+func TestCall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socketFile = filepath.Join(dir, "run", "flexvolume.sock")
+	defer func() { socketFile = SOCKET_FILE }()
+	ctx := context.Background()
+
+	if _, err := Ping(ctx); !IsUnavailable(err) {
+		t.Fatalf("expect unavailable before serve, got: %v", err)
+	}
+
+	Handle("/test/echo", func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		req := &echoRequest{}
+		if err := decode(req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	})
+	Handle("/test/ecs", func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		ecsErr := &common.Error{StatusCode: 400}
+		ecsErr.Code = "Throttling.User"
+		ecsErr.Message = "request is throttled"
+		return nil, ecsErr
+	})
+	Handle("/test/code", func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		return nil, utils.NewCodeError(utils.CODE_METADATA_UNAVAILABLE, "metadata timeout")
+	})
+	go Serve(socketFile)
+
+	for i := 0; ; i++ {
+		if _, err := Ping(ctx); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatalf("daemon not serving: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if fi, err := os.Stat(socketFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("socket should be only accessed by root: %v, %v", fi.Mode(), err)
+	}
+
+	resp := &echoRequest{}
+	if err := Call(ctx, "/test/echo", &echoRequest{Name: "d-1"}, resp); err != nil || resp.Name != "d-1" {
+		t.Errorf("echo: expect d-1, got %+v, %v", resp, err)
+	}
+	err = Call(ctx, "/test/ecs", nil, nil)
+	if ecsErr, ok := err.(*common.Error); !ok || ecsErr.Code != "Throttling.User" || ecsErr.StatusCode != 400 {
+		t.Errorf("ecs error is not restored: %#v", err)
+	}
+	if code := utils.ErrorCodeOf(Call(ctx, "/test/code", nil, nil), utils.CODE_INTERNAL); code != utils.CODE_METADATA_UNAVAILABLE {
+		t.Errorf("expect code %s, got %s", utils.CODE_METADATA_UNAVAILABLE, code)
+	}
+	if err := Call(ctx, "/test/unknown", nil, nil); !IsUnavailable(err) {
+		t.Errorf("unknown path should be unavailable, got: %v", err)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	queue := NewQueue(10)
+	order := make(chan int, 10)
+	release := make(chan struct{})
+
+	// block the worker, the cancelled job is skipped
+	go queue.Do(context.Background(), func() error { <-release; return nil })
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	skipped := make(chan error)
+	go func() {
+		skipped <- queue.Do(ctx, func() error { order <- 0; return nil })
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-skipped; err != context.Canceled {
+		t.Errorf("expect cancelled, got %v", err)
+	}
+
+	done := make(chan error, 3)
+	for i := 1; i <= 3; i++ {
+		i := i
+		go func() {
+			done <- queue.Do(context.Background(), func() error { order <- i; return errors.New("job error") })
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	for i := 1; i <= 3; i++ {
+		if err := <-done; err == nil || err.Error() != "job error" {
+			t.Errorf("expect job error, got %v", err)
+		}
+		if got := <-order; got != i {
+			t.Errorf("expect job %d, got %d", i, got)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package daemon
+
+import (
+	"context"
+)
+
+// Queue run the jobs one by one in the order of submit
+type Queue struct {
+	jobs chan *job
+}
+
+type job struct {
+	ctx  context.Context
+	fn   func() error
+	done chan error
+}
+
+// NewQueue create the queue and start the worker, size is the max waiting jobs
+func NewQueue(size int) *Queue {
+	q := &Queue{jobs: make(chan *job, size)}
+	go q.run()
+	return q
+}
+
+func (q *Queue) run() {
+	for j := range q.jobs {
+		// the caller is gone while waiting, skip the job
+		if err := j.ctx.Err(); err != nil {
+			j.done <- err
+			continue
+		}
+		j.done <- j.fn()
+	}
+}
+
+// Do submit the job and wait for the result, return when ctx is done.
+// The running job is not interrupted as the cloud api calls are not cancellable.
+func (q *Queue) Do(ctx context.Context, fn func() error) error {
+	j := &job{ctx: ctx, fn: fn, done: make(chan error, 1)}
+	select {
+	case q.jobs <- j:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-j.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,204 @@
+package disk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/daemon"
+	"github.com/AliyunContainerService/flexvolume/provider/endpoint"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+// diskInfo is the disk status used by plugin
+type diskInfo struct {
+	DiskId     string         `json:"diskId"`
+	InstanceId string         `json:"instanceId"`
+	Status     ecs.DiskStatus `json:"status"`
+	Size       int            `json:"size"`
+}
+
+// diskCloud is the cloud api used by disk plugin, served by the node daemon or ecs directly
+type diskCloud interface {
+	Metadata(ctx context.Context) (regionId string, instanceId string, err error)
+	DescribeDisks(ctx context.Context, regionId string, diskIds []string) ([]diskInfo, error)
+	AttachDisk(ctx context.Context, instanceId, diskId string) error
+	DetachDisk(ctx context.Context, instanceId, diskId string) error
+	ResizeDisk(ctx context.Context, diskId string, size int) error
+}
+
+// initCloud use the node daemon if it is serving, otherwise call ecs in process.
+// The disk in another account is operated by the assumed role.
+func (p *DiskPlugin) initCloud(ctx context.Context, role *credentials.Role) error {
+	if p.cloud != nil {
+		return nil
+	}
+	if role != nil {
+		if err := role.Validate(); err != nil {
+			return utils.NewCodeError(utils.CODE_INVALID_OPTIONS, err.Error())
+		}
+	}
+	if _, err := daemon.Ping(ctx); err == nil {
+		p.cloud = &daemonCloud{role: role}
+		return nil
+	} else if !daemon.IsUnavailable(err) {
+		log.Warnf("Disk, Ping daemon error: %s, run in process", err.Error())
+	}
+	cloud, err := newEcsCloud(ctx, "", role)
+	if err != nil {
+		return err
+	}
+	p.cloud = cloud
+	return nil
+}
+
+// ecsCloud call ecs api with the client of plugin
+type ecsCloud struct {
+	client *ecs.Client
+}
+
+// newEcsCloud create ecs client with the credentials under root,
+// the files /etc/.volumeak/diskAkId and diskAkSecret are used before the global ones.
+// The role is assumed by the credential if it is not nil.
+func newEcsCloud(ctx context.Context, root string, role *credentials.Role) (*ecsCloud, error) {
+	cred, err := credentials.Resolve(ctx, credentials.Options{Root: root, Driver: CREDENTIAL_DRIVER})
+	if err != nil {
+		return nil, fmt.Errorf("Get access key error: %s", err.Error())
+	}
+	if role != nil {
+		if cred, err = assumeRole(ctx, cred, role); err != nil {
+			return nil, err
+		}
+	}
+	log.Debugf("Disk, use the credential of %s", cred.Source)
+
+	// Apsara Stack use local config file
+	client := newEcsClient(ctx, cred, getDiskEndpoint(root))
+	if client == nil {
+		return nil, fmt.Errorf("New Ecs Client error, ak_id: %s", credentials.Redact(cred.AccessKeyID))
+	}
+	return &ecsCloud{client: client}, nil
+}
+
+// assumeRole call the sts endpoint of node region
+func assumeRole(ctx context.Context, cred *credentials.Credential, role *credentials.Role) (*credentials.Credential, error) {
+	stsEndpoint, err := endpoint.Resolve(ctx, endpoint.SERVICE_STS, endpoint.Options{Credential: cred})
+	if err != nil {
+		return nil, fmt.Errorf("resolve sts endpoint error: %s", err.Error())
+	}
+	return credentials.AssumeRole(ctx, cred, *role, endpoint.URL(stsEndpoint))
+}
+
+func (c *ecsCloud) Metadata(ctx context.Context) (string, string, error) {
+	regionId, instanceId, err := utils.GetRegionAndInstanceId(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	c.client.SetUserAgent(KUBERNETES_ALICLOUD_DISK_DRIVER + "/" + instanceId)
+	return regionId, instanceId, nil
+}
+
+func (c *ecsCloud) DescribeDisks(ctx context.Context, regionId string, diskIds []string) ([]diskInfo, error) {
+	var disks []ecs.DiskItemType
+	err := invoke(ctx, "DescribeDisks", func() (err error) {
+		disks, _, err = c.client.DescribeDisks(&ecs.DescribeDisksArgs{
+			RegionId: common.Region(regionId),
+			DiskIds:  diskIds,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]diskInfo, 0, len(disks))
+	for _, disk := range disks {
+		infos = append(infos, diskInfo{DiskId: disk.DiskId, InstanceId: disk.InstanceId, Status: disk.Status, Size: disk.Size})
+	}
+	return infos, nil
+}
+
+func (c *ecsCloud) AttachDisk(ctx context.Context, instanceId, diskId string) error {
+	return invoke(ctx, "AttachDisk", func() error {
+		return c.client.AttachDisk(&ecs.AttachDiskArgs{InstanceId: instanceId, DiskId: diskId})
+	})
+}
+
+func (c *ecsCloud) DetachDisk(ctx context.Context, instanceId, diskId string) error {
+	return invoke(ctx, "DetachDisk", func() error {
+		return c.client.DetachDisk(instanceId, diskId)
+	})
+}
+
+func (c *ecsCloud) ResizeDisk(ctx context.Context, diskId string, size int) error {
+	return invoke(ctx, "ResizeDisk", func() error {
+		return c.client.ResizeDisk(diskId, size)
+	})
+}
+
+// invoke call the ecs api, the sdk client has no context so the call is abandoned when the deadline exceeded,
+// the plugin return in the deadline and the abandoned request is bounded by the timeout of transport
+func invoke(ctx context.Context, action string, call func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("ecs %s not responded: %s", action, ctx.Err())
+	case err := <-done:
+		return err
+	}
+}
+
+// daemon paths of disk cloud api
+const (
+	DAEMON_DISK_METADATA = "/disk/metadata"
+	DAEMON_DISK_DESCRIBE = "/disk/describe"
+	DAEMON_DISK_ATTACH   = "/disk/attach"
+	DAEMON_DISK_DETACH   = "/disk/detach"
+	DAEMON_DISK_RESIZE   = "/disk/resize"
+)
+
+// daemonRequest is the request of disk cloud api in daemon
+type daemonRequest struct {
+	RegionId   string   `json:"regionId,omitempty"`
+	InstanceId string   `json:"instanceId,omitempty"`
+	DiskIds    []string `json:"diskIds,omitempty"`
+	Size       int      `json:"size,omitempty"`
+	// Role is assumed by the daemon for the disk in another account
+	Role *credentials.Role `json:"role,omitempty"`
+}
+
+// daemonCloud call the cloud api through the node daemon
+type daemonCloud struct {
+	role *credentials.Role
+}
+
+func (c *daemonCloud) Metadata(ctx context.Context) (string, string, error) {
+	resp := &daemonRequest{}
+	if err := daemon.Call(ctx, DAEMON_DISK_METADATA, &daemonRequest{}, resp); err != nil {
+		return "", "", err
+	}
+	return resp.RegionId, resp.InstanceId, nil
+}
+
+func (c *daemonCloud) DescribeDisks(ctx context.Context, regionId string, diskIds []string) ([]diskInfo, error) {
+	disks := []diskInfo{}
+	err := daemon.Call(ctx, DAEMON_DISK_DESCRIBE, &daemonRequest{RegionId: regionId, DiskIds: diskIds, Role: c.role}, &disks)
+	return disks, err
+}
+
+func (c *daemonCloud) AttachDisk(ctx context.Context, instanceId, diskId string) error {
+	return daemon.Call(ctx, DAEMON_DISK_ATTACH, &daemonRequest{InstanceId: instanceId, DiskIds: []string{diskId}, Role: c.role}, nil)
+}
+
+func (c *daemonCloud) DetachDisk(ctx context.Context, instanceId, diskId string) error {
+	return daemon.Call(ctx, DAEMON_DISK_DETACH, &daemonRequest{InstanceId: instanceId, DiskIds: []string{diskId}, Role: c.role}, nil)
+}
+
+func (c *daemonCloud) ResizeDisk(ctx context.Context, diskId string, size int) error {
+	return daemon.Call(ctx, DAEMON_DISK_RESIZE, &daemonRequest{DiskIds: []string{diskId}, Size: size, Role: c.role}, nil)
+}
@@ -0,0 +1,209 @@
+package disk
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/daemon"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+// const values for the disk cloud api served by daemon
+const (
+	// the client is recreated as the sts token expires
+	ECS_CLIENT_REFRESH_PERIOD = 10 * time.Minute
+	// kubelet check all the attached volumes in a burst
+	DESCRIBE_CACHE_TTL = time.Second
+	DISK_QUEUE_SIZE    = 100
+)
+
+// nodeCloud is the disk cloud api of node, shared by daemon and journal recoverer in monitor
+var nodeCloud = newCachedCloud(func(ctx context.Context) (diskCloud, error) {
+	return newEcsCloud(ctx, daemon.HOST_ROOT, nil)
+})
+
+// roleClouds are the disk cloud api of the assumed roles, created on demand
+var roleClouds = struct {
+	sync.Mutex
+	clouds map[credentials.Role]*cachedCloud
+}{clouds: map[credentials.Role]*cachedCloud{}}
+
+// cloudOf return the disk cloud api of role, nodeCloud if role is nil.
+// The disk operations of roles are in the queue of node, the devices are attached one by one.
+func cloudOf(role *credentials.Role) *cachedCloud {
+	if role == nil {
+		return nodeCloud
+	}
+	roleClouds.Lock()
+	defer roleClouds.Unlock()
+	key := *role
+	cloud, ok := roleClouds.clouds[key]
+	if !ok {
+		cloud = newCachedCloud(func(ctx context.Context) (diskCloud, error) {
+			return newEcsCloud(ctx, daemon.HOST_ROOT, &key)
+		})
+		cloud.queue = nodeCloud.queue
+		roleClouds.clouds[key] = cloud
+	}
+	return cloud
+}
+
+// register the disk cloud api to daemon
+func init() {
+	daemon.Handle(DAEMON_DISK_METADATA, func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		regionId, instanceId, err := nodeCloud.Metadata(ctx)
+		return &daemonRequest{RegionId: regionId, InstanceId: instanceId}, err
+	})
+	daemon.Handle(DAEMON_DISK_DESCRIBE, func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		req := &daemonRequest{}
+		if err := decode(req); err != nil {
+			return nil, utils.NewCodeError(utils.CODE_INVALID_ARGUMENTS, err.Error())
+		}
+		return cloudOf(req.Role).DescribeDisks(ctx, req.RegionId, req.DiskIds)
+	})
+	daemon.Handle(DAEMON_DISK_ATTACH, func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		req := &daemonRequest{}
+		if err := decode(req); err != nil || len(req.DiskIds) != 1 {
+			return nil, utils.NewCodeError(utils.CODE_INVALID_ARGUMENTS, "attach expect one disk")
+		}
+		return nil, cloudOf(req.Role).AttachDisk(ctx, req.InstanceId, req.DiskIds[0])
+	})
+	daemon.Handle(DAEMON_DISK_DETACH, func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		req := &daemonRequest{}
+		if err := decode(req); err != nil || len(req.DiskIds) != 1 {
+			return nil, utils.NewCodeError(utils.CODE_INVALID_ARGUMENTS, "detach expect one disk")
+		}
+		return nil, cloudOf(req.Role).DetachDisk(ctx, req.InstanceId, req.DiskIds[0])
+	})
+	daemon.Handle(DAEMON_DISK_RESIZE, func(ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+		req := &daemonRequest{}
+		if err := decode(req); err != nil || len(req.DiskIds) != 1 {
+			return nil, utils.NewCodeError(utils.CODE_INVALID_ARGUMENTS, "resize expect one disk")
+		}
+		return nil, cloudOf(req.Role).ResizeDisk(ctx, req.DiskIds[0], req.Size)
+	})
+}
+
+// cachedCloud is the disk cloud api in daemon, it owns the ecs client, caches the metadata and disks,
+// and queues the disk operations of the node.
+type cachedCloud struct {
+	newCloud func(ctx context.Context) (diskCloud, error)
+	queue    *daemon.Queue
+
+	mutex       sync.Mutex
+	cloud       diskCloud
+	cloudTime   time.Time
+	regionId    string
+	instanceId  string
+	disks       map[string][]diskInfo
+	disksExpire map[string]time.Time
+}
+
+func newCachedCloud(newCloud func(ctx context.Context) (diskCloud, error)) *cachedCloud {
+	return &cachedCloud{
+		newCloud:    newCloud,
+		queue:       daemon.NewQueue(DISK_QUEUE_SIZE),
+		disks:       map[string][]diskInfo{},
+		disksExpire: map[string]time.Time{},
+	}
+}
+
+// getCloud return the client, recreate it after the refresh period in the deadline of call
+func (c *cachedCloud) getCloud(ctx context.Context) (diskCloud, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cloud == nil || time.Since(c.cloudTime) > ECS_CLIENT_REFRESH_PERIOD {
+		cloud, err := c.newCloud(ctx)
+		if err != nil {
+			return nil, utils.NewCodeError(utils.CODE_CREDENTIAL_MISSING, err.Error())
+		}
+		c.cloud, c.cloudTime = cloud, time.Now()
+	}
+	return c.cloud, nil
+}
+
+// Metadata is cached forever, the node is not moved
+func (c *cachedCloud) Metadata(ctx context.Context) (string, string, error) {
+	c.mutex.Lock()
+	regionId, instanceId := c.regionId, c.instanceId
+	c.mutex.Unlock()
+	if regionId != "" && instanceId != "" {
+		return regionId, instanceId, nil
+	}
+
+	cloud, err := c.getCloud(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if regionId, instanceId, err = cloud.Metadata(ctx); err != nil {
+		return "", "", utils.NewCodeError(utils.CODE_METADATA_UNAVAILABLE, err.Error())
+	}
+	c.mutex.Lock()
+	c.regionId, c.instanceId = regionId, instanceId
+	c.mutex.Unlock()
+	return regionId, instanceId, nil
+}
+
+func (c *cachedCloud) DescribeDisks(ctx context.Context, regionId string, diskIds []string) ([]diskInfo, error) {
+	ids := append([]string{}, diskIds...)
+	sort.Strings(ids)
+	key := regionId + "/" + strings.Join(ids, ",")
+
+	c.mutex.Lock()
+	if expire, ok := c.disksExpire[key]; ok && time.Now().Before(expire) {
+		disks := c.disks[key]
+		c.mutex.Unlock()
+		return disks, nil
+	}
+	c.mutex.Unlock()
+
+	cloud, err := c.getCloud(ctx)
+	if err != nil {
+		return nil, err
+	}
+	disks, err := cloud.DescribeDisks(ctx, regionId, diskIds)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.disks[key], c.disksExpire[key] = disks, time.Now().Add(DESCRIBE_CACHE_TTL)
+	c.mutex.Unlock()
+	return disks, nil
+}
+
+func (c *cachedCloud) AttachDisk(ctx context.Context, instanceId, diskId string) error {
+	return c.modify(ctx, func(cloud diskCloud) error {
+		return cloud.AttachDisk(ctx, instanceId, diskId)
+	})
+}
+
+func (c *cachedCloud) DetachDisk(ctx context.Context, instanceId, diskId string) error {
+	return c.modify(ctx, func(cloud diskCloud) error {
+		return cloud.DetachDisk(ctx, instanceId, diskId)
+	})
+}
+
+func (c *cachedCloud) ResizeDisk(ctx context.Context, diskId string, size int) error {
+	return c.modify(ctx, func(cloud diskCloud) error {
+		return cloud.ResizeDisk(ctx, diskId, size)
+	})
+}
+
+// modify run the disk operation in queue, and drop the cached disks as the status is changed
+func (c *cachedCloud) modify(ctx context.Context, fn func(cloud diskCloud) error) error {
+	cloud, err := c.getCloud(ctx)
+	if err != nil {
+		return err
+	}
+	return c.queue.Do(ctx, func() error {
+		err := fn(cloud)
+		c.mutex.Lock()
+		c.disks, c.disksExpire = map[string][]diskInfo{}, map[string]time.Time{}
+		c.mutex.Unlock()
+		return err
+	})
+}
@@ -1,19 +1,28 @@
 package disk
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/endpoint"
+	"github.com/AliyunContainerService/flexvolume/provider/journal"
+	"github.com/AliyunContainerService/flexvolume/provider/locking"
+	"github.com/AliyunContainerService/flexvolume/provider/metadata"
+	"github.com/AliyunContainerService/flexvolume/provider/mountinfo"
+	"github.com/AliyunContainerService/flexvolume/provider/registry"
 	"github.com/AliyunContainerService/flexvolume/provider/utils"
 	"github.com/denverdino/aliyungo/common"
 	"github.com/denverdino/aliyungo/ecs"
-	"github.com/denverdino/aliyungo/metadata"
-	"github.com/nightlyone/lockfile"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,10 +31,17 @@ const (
 	KUBERNETES_ALICLOUD_DISK_DRIVER = "alicloud_disk"
 	VolumeDir                       = "/etc/kubernetes/volumes/disk/"
 	VolumeDirRemove                 = "/etc/kubernetes/volumes/disk/remove"
-	DISK_AKID                       = "/etc/.volumeak/diskAkId"
-	DISK_AKSECRET                   = "/etc/.volumeak/diskAkSecret"
+	DEFAULT_FSTYPE                  = "ext4"
+	GB_SIZE                         = 1024 * 1024 * 1024
+	FORMAT_TIMEOUT                  = 10 * time.Minute
+	CREDENTIAL_DRIVER               = "disk"
 	DISK_ECSENPOINT                 = "/etc/.volumeak/diskEcsEndpoint"
-	ECSDEFAULTENDPOINT              = "https://ecs-cn-hangzhou.aliyuncs.com"
+	DEVICE_LOCK                     = "disk-devices"
+	DEVICE_BY_ID_DIR                = "/dev/disk/by-id"
+	// the serial of disk device is the disk id without "d-"
+	DEVICE_SERIAL_PREFIX = "virtio-"
+	// DEVICE_MOUNT_DIR is the global device mount dir under the root dir of kubelet
+	DEVICE_MOUNT_DIR = "/plugins/kubernetes.io/flexvolume/alicloud/disk/mounts"
 )
 
 // DiskOptions define the disk parameters
@@ -33,6 +49,42 @@ type DiskOptions struct {
 	VolumeName string `json:"kubernetes.io/pvOrVolumeName"`
 	FsType     string `json:"kubernetes.io/fsType"`
 	VolumeId   string `json:"volumeId"`
+	ReadWrite  string `json:"kubernetes.io/readwrite"`
+	// RoleArn and RoleSessionName is the ram role of the account owning the disk
+	RoleArn         string `json:"roleArn"`
+	RoleSessionName string `json:"roleSessionName"`
+}
+
+// role return the ram role to assume, nil for the disk of node account
+func (opt *DiskOptions) role() *credentials.Role {
+	if opt.RoleArn == "" {
+		return nil
+	}
+	return &credentials.Role{Arn: opt.RoleArn, SessionName: opt.RoleSessionName}
+}
+
+// Capabilities disk is attachable, and formatted by plugin
+var Capabilities = utils.Capabilities{
+	Attach:          true,
+	SELinuxRelabel:  true,
+	SupportsMetrics: true,
+	FSGroup:         true,
+}
+
+// register disk driver
+func init() {
+	registry.Register(registry.Driver{
+		Name:         "disk",
+		Capabilities: Capabilities,
+		Options: []registry.Option{
+			{Name: "volumeId", Required: true, Description: "id of the ecs cloud disk"},
+			{Name: "kubernetes.io/fsType", Default: DEFAULT_FSTYPE, Description: "filesystem to format the disk, ext4, ext3 or xfs"},
+			{Name: "kubernetes.io/readwrite", Default: utils.READ_WRITE, Description: "access mode passed by kubelet, ro mounts the disk read only"},
+			{Name: "roleArn", Description: "ram role of the account owning the disk, assumed by the node credential"},
+			{Name: "roleSessionName", Default: credentials.DEFAULT_SESSION_NAME, Description: "session name of the assumed role"},
+		},
+		New: func() registry.Plugin { return &DiskPlugin{} },
+	})
 }
 
 // the iddentity for http headker
@@ -40,9 +92,20 @@ var KUBERNETES_ALICLOUD_IDENTITY = fmt.Sprintf("Kubernetes.Alicloud/Flexvolume.D
 // default region for aliyun sdk usage
 var DEFAULT_REGION = common.Hangzhou
 
+// volumeDir and volumeRemoveDir record the volumes attached and mounted on node,
+// deviceByIdDir link the disk serials to devices, changed by tests
+var (
+	volumeDir       = VolumeDir
+	volumeRemoveDir = VolumeDirRemove
+	deviceByIdDir   = DEVICE_BY_ID_DIR
+)
+
 // DiskPlugin define DiskPlugin
 type DiskPlugin struct {
-	client *ecs.Client
+	cloud     diskCloud
+	exec      utils.Executor
+	mountInfo string
+	journal   string
 }
 
 // NewOptions define NewOptions
@@ -50,106 +113,158 @@ func (p *DiskPlugin) NewOptions() interface{} {
 	return &DiskOptions{}
 }
 
+// executor run the commands of plugin, default run on host
+func (p *DiskPlugin) executor() utils.Executor {
+	if p.exec == nil {
+		p.exec = utils.NewExecutor()
+	}
+	return p.exec
+}
+
+// mountinfo file of plugin, default is the mount namespace of plugin
+func (p *DiskPlugin) mountInfoFile() string {
+	if p.mountInfo == "" {
+		return mountinfo.SELF_MOUNTINFO
+	}
+	return p.mountInfo
+}
+
 // Init define Init for DiskPlugin
-func (p *DiskPlugin) Init() utils.Result {
-	return utils.Succeed()
+func (p *DiskPlugin) Init(ctx context.Context) utils.Result {
+	return utils.SucceedWithCapabilities(Capabilities)
 }
 
 // Attach attach with NodeName and Options
 // Attach: nodeName: regionId.instanceId, exammple: cn-hangzhou.i-bp12gei4ljuzilgwzahc
 // Attach: options: {"kubernetes.io/fsType": "", "kubernetes.io/pvOrVolumeName": "", "kubernetes.io/readwrite": "", "volumeId":""}
-func (p *DiskPlugin) Attach(opts interface{}, nodeName string) utils.Result {
+func (p *DiskPlugin) Attach(ctx context.Context, opts interface{}, nodeName string) (result utils.Result) {
 
 	log.Infof("Disk Plugin Attach: %s", strings.Join(os.Args, ","))
 
 	// Step 0: Check disk is attached on this host
 	// resolve kubelet restart issue
 	opt := opts.(*DiskOptions)
-	cmd := fmt.Sprintf("mount | grep alicloud~disk/%s", opt.VolumeName)
-	if out, err := utils.Run(cmd); err == nil {
-		devicePath := strings.Split(strings.TrimSpace(out), " ")[0]
-		log.Infof("Disk Already Attached, DiskId: %s, Device: %s", opt.VolumeName, devicePath)
-		return utils.Result{Status: "Success", Device: devicePath}
+	if table, err := mountinfo.Load(p.mountInfoFile()); err == nil {
+		for _, mount := range table {
+			if filepath.Base(mount.MountPoint) == opt.VolumeName && filepath.Base(filepath.Dir(mount.MountPoint)) == "alicloud~disk" {
+				log.Infof("Disk Already Attached, DiskId: %s, Device: %s", opt.VolumeName, mount.Source)
+				p.finishJournal(opt.VolumeName)
+				return utils.Result{Status: "Success", Device: mount.Source}
+			}
+		}
 	}
 
 	// Step 1: init ecs client and parameters
-	p.initEcsClient()
-	regionId, instanceId, err := utils.GetRegionAndInstanceId()
-	if err != nil {
-		utils.FinishError("Disk, Parse node region/name error: " + nodeName + err.Error())
+	if err := p.initCloud(ctx, opt.role()); err != nil {
+		return utils.FailWithError(err, utils.CODE_CREDENTIAL_MISSING, "Disk, Init ecs client error: "+err.Error())
 	}
-	p.client.SetUserAgent(KUBERNETES_ALICLOUD_DISK_DRIVER + "/" + instanceId)
-	attachRequest := &ecs.AttachDiskArgs{
-		InstanceId: instanceId,
-		DiskId:     opt.VolumeId,
+	regionId, instanceId, err := p.cloud.Metadata(ctx)
+	if err != nil {
+		return utils.FailWithError(err, utils.CODE_METADATA_UNAVAILABLE, "Disk, Parse node region/name error: "+nodeName+err.Error())
 	}
-
 	// Step 2: Detach disk first
 	var devicePath string
-	describeDisksRequest := &ecs.DescribeDisksArgs{
-		DiskIds:  []string{opt.VolumeId},
-		RegionId: common.Region(regionId),
-	}
 	// call detach to ensure work after node reboot
-	disks, _, err := p.client.DescribeDisks(describeDisksRequest)
+	disks, err := p.cloud.DescribeDisks(ctx, regionId, []string{opt.VolumeId})
+	if err != nil {
+		return utils.FailWithError(err, utils.CODE_ECS_ERROR, "Disk, Can not get disk: "+opt.VolumeId+", with error:"+err.Error())
+	}
+	if len(disks) == 0 {
+		return utils.FailWithCode(utils.CODE_DISK_NOT_FOUND, "Disk, Disk not exist: "+opt.VolumeId)
+	}
+
+	// the unfinished operation of volume: the running one is not interrupted,
+	// the disk attached by the abandoned one is not detached again, only the device is discovered.
+	pending, err := journal.Load(p.journalDir(), JOURNAL_DRIVER, opt.VolumeName)
 	if err != nil {
-		utils.FinishError("Disk, Can not get disk: " + opt.VolumeId + ", with error:" + err.Error())
+		return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Load journal failed, Volume: "+opt.VolumeName+", err: "+err.Error())
+	}
+	if pending != nil && pending.State() == journal.STATE_RUNNING {
+		return utils.FailWithCode(utils.CODE_DISK_LOCKED, "Disk, Volume "+opt.VolumeName+" is in operation: "+pending.String())
+	}
+	var op *journal.Operation
+	var before []string
+	resumed := canResume(pending, opt.VolumeId, instanceId, disks[0])
+	if resumed {
+		log.Infof("Disk, Resume the abandoned operation: %s", pending)
+		op, before = pending, splitDevices(pending.Data[DATA_DEVICES])
+	} else {
+		if pending != nil {
+			log.Warnf("Disk, Restart the abandoned operation: %s", pending)
+		}
+		op, err = journal.Begin(p.journalDir(), JOURNAL_DRIVER, "attach", opt.VolumeName, withRole(map[string]string{DATA_DISK_ID: opt.VolumeId, DATA_INSTANCE_ID: instanceId}, opt.role()))
+		if err != nil {
+			return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Write journal failed, Volume: "+opt.VolumeName+", err: "+err.Error())
+		}
 	}
-	if len(disks) >= 1 && disks[0].Status == ecs.DiskStatusInUse {
-		err = p.client.DetachDisk(disks[0].InstanceId, disks[0].DiskId)
+	defer endOperation(op, &result)
+
+	if !resumed && disks[0].Status == ecs.DiskStatusInUse {
+		if err := op.Next(STEP_DETACHING, map[string]string{DATA_FROM_INSTANCE: disks[0].InstanceId}); err != nil {
+			return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Write journal failed, Volume: "+opt.VolumeName+", err: "+err.Error())
+		}
+		err = p.cloud.DetachDisk(ctx, disks[0].InstanceId, disks[0].DiskId)
 		if err != nil {
-			utils.FinishError("Disk, Failed to detach: " + err.Error())
+			return utils.FailWithError(err, utils.CODE_DISK_DETACH_FAILED, "Disk, Failed to detach: "+err.Error())
 		}
 	}
 
 	// Step 3: wait for Detach
-	for i := 0; i < 15; i++ {
-		disks, _, err := p.client.DescribeDisks(describeDisksRequest)
+	pollTimes, pollInterval := diskPolling()
+	for i := 0; i < pollTimes && !resumed; i++ {
+		disks, err := p.cloud.DescribeDisks(ctx, regionId, []string{opt.VolumeId})
 		if err != nil {
-			utils.FinishError("Could not get Disk again " + opt.VolumeId + ", with error: " + err.Error())
+			return utils.FailWithError(err, utils.CODE_ECS_ERROR, "Could not get Disk again "+opt.VolumeId+", with error: "+err.Error())
 		}
 		if len(disks) >= 1 && disks[0].Status == ecs.DiskStatusAvailable {
 			break
 		}
-		if i == 14 {
-			utils.FinishError("Detach disk timeout, failed: " + opt.VolumeId)
+		if i == pollTimes-1 {
+			return utils.FailWithCode(utils.CODE_DISK_DETACH_TIMEOUT, "Detach disk timeout, failed: "+opt.VolumeId)
+		}
+		if err := utils.Sleep(ctx, pollInterval); err != nil {
+			return utils.FailWithCode(utils.CODE_CALL_TIMEOUT, "Wait for detach interrupted: "+opt.VolumeId+", with error: "+err.Error())
 		}
-		time.Sleep(2000 * time.Millisecond)
 	}
 	log.Infof("Disk is ready to attach: %s, %s, %s", opt.VolumeName, opt.VolumeId, opt.FsType)
 
-	// multi disk attach at the same time
-	// lck file created under /tmp/
-	lockfileName := "lockfile-disk.lck"
-	lock, err := lockfile.New(filepath.Join(os.TempDir(), lockfileName))
-	if err != nil {
-		utils.FinishError("Lockfile New failed, DiskId: " + opt.VolumeId + ", Volume: " + opt.VolumeName + ", err: " + err.Error())
-	}
-	err = lock.TryLock()
+	// multi disk attach at the same time, the new device is found by the devices before and after attach,
+	// so wait for the device lock of node
+	lock, err := locking.Node(ctx, DEVICE_LOCK)
 	if err != nil {
-		utils.FinishError("Lockfile failed, DiskId: " + opt.VolumeId + ", Volume: " + opt.VolumeName + ", err: " + err.Error())
+		return utils.FailWithError(err, utils.CODE_LOCK_TIMEOUT, "Lock devices failed, DiskId: "+opt.VolumeId+", Volume: "+opt.VolumeName+", err: "+err.Error())
 	}
-	defer lock.Unlock()
+	defer lock.Release()
 
-	// Step 4: Attach Disk, list device before attach disk
-	before := GetCurrentDevices()
-	if err = p.client.AttachDisk(attachRequest); err != nil {
-		utils.FinishError("Attach failed, DiskId: " + opt.VolumeId + ", Volume: " + opt.VolumeName + ", err: " + err.Error())
+	// Step 4: Attach Disk, list device before attach disk, record them for the device discovery on resume
+	if !resumed {
+		before = GetCurrentDevices()
+		if err := op.Next(STEP_ATTACHING, map[string]string{DATA_DEVICES: strings.Join(before, ",")}); err != nil {
+			return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Write journal failed, Volume: "+opt.VolumeName+", err: "+err.Error())
+		}
+		if err = p.cloud.AttachDisk(ctx, instanceId, opt.VolumeId); err != nil {
+			return utils.FailWithError(err, utils.CODE_DISK_ATTACH_FAILED, "Attach failed, DiskId: "+opt.VolumeId+", Volume: "+opt.VolumeName+", err: "+err.Error())
+		}
 	}
 
 	// step 5: wait for attach
-	for i := 0; i < 15; i++ {
-		disks, _, err := p.client.DescribeDisks(describeDisksRequest)
+	for i := 0; i < pollTimes && !resumed; i++ {
+		disks, err := p.cloud.DescribeDisks(ctx, regionId, []string{opt.VolumeId})
 		if err != nil {
-			utils.FinishError("Attach describe error, DiskId: " + opt.VolumeId + ", Volume: " + opt.VolumeName + ", err: " + err.Error())
+			return utils.FailWithError(err, utils.CODE_ECS_ERROR, "Attach describe error, DiskId: "+opt.VolumeId+", Volume: "+opt.VolumeName+", err: "+err.Error())
 		}
 		if len(disks) >= 1 && disks[0].Status == ecs.DiskStatusInUse {
 			break
 		}
-		if i == 14 {
-			utils.FinishError("Attach timeout, DiskId: " + opt.VolumeId + ", Volume: " + opt.VolumeName)
+		if i == pollTimes-1 {
+			return utils.FailWithCode(utils.CODE_DISK_ATTACH_TIMEOUT, "Attach timeout, DiskId: "+opt.VolumeId+", Volume: "+opt.VolumeName)
+		}
+		if err := utils.Sleep(ctx, pollInterval); err != nil {
+			return utils.FailWithCode(utils.CODE_CALL_TIMEOUT, "Wait for attach interrupted, DiskId: "+opt.VolumeId+", Volume: "+opt.VolumeName+", err: "+err.Error())
 		}
-		time.Sleep(2000 * time.Millisecond)
+	}
+	if err := op.Next(STEP_ATTACHED, nil); err != nil {
+		return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Write journal failed, Volume: "+opt.VolumeName+", err: "+err.Error())
 	}
 
 	// Step 6: Analysis attach device, list device after attach device
@@ -157,7 +272,7 @@ func (p *DiskPlugin) Attach(opts interface{}, nodeName string) utils.Result {
 		after := GetCurrentDevices()
 		devicePaths := getDevicePath(before, after)
 		if i == 9 {
-			utils.FinishError("Attach Success, but get DevicePath error1, DiskId: " + opt.VolumeId + ", Volume: " + opt.VolumeName + ", DevicePaths: " + strings.Join(devicePaths, ",") + ", After: " + strings.Join(after, ","))
+			return utils.FailWithCode(utils.CODE_DISK_DEVICE_NOT_FOUND, "Attach Success, but get DevicePath error1, DiskId: "+opt.VolumeId+", Volume: "+opt.VolumeName+", DevicePaths: "+strings.Join(devicePaths, ",")+", After: "+strings.Join(after, ","))
 		}
 		if len(devicePaths) == 2 && strings.HasPrefix(devicePaths[1], devicePaths[0]) {
 			devicePath = devicePaths[1]
@@ -166,15 +281,21 @@ func (p *DiskPlugin) Attach(opts interface{}, nodeName string) utils.Result {
 			devicePath = devicePaths[0]
 			break
 		} else if len(devicePaths) == 0 {
-			time.Sleep(2 * time.Second)
+			if err := utils.Sleep(ctx, 2*time.Second); err != nil {
+				return utils.FailWithCode(utils.CODE_CALL_TIMEOUT, "Attach Success, but wait for DevicePath interrupted, DiskId: "+opt.VolumeId+", Volume: "+opt.VolumeName+", err: "+err.Error())
+			}
 		} else {
-			utils.FinishError("Attach Success, but get DevicePath error2, DiskId: " + opt.VolumeId + ", Volume: " + opt.VolumeName + ", DevicePaths: " + strings.Join(devicePaths, ",") + ", After: " + strings.Join(after, ","))
+			return utils.FailWithCode(utils.CODE_DISK_DEVICE_NOT_FOUND, "Attach Success, but get DevicePath error2, DiskId: "+opt.VolumeId+", Volume: "+opt.VolumeName+", DevicePaths: "+strings.Join(devicePaths, ",")+", After: "+strings.Join(after, ","))
 		}
 	}
 
+	if err := op.Next(STEP_DEVICE_FOUND, map[string]string{DATA_DEVICE: devicePath}); err != nil {
+		return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Write journal failed, Volume: "+opt.VolumeName+", err: "+err.Error())
+	}
+
 	// save volume info to file
 	if err := saveVolumeConfig(opt); err != nil {
-		log.Errorf("Save volume config failed: " + err.Error())
+		log.Errorf("Save volume config failed: %s", err.Error())
 	}
 
 	log.Infof("Attach successful, DiskId: %s, Volume: %s, Device: %s", opt.VolumeId, opt.VolumeName, devicePath)
@@ -184,6 +305,80 @@ func (p *DiskPlugin) Attach(opts interface{}, nodeName string) utils.Result {
 	}
 }
 
+// Isattached check the disk is attached to this node by ecs, and the device of disk is present on node,
+// and clean up the local volume config if the attachment is stale.
+func (p *DiskPlugin) Isattached(ctx context.Context, opts interface{}, nodeName string) utils.Result {
+	opt := opts.(*DiskOptions)
+	log.Infof("Disk Plugin Isattached: %s", strings.Join(os.Args, ","))
+
+	// Step 1: init ecs client and parameters
+	if err := p.initCloud(ctx, opt.role()); err != nil {
+		return utils.FailWithError(err, utils.CODE_CREDENTIAL_MISSING, "Disk, Init ecs client error: "+err.Error())
+	}
+	regionId, instanceId, err := p.cloud.Metadata(ctx)
+	if err != nil {
+		return utils.FailWithError(err, utils.CODE_METADATA_UNAVAILABLE, "Isattached with get regionid/instanceid error: "+err.Error())
+	}
+
+	// Step 2: check disk status from ecs
+	disks, err := p.cloud.DescribeDisks(ctx, regionId, []string{opt.VolumeId})
+	if err != nil {
+		return utils.FailWithError(err, utils.CODE_ECS_ERROR, "Isattached, Can not get disk: "+opt.VolumeId+", with error: "+err.Error())
+	}
+	attached := len(disks) >= 1 && disks[0].InstanceId == instanceId && disks[0].Status == ecs.DiskStatusInUse
+	if len(disks) >= 1 && disks[0].InstanceId != "" && disks[0].InstanceId != instanceId {
+		log.Warnf("Isattached, Disk %s of volume %s is attached to another instance: %s", opt.VolumeId, opt.VolumeName, disks[0].InstanceId)
+	}
+
+	// Step 3: check the device on node, the attachment without device is not usable for mountdevice
+	configDiskId := getVolumeConfig(opt.VolumeName)
+	if attached {
+		device, known := diskDevice(opt.VolumeId)
+		if !known {
+			// the node has no serial links, trust the volume config saved by attach or the mounted device
+			deviceMountPath := getDeviceMountPath(opt.VolumeName)
+			attached = configDiskId == opt.VolumeId || (deviceMountPath != "" && utils.IsMounted(p.mountInfoFile(), deviceMountPath))
+		} else if device == "" {
+			attached = false
+		}
+		if !attached {
+			log.Warnf("Isattached, Disk %s is attached to %s by ecs, but the device of volume %s is not found", opt.VolumeId, instanceId, opt.VolumeName)
+		}
+	}
+
+	// Step 4: check local volume config
+	if !attached && configDiskId != "" {
+		log.Warnf("Isattached, Volume %s is not attached on %s, remove stale config of disk: %s", opt.VolumeName, instanceId, configDiskId)
+		if err := removeVolumeConfig(opt.VolumeName); err != nil {
+			log.Errorf("Isattached, Remove volume config failed: %s", err.Error())
+		}
+	} else if attached && configDiskId != opt.VolumeId {
+		log.Warnf("Isattached, Volume %s is attached on %s, but config is: %s, save it again", opt.VolumeName, instanceId, configDiskId)
+		if err := saveVolumeConfig(opt); err != nil {
+			log.Errorf("Isattached, Save volume config failed: %s", err.Error())
+		}
+	}
+
+	log.Infof("Isattached, Volume: %s, DiskId: %s, Instance: %s, Attached: %t", opt.VolumeName, opt.VolumeId, instanceId, attached)
+	return utils.Result{
+		Status:   "Success",
+		Attached: attached,
+	}
+}
+
+// diskDevice return the device of disk found by its serial, known is false if the node has no serial links
+func diskDevice(diskId string) (device string, known bool) {
+	if !utils.IsFileExisting(deviceByIdDir) {
+		return "", false
+	}
+	link := filepath.Join(deviceByIdDir, DEVICE_SERIAL_PREFIX+strings.TrimPrefix(diskId, "d-"))
+	device, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", true
+	}
+	return device, true
+}
+
 // GetCurrentDevices: Get devices like /dev/vd**
 func GetCurrentDevices() []string {
 	var devices []string
@@ -198,14 +393,17 @@ func GetCurrentDevices() []string {
 
 // Detach current kubelet call detach not provide plugin spec;
 // this issue is tracked by: https://github.com/kubernetes/kubernetes/issues/52590
-func (p *DiskPlugin) Detach(volumeName string, nodeName string) utils.Result {
+func (p *DiskPlugin) Detach(ctx context.Context, volumeName string, nodeName string) (result utils.Result) {
 	log.Infof("Disk Plugin Detach: %s", strings.Join(os.Args, ","))
 
-	// Step 1: init ecs client
-	p.initEcsClient()
-	regionId, instanceId, err := utils.GetRegionAndInstanceId()
+	// Step 1: init ecs client, with the role of volume saved by attach
+	role := getVolumeRole(volumeName)
+	if err := p.initCloud(ctx, role); err != nil {
+		return utils.FailWithError(err, utils.CODE_CREDENTIAL_MISSING, "Disk, Init ecs client error: "+err.Error())
+	}
+	regionId, instanceId, err := p.cloud.Metadata(ctx)
 	if err != nil {
-		utils.FinishError("Detach with get regionid/instanceid error: " + err.Error())
+		return utils.FailWithError(err, utils.CODE_METADATA_UNAVAILABLE, "Detach with get regionid/instanceid error: "+err.Error())
 	}
 
 	// step 2: get diskid
@@ -216,17 +414,13 @@ func (p *DiskPlugin) Detach(volumeName string, nodeName string) utils.Result {
 	}
 
 	// Step 3: check disk
-	p.client.SetUserAgent(KUBERNETES_ALICLOUD_DISK_DRIVER + "/" + instanceId)
-	describeDisksRequest := &ecs.DescribeDisksArgs{
-		RegionId: common.Region(regionId),
-		DiskIds:  []string{diskId},
-	}
-	disks, _, err := p.client.DescribeDisks(describeDisksRequest)
+	disks, err := p.cloud.DescribeDisks(ctx, regionId, []string{diskId})
 	if err != nil {
-		utils.FinishError("Failed to list Volume: " + volumeName + ", DiskId: " + diskId + ", with error: " + err.Error())
+		return utils.FailWithError(err, utils.CODE_ECS_ERROR, "Failed to list Volume: "+volumeName+", DiskId: "+diskId+", with error: "+err.Error())
 	}
 	if len(disks) == 0 {
 		log.Info("No Need Detach, Volume: ", volumeName, ", DiskId: ", diskId, " is not exist")
+		p.finishJournal(volumeName)
 		return utils.Succeed()
 	}
 
@@ -236,64 +430,337 @@ func (p *DiskPlugin) Detach(volumeName string, nodeName string) utils.Result {
 		// only detach disk on self instance
 		if disk.InstanceId != instanceId {
 			log.Info("Skip Detach, Volume: ", volumeName, ", DiskId: ", diskId, " is attached on: ", disk.InstanceId)
+			p.finishJournal(volumeName)
 			return utils.Succeed()
 		}
 
-		// multi disk detach at the same time
-		// lck file created under /tmp/
-		lockfileName := "lockfile-disk.lck"
-		lock, err := lockfile.New(filepath.Join(os.TempDir(), lockfileName))
+		// the devices are changed by detach, wait for the attach discovering devices
+		lock, err := locking.Node(ctx, DEVICE_LOCK)
 		if err != nil {
-			utils.FinishError("Detach:: Lockfile New failed, DiskId: " + ", Volume: " + volumeName + ", err: " + err.Error())
+			return utils.FailWithError(err, utils.CODE_LOCK_TIMEOUT, "Detach:: Lock devices failed, DiskId: "+diskId+", Volume: "+volumeName+", err: "+err.Error())
 		}
-		err = lock.TryLock()
+		defer lock.Release()
+
+		pending, err := journal.Load(p.journalDir(), JOURNAL_DRIVER, volumeName)
+		if err != nil {
+			return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Load journal failed, Volume: "+volumeName+", err: "+err.Error())
+		}
+		if pending != nil && pending.State() == journal.STATE_RUNNING {
+			return utils.FailWithCode(utils.CODE_DISK_LOCKED, "Disk, Volume "+volumeName+" is in operation: "+pending.String())
+		}
+		op, err := journal.Begin(p.journalDir(), JOURNAL_DRIVER, "detach", volumeName, withRole(map[string]string{DATA_DISK_ID: disk.DiskId, DATA_INSTANCE_ID: instanceId}, role))
 		if err != nil {
-			utils.FinishError("Detach:: Lockfile failed, DiskId: " + volumeName + ", err: " + err.Error())
+			return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Write journal failed, Volume: "+volumeName+", err: "+err.Error())
+		}
+		defer endOperation(op, &result)
+		if err := op.Next(STEP_DETACHING, nil); err != nil {
+			return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Write journal failed, Volume: "+volumeName+", err: "+err.Error())
 		}
-		defer lock.Unlock()
 
-		err = p.client.DetachDisk(disk.InstanceId, disk.DiskId)
+		err = p.cloud.DetachDisk(ctx, disk.InstanceId, disk.DiskId)
 		if err != nil {
-			utils.FinishError("Disk, Failed to detach: " + err.Error())
+			return utils.FailWithError(err, utils.CODE_DISK_DETACH_FAILED, "Disk, Failed to detach: "+err.Error())
 		}
 	}
 
 	// step 5: remove volume config file
 	removeVolumeConfig(volumeName)
+	if disk.InstanceId == "" {
+		p.finishJournal(volumeName)
+	}
 
 	log.Info("Detach Successful, Volume: ", volumeName, ", DiskId: ", diskId, ", NodeName: ", nodeName)
 	return utils.Succeed()
 }
 
-// Mount Not Support
-func (p *DiskPlugin) Mount(opts interface{}, mountPath string) utils.Result {
-	return utils.NotSupport()
+// Mount bind mount the global device mount path to the pod volume path
+func (p *DiskPlugin) Mount(ctx context.Context, opts interface{}, mountPath string) utils.Result {
+	opt := opts.(*DiskOptions)
+	log.Infof("Disk Plugin Mount: %s", strings.Join(os.Args, ","))
+
+	readOnly, err := utils.IsReadOnly(opt.ReadWrite)
+	if err != nil {
+		return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "Disk, Mount with "+err.Error())
+	}
+	if mounted, err := utils.CheckMountMode(p.mountInfoFile(), mountPath, readOnly); err != nil {
+		return utils.FailWithError(err, utils.CODE_MOUNT_FAILED, "Disk, Mount failed: "+err.Error())
+	} else if mounted {
+		log.Infof("Disk, Mount Path Already Mounted: %s", mountPath)
+		return utils.Succeed()
+	}
+
+	// the global mount path is recorded by mountdevice, or found in mountinfo for the volume mounted before upgrade
+	deviceMountPath := p.findDeviceMountPath(opt.VolumeName)
+	if deviceMountPath == "" || !utils.IsMounted(p.mountInfoFile(), deviceMountPath) {
+		return utils.FailWithCode(utils.CODE_DISK_DEVICE_NOT_FOUND, "Disk, Mount failed as device is not mounted for Volume: "+opt.VolumeName+", DeviceMountPath: "+deviceMountPath)
+	}
+
+	if err := utils.CreateDest(mountPath); err != nil {
+		return utils.FailWithCode(utils.CODE_MOUNT_FAILED, "Disk, Mount error with create Path fail: "+mountPath+", with error: "+err.Error())
+	}
+
+	if _, err := utils.Run(ctx, p.executor(), "mount", "--bind", deviceMountPath, mountPath); err != nil {
+		return utils.FailWithCode(utils.CODE_MOUNT_FAILED, "Disk, Bind mount failed: "+err.Error())
+	}
+	// the read only flag of bind mount is set by remount, the old mount ignore "--bind -o ro"
+	if readOnly {
+		if _, err := utils.Run(ctx, p.executor(), "mount", "-o", "remount,bind,ro", mountPath); err != nil {
+			utils.Umount(ctx, p.executor(), mountPath)
+			return utils.FailWithCode(utils.CODE_MOUNT_FAILED, "Disk, Remount read only failed: "+err.Error())
+		}
+	}
+
+	log.Infof("Disk, Mount Successful: %s, %s", deviceMountPath, mountPath)
+	return utils.Succeed()
 }
 
-// Unmount Support, to fix umount bug;
-func (p *DiskPlugin) Unmount(mountPoint string) utils.Result {
+// Unmount umount the pod volume path
+func (p *DiskPlugin) Unmount(ctx context.Context, mountPoint string) utils.Result {
 	log.Infof("Disk, Starting to Unmount: %s", mountPoint)
 
-	p.doUnmount(mountPoint)
+	if err := UnmountMountPoint(ctx, p.executor(), p.mountInfoFile(), mountPoint); err != nil {
+		return utils.FailWithCode(utils.CODE_UNMOUNT_FAILED, "Disk, Failed to Unmount: "+mountPoint+" with error: "+err.Error())
+	}
 	log.Infof("Disk, Unmount Successful: %s", mountPoint)
 	return utils.Succeed()
 }
 
-func (p *DiskPlugin) doUnmount(mountPoint string) {
-	if err := UnmountMountPoint(mountPoint); err != nil {
-		utils.FinishError("Disk, Failed to Unmount: " + mountPoint + err.Error())
+// Mountdevice format the disk if needed, and mount it to the global device mount path
+// Mountdevice: mountPath: /var/lib/kubelet/plugins/kubernetes.io/flexvolume/alicloud/disk/mounts/d-2zefwuq9sv0gkxqrll5t
+// Mountdevice: devicePath: /dev/vdc, output of attach
+func (p *DiskPlugin) Mountdevice(ctx context.Context, mountPath, devicePath string, opts interface{}) utils.Result {
+	opt := opts.(*DiskOptions)
+	log.Infof("Disk Plugin Mountdevice: %s", strings.Join(os.Args, ","))
+
+	readOnly, err := utils.IsReadOnly(opt.ReadWrite)
+	if err != nil {
+		return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "Disk, Mountdevice with "+err.Error())
+	}
+	if mounted, err := utils.CheckMountMode(p.mountInfoFile(), mountPath, readOnly); err != nil {
+		return utils.FailWithError(err, utils.CODE_MOUNT_FAILED, "Disk, Mountdevice failed: "+err.Error())
+	} else if mounted {
+		log.Infof("Disk, Device Already Mounted: %s, %s", devicePath, mountPath)
+		if err := saveDeviceMountPath(opt.VolumeName, mountPath); err != nil {
+			return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Save device mount path failed: "+err.Error())
+		}
+		return utils.Succeed()
+	}
+	if devicePath == "" || !utils.IsFileExisting(devicePath) {
+		return utils.FailWithCode(utils.CODE_DISK_DEVICE_NOT_FOUND, "Disk, Mountdevice with illegal devicePath: "+devicePath+", Volume: "+opt.VolumeName)
+	}
+
+	if err := utils.CreateDest(mountPath); err != nil {
+		return utils.FailWithCode(utils.CODE_MOUNT_FAILED, "Disk, Mountdevice error with create Path fail: "+mountPath+", with error: "+err.Error())
+	}
+
+	// format the disk only the first time
+	fsType := opt.FsType
+	if fsType == "" {
+		fsType = DEFAULT_FSTYPE
+	}
+	existFsType, err := getDiskFormat(ctx, p.executor(), devicePath)
+	if err != nil {
+		return utils.FailWithCode(utils.CODE_DISK_FORMAT_FAILED, "Disk, Mountdevice check format failed: "+devicePath+", with error: "+err.Error())
+	}
+	if existFsType == "" && readOnly {
+		return utils.FailWithCode(utils.CODE_DISK_FORMAT_FAILED, "Disk, Mountdevice failed: the unformatted device "+devicePath+" can not be mounted read only")
+	}
+	if existFsType == "" {
+		if err := formatDisk(ctx, p.executor(), devicePath, fsType); err != nil {
+			return utils.FailWithCode(utils.CODE_DISK_FORMAT_FAILED, "Disk, Mountdevice format failed: "+devicePath+", with error: "+err.Error())
+		}
+		log.Infof("Disk, Format device successful: %s, %s", devicePath, fsType)
+	} else if existFsType != fsType {
+		log.Warnf("Disk, Device %s is formatted as %s, but %s is expected, mount with %s", devicePath, existFsType, fsType, existFsType)
+		fsType = existFsType
+	}
+
+	mntArgs := []string{"-t", fsType}
+	if readOnly {
+		mntArgs = append(mntArgs, "-o", utils.READ_ONLY)
+	}
+	if _, err := utils.Run(ctx, p.executor(), "mount", append(mntArgs, devicePath, mountPath)...); err != nil {
+		return utils.FailWithCode(utils.CODE_MOUNT_FAILED, "Disk, Mountdevice failed: "+err.Error())
+	}
+	if err := saveDeviceMountPath(opt.VolumeName, mountPath); err != nil {
+		return utils.FailWithCode(utils.CODE_INTERNAL, "Disk, Save device mount path failed: "+err.Error())
+	}
+
+	log.Infof("Disk, Mountdevice Successful: %s, %s", devicePath, mountPath)
+	return utils.Succeed()
+}
+
+// Unmountdevice umount the global device mount path
+func (p *DiskPlugin) Unmountdevice(ctx context.Context, mountPath string) utils.Result {
+	log.Infof("Disk Plugin Unmountdevice: %s", mountPath)
+
+	if err := UnmountMountPoint(ctx, p.executor(), p.mountInfoFile(), mountPath); err != nil {
+		return utils.FailWithCode(utils.CODE_UNMOUNT_FAILED, "Disk, Failed to Unmountdevice: "+mountPath+" with error: "+err.Error())
+	}
+	removeDeviceMountPath(filepath.Base(mountPath))
+
+	log.Infof("Disk, Unmountdevice Successful: %s", mountPath)
+	return utils.Succeed()
+}
+
+// ExpandVolume resize the cloud disk to the new size
+// ExpandVolume: newSize, oldSize: size in bytes, example: 21474836480
+func (p *DiskPlugin) ExpandVolume(ctx context.Context, opts interface{}, devicePath, newSize, oldSize string) utils.Result {
+	opt := opts.(*DiskOptions)
+	log.Infof("Disk Plugin ExpandVolume: %s", strings.Join(os.Args, ","))
+
+	newSizeGB, err := bytesToGB(newSize)
+	if err != nil {
+		return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Disk, ExpandVolume with illegal new size: "+newSize+", with error: "+err.Error())
+	}
+
+	// Step 1: init ecs client
+	if err := p.initCloud(ctx, opt.role()); err != nil {
+		return utils.FailWithError(err, utils.CODE_CREDENTIAL_MISSING, "Disk, Init ecs client error: "+err.Error())
+	}
+	regionId, _, err := p.cloud.Metadata(ctx)
+	if err != nil {
+		return utils.FailWithError(err, utils.CODE_METADATA_UNAVAILABLE, "ExpandVolume with get regionid/instanceid error: "+err.Error())
+	}
+
+	// Step 2: check disk size, skip if already expanded
+	disks, err := p.cloud.DescribeDisks(ctx, regionId, []string{opt.VolumeId})
+	if err != nil {
+		return utils.FailWithError(err, utils.CODE_ECS_ERROR, "ExpandVolume, Can not get disk: "+opt.VolumeId+", with error: "+err.Error())
+	}
+	if len(disks) == 0 {
+		return utils.FailWithCode(utils.CODE_DISK_NOT_FOUND, "ExpandVolume, Disk not exist: "+opt.VolumeId)
+	}
+	if disks[0].Size >= newSizeGB {
+		log.Infof("ExpandVolume, Disk %s is already %dGB, request %dGB", opt.VolumeId, disks[0].Size, newSizeGB)
+		return utils.Succeed()
+	}
+
+	// Step 3: resize disk
+	if err := p.cloud.ResizeDisk(ctx, opt.VolumeId, newSizeGB); err != nil {
+		return utils.FailWithError(err, utils.CODE_DISK_RESIZE_FAILED, "ExpandVolume, Resize disk failed: "+opt.VolumeId+", with error: "+err.Error())
+	}
+
+	// Step 4: wait for resize
+	pollTimes, pollInterval := diskPolling()
+	for i := 0; i < pollTimes; i++ {
+		disks, err := p.cloud.DescribeDisks(ctx, regionId, []string{opt.VolumeId})
+		if err != nil {
+			return utils.FailWithError(err, utils.CODE_ECS_ERROR, "ExpandVolume, Could not get Disk again "+opt.VolumeId+", with error: "+err.Error())
+		}
+		if len(disks) >= 1 && disks[0].Size >= newSizeGB {
+			break
+		}
+		if i == pollTimes-1 {
+			return utils.FailWithCode(utils.CODE_DISK_RESIZE_TIMEOUT, "ExpandVolume, Resize disk timeout: "+opt.VolumeId)
+		}
+		if err := utils.Sleep(ctx, pollInterval); err != nil {
+			return utils.FailWithCode(utils.CODE_CALL_TIMEOUT, "ExpandVolume, Wait for resize interrupted: "+opt.VolumeId+", with error: "+err.Error())
+		}
+	}
+
+	log.Infof("ExpandVolume Successful, DiskId: %s, Size: %dGB", opt.VolumeId, newSizeGB)
+	return utils.Succeed()
+}
+
+// ExpandFS grow the filesystem online after the block device shows the new size
+func (p *DiskPlugin) ExpandFS(ctx context.Context, opts interface{}, devicePath, deviceMountPath, newSize, oldSize string) utils.Result {
+	opt := opts.(*DiskOptions)
+	log.Infof("Disk Plugin ExpandFS: %s", strings.Join(os.Args, ","))
+
+	newSizeBytes, err := strconv.ParseInt(newSize, 10, 64)
+	if err != nil {
+		return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Disk, ExpandFS with illegal new size: "+newSize+", with error: "+err.Error())
 	}
 
-	// issue: below directory can not be umounted
-	// /var/lib/kubelet/plugins/kubernetes.io/flexvolume/alicloud/disk/mounts/d-2zefwuq9sv0gkxqrll5t
-	diskMntPath := "/var/lib/kubelet/plugins/kubernetes.io/flexvolume/alicloud/disk/mounts/" + filepath.Base(mountPoint)
-	if err := UnmountMountPoint(diskMntPath); err != nil {
-		utils.FinishError("Disk, Failed to Unmount: " + diskMntPath + " with error: " + err.Error())
+	// Step 1: wait for block device resized
+	pollTimes, pollInterval := diskPolling()
+	for i := 0; i < pollTimes; i++ {
+		deviceSize, err := getDeviceSize(ctx, p.executor(), devicePath)
+		if err != nil {
+			return utils.FailWithCode(utils.CODE_DISK_DEVICE_NOT_FOUND, "ExpandFS, Get device size failed: "+devicePath+", with error: "+err.Error())
+		}
+		if deviceSize >= newSizeBytes {
+			break
+		}
+		if i == pollTimes-1 {
+			return utils.FailWithCode(utils.CODE_DISK_RESIZE_TIMEOUT, "ExpandFS, Wait device resize timeout: "+devicePath+", Volume: "+opt.VolumeName)
+		}
+		if err := utils.Sleep(ctx, pollInterval); err != nil {
+			return utils.FailWithCode(utils.CODE_CALL_TIMEOUT, "ExpandFS, Wait device resize interrupted: "+devicePath+", with error: "+err.Error())
+		}
+	}
+
+	// Step 2: grow filesystem
+	fsType, err := getDiskFormat(ctx, p.executor(), devicePath)
+	if err != nil {
+		return utils.FailWithCode(utils.CODE_DISK_FORMAT_FAILED, "ExpandFS, Check format failed: "+devicePath+", with error: "+err.Error())
+	}
+	var resizeCmd utils.Command
+	switch fsType {
+	case "ext3", "ext4":
+		resizeCmd = utils.NewCommand("resize2fs", devicePath)
+	case "xfs":
+		resizeCmd = utils.NewCommand("xfs_growfs", deviceMountPath)
+	default:
+		return utils.FailWithCode(utils.CODE_DISK_RESIZE_FAILED, "ExpandFS, Not support filesystem: "+fsType+", device: "+devicePath)
+	}
+	if _, _, err := p.executor().Execute(ctx, resizeCmd); err != nil {
+		return utils.FailWithCode(utils.CODE_DISK_RESIZE_FAILED, "ExpandFS, Grow filesystem failed: "+err.Error())
+	}
+
+	log.Infof("ExpandFS Successful, Volume: %s, Device: %s, Size: %s", opt.VolumeName, devicePath, newSize)
+	return utils.Succeed()
+}
+
+// convert size in bytes to GB, round up
+func bytesToGB(size string) (int, error) {
+	sizeBytes, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if sizeBytes <= 0 {
+		return 0, fmt.Errorf("size should be positive: %s", size)
+	}
+	return int((sizeBytes + GB_SIZE - 1) / GB_SIZE), nil
+}
+
+// get the block device size in bytes
+func getDeviceSize(ctx context.Context, executor utils.Executor, devicePath string) (int64, error) {
+	out, err := utils.Run(ctx, executor, "blockdev", "--getsize64", devicePath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+}
+
+// get the filesystem type of the device, empty for no filesystem
+func getDiskFormat(ctx context.Context, executor utils.Executor, devicePath string) (string, error) {
+	out, err := utils.Run(ctx, executor, "lsblk", "-n", "-o", "FSTYPE", devicePath)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) > 1 {
+		return "", fmt.Errorf("device %s has partitions, cannot be used as volume", devicePath)
 	}
+	return strings.TrimSpace(lines[0]), nil
 }
 
-// UnmountMountPoint Unmount host mount path
-func UnmountMountPoint(mountPath string) error {
+// format device with the fsType
+func formatDisk(ctx context.Context, executor utils.Executor, devicePath, fsType string) error {
+	force := "-F"
+	if fsType == "xfs" {
+		force = "-f"
+	}
+	cmd := utils.NewCommand("mkfs."+fsType, force, devicePath)
+	cmd.Timeout = FORMAT_TIMEOUT
+	_, _, err := executor.Execute(ctx, cmd)
+	return err
+}
+
+// UnmountMountPoint Unmount host mount path, the mount point is checked in the mountinfo file
+func UnmountMountPoint(ctx context.Context, executor utils.Executor, mountInfoFile, mountPath string) error {
 	// check mountpath is exist
 	if pathExists, pathErr := utils.PathExists(mountPath); pathErr != nil {
 		return pathErr
@@ -302,38 +769,27 @@ func UnmountMountPoint(mountPath string) error {
 	}
 
 	// check mountPath is mountPoint
-	var notMnt bool
-	var err error
-	notMnt, err = utils.IsLikelyNotMountPoint(mountPath)
-	if err != nil {
-		return err
-	}
-	if notMnt {
+	if !utils.IsMounted(mountInfoFile, mountPath) {
 		log.Warningf("Warning: %q is not a mountpoint, deleting", mountPath)
 		return os.Remove(mountPath)
 	}
 
 	// Unmount the mount path
-	mntCmd := fmt.Sprintf("umount -f %s", mountPath)
-	if _, err := utils.Run(mntCmd); err != nil {
+	if _, err := utils.Run(ctx, executor, "umount", "-f", mountPath); err != nil {
 		return err
 	}
-	notMnt, mntErr := utils.IsLikelyNotMountPoint(mountPath)
-	if mntErr != nil {
-		return err
+	if utils.IsMounted(mountInfoFile, mountPath) {
+		return fmt.Errorf("Failed to unmount path")
 	}
-	if notMnt {
-		if err := os.Remove(mountPath); err != nil {
-			log.Warningf("Warning: deleting mountPath %s, with error: %s", mountPath, err.Error())
-			return err
-		}
-		return nil
+	if err := os.Remove(mountPath); err != nil {
+		log.Warningf("Warning: deleting mountPath %s, with error: %s", mountPath, err.Error())
+		return err
 	}
-	return fmt.Errorf("Failed to unmount path")
+	return nil
 }
 
 // Getvolumename Support
-func (p *DiskPlugin) Getvolumename(opts interface{}) utils.Result {
+func (p *DiskPlugin) Getvolumename(ctx context.Context, opts interface{}) utils.Result {
 	opt := opts.(*DiskOptions)
 	return utils.Result{
 		Status:     "Success",
@@ -342,25 +798,24 @@ func (p *DiskPlugin) Getvolumename(opts interface{}) utils.Result {
 }
 
 // Waitforattach Not Support
-func (p *DiskPlugin) Waitforattach(devicePath string, opts interface{}) utils.Result {
+func (p *DiskPlugin) Waitforattach(ctx context.Context, devicePath string, opts interface{}) utils.Result {
 	opt := opts.(*DiskOptions)
 	if devicePath == "" {
-		utils.FinishError("Waitforattach, devicePath is empty, cannot used for Volume: " + opt.VolumeName)
+		return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Waitforattach, devicePath is empty, cannot used for Volume: "+opt.VolumeName)
 	}
 	if !utils.IsFileExisting(devicePath) {
-		utils.FinishError("Waitforattach, devicePath: " + devicePath + " is not exist, cannot used for Volume: " + opt.VolumeName)
+		return utils.FailWithCode(utils.CODE_DISK_DEVICE_NOT_FOUND, "Waitforattach, devicePath: "+devicePath+" is not exist, cannot used for Volume: "+opt.VolumeName)
 	}
 
 	// check the device is used for system
 	if devicePath == "/dev/vda" || devicePath == "/dev/vda1" {
-		utils.FinishError("Waitforattach, devicePath: " + devicePath + " is system device, cannot used for Volume: " + opt.VolumeName)
+		return utils.FailWithCode(utils.CODE_DISK_DEVICE_IN_USE, "Waitforattach, devicePath: "+devicePath+" is system device, cannot used for Volume: "+opt.VolumeName)
 	}
 	if devicePath == "/dev/vdb1" {
-		checkCmd := fmt.Sprintf("mount | grep \"/dev/vdb1 on /var/lib/kubelet type\" | wc -l")
-		if out, err := utils.Run(checkCmd); err != nil {
-			utils.FinishError("Waitforattach, devicePath: " + devicePath + " is check vdb error for Volume: " + opt.VolumeName)
-		} else if strings.TrimSpace(out) != "0" {
-			utils.FinishError("Waitforattach, devicePath: " + devicePath + " is used as DataDisk for kubelet,  cannot used fo Volume: " + opt.VolumeName)
+		if table, err := mountinfo.Load(p.mountInfoFile()); err != nil {
+			return utils.FailWithCode(utils.CODE_INTERNAL, "Waitforattach, devicePath: "+devicePath+" is check vdb error for Volume: "+opt.VolumeName)
+		} else if mount, ok := table.ByMountPoint("/var/lib/kubelet"); ok && mount.Source == devicePath {
+			return utils.FailWithCode(utils.CODE_DISK_DEVICE_IN_USE, "Waitforattach, devicePath: "+devicePath+" is used as DataDisk for kubelet,  cannot used fo Volume: "+opt.VolumeName)
 		}
 	}
 
@@ -371,55 +826,16 @@ func (p *DiskPlugin) Waitforattach(devicePath string, opts interface{}) utils.Re
 	}
 }
 
-// Mountdevice Not Support
-func (p *DiskPlugin) Mountdevice(mountPath string, opts interface{}) utils.Result {
-	return utils.NotSupport()
-}
-
-//
-func (p *DiskPlugin) initEcsClient() {
-	accessKeyID, accessSecret, accessToken, ecsEndpoint := "", "", "", ""
-	// Apsara Stack use local config file
-	accessKeyID, accessSecret, ecsEndpoint = p.GetDiskLocalConfig()
-
-	// the common environment
-	if accessKeyID == "" || accessSecret == "" {
-		accessKeyID, accessSecret, accessToken = utils.GetDefaultAK()
-	}
-
-	p.client = newEcsClient(accessKeyID, accessSecret, accessToken, ecsEndpoint)
-	if p.client == nil {
-		utils.FinishError("New Ecs Client error, ak_id: " + accessKeyID)
-	}
-}
-
-// GetDiskLocalConfig read disk config from local file
-func (p *DiskPlugin) GetDiskLocalConfig() (string, string, string) {
-	accessKeyID, accessSecret, ecsEndpoint := "", "", ""
-
-	if utils.IsFileExisting(DISK_AKID) && utils.IsFileExisting(DISK_AKSECRET) && utils.IsFileExisting(DISK_ECSENPOINT) {
-		raw, err := ioutil.ReadFile(DISK_AKID)
-		if err != nil {
-			log.Error("Read disk AK ID file error:", err.Error())
-			return "", "", ""
-		}
-		accessKeyID = string(raw)
-
-		raw, err = ioutil.ReadFile(DISK_AKSECRET)
-		if err != nil {
-			log.Error("Read disk AK Secret file error:", err.Error())
-			return "", "", ""
-		}
-		accessSecret = string(raw)
-
-		raw, err = ioutil.ReadFile(DISK_ECSENPOINT)
-		if err != nil {
+// getDiskEndpoint read the ecs endpoint of Apsara Stack under root, the daemon read the file of host
+func getDiskEndpoint(root string) string {
+	raw, err := ioutil.ReadFile(path.Join(root, DISK_ECSENPOINT))
+	if err != nil {
+		if !os.IsNotExist(err) {
 			log.Error("Read disk ecs Endpoint file error:", err.Error())
-			return "", "", ""
 		}
-		ecsEndpoint = string(raw)
+		return ""
 	}
-	return strings.TrimSpace(accessKeyID), strings.TrimSpace(accessSecret), strings.TrimSpace(ecsEndpoint)
+	return strings.TrimSpace(string(raw))
 }
 
 func getDevicePath(before, after []string) []string {
@@ -438,28 +854,35 @@ func getDevicePath(before, after []string) []string {
 	return devicePaths
 }
 
-// endpoint: env variable first; /etc/.volumeak/diskEcsEndpoint second, overseas region third;
-func newEcsClient(accessKeyId, accessKeySecret, accessToken, ecsEndpoint string) *ecs.Client {
-	m := metadata.NewMetaData(nil)
-	region, err := m.Region()
+// newEcsClient resolve the endpoint: the config first; /etc/.volumeak/diskEcsEndpoint second, the location service and table of region third
+func newEcsClient(ctx context.Context, cred *credentials.Credential, localEndpoint string) *ecs.Client {
+	region, err := metadata.RegionID(ctx)
 	if err != nil {
 		region = string(DEFAULT_REGION)
 	}
 
-	// use environment endpoint first;
-	if ep := os.Getenv("ECS_ENDPOINT"); ep != "" {
-		ecsEndpoint = ep
+	ecsEndpoint, err := endpoint.Resolve(ctx, endpoint.SERVICE_ECS, endpoint.Options{Region: region, Local: localEndpoint, Credential: cred})
+	if err != nil {
+		log.Warnf("Disk, resolve ecs endpoint error: %s, use the sdk default", err.Error())
+	} else {
+		ecsEndpoint = endpoint.URL(ecsEndpoint)
 	}
 
-	client := ecs.NewECSClientWithEndpointAndSecurityToken(ecsEndpoint, accessKeyId, accessKeySecret, accessToken, common.Region(region))
+	client := ecs.NewECSClientWithEndpointAndSecurityToken(ecsEndpoint, cred.AccessKeyID, cred.AccessKeySecret, cred.SecurityToken, common.Region(region))
 	client.SetUserAgent(KUBERNETES_ALICLOUD_IDENTITY)
 
 	return client
 }
 
+// diskPolling return the times and interval to wait for the disk status
+func diskPolling() (int, time.Duration) {
+	cfg := config.Get()
+	return cfg.DiskPollTimes, time.Duration(cfg.DiskPollInterval)
+}
+
 // get diskID
 func getVolumeConfig(volumeName string) string {
-	volumeFile := path.Join(VolumeDir, volumeName+".conf")
+	volumeFile := path.Join(volumeDir, volumeName+".conf")
 	if !utils.IsFileExisting(volumeFile) {
 		return ""
 	}
@@ -475,29 +898,114 @@ func getVolumeConfig(volumeName string) string {
 
 // save diskID and volume name
 func saveVolumeConfig(opt *DiskOptions) error {
-	if err := utils.CreateDest(VolumeDir); err != nil {
+	if err := utils.CreateDest(volumeDir); err != nil {
 		return err
 	}
-	if err := utils.CreateDest(VolumeDirRemove); err != nil {
+	if err := utils.CreateDest(volumeRemoveDir); err != nil {
 		return err
 	}
 	if err := removeVolumeConfig(opt.VolumeName); err != nil {
 		return err
 	}
 
-	volumeFile := path.Join(VolumeDir, opt.VolumeName+".conf")
-	return ioutil.WriteFile(volumeFile, []byte(opt.VolumeId), 0644)
+	volumeFile := path.Join(volumeDir, opt.VolumeName+".conf")
+	if err := ioutil.WriteFile(volumeFile, []byte(opt.VolumeId), 0644); err != nil {
+		return err
+	}
+	if role := opt.role(); role != nil {
+		raw, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path.Join(volumeDir, opt.VolumeName+".role"), raw, 0644)
+	}
+	return nil
+}
+
+// get the role of volume saved by attach, detach is called without the volume options
+func getVolumeRole(volumeName string) *credentials.Role {
+	raw, err := ioutil.ReadFile(path.Join(volumeDir, volumeName+".role"))
+	if err != nil {
+		return nil
+	}
+	role := &credentials.Role{}
+	if err := json.Unmarshal(raw, role); err != nil || role.Arn == "" {
+		log.Warnf("Disk, Ignore the illegal role of volume %s: %s", volumeName, string(raw))
+		return nil
+	}
+	return role
 }
 
 // move config file to remove dir
 func removeVolumeConfig(volumeName string) error {
-	volumeFile := path.Join(VolumeDir, volumeName+".conf")
+	volumeFile := path.Join(volumeDir, volumeName+".conf")
 	if utils.IsFileExisting(volumeFile) {
 		timeStr := time.Now().Format("2006-01-02-15:04:05")
-		removeFile := path.Join(VolumeDirRemove, volumeName+"-"+timeStr+".conf")
+		removeFile := path.Join(volumeRemoveDir, volumeName+"-"+timeStr+".conf")
 		if err := os.Rename(volumeFile, removeFile); err != nil {
 			return err
 		}
 	}
+	roleFile := path.Join(volumeDir, volumeName+".role")
+	if err := os.Remove(roleFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
+
+// get the global device mount path saved by mountdevice
+func getDeviceMountPath(volumeName string) string {
+	mountFile := path.Join(volumeDir, volumeName+".mnt")
+	value, err := ioutil.ReadFile(mountFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// findDeviceMountPath return the global device mount path of volume. The path not recorded by mountdevice of
+// the old version is found in mountinfo by the volume name under kubelet, or by the device of disk, and recorded.
+func (p *DiskPlugin) findDeviceMountPath(volumeName string) string {
+	if deviceMountPath := getDeviceMountPath(volumeName); deviceMountPath != "" {
+		return deviceMountPath
+	}
+	table, err := mountinfo.Load(p.mountInfoFile())
+	if err != nil {
+		log.Warnf("Disk, Load mountinfo error: %s", err.Error())
+		return ""
+	}
+	device := ""
+	if diskId := getVolumeConfig(volumeName); diskId != "" {
+		device, _ = diskDevice(diskId)
+	}
+	for _, mount := range table {
+		if !strings.HasSuffix(filepath.Dir(mount.MountPoint), DEVICE_MOUNT_DIR) {
+			continue
+		}
+		if filepath.Base(mount.MountPoint) == volumeName || device != "" && mount.Source == device {
+			log.Infof("Disk, Found the device mount path of Volume %s in mountinfo: %s", volumeName, mount.MountPoint)
+			if err := saveDeviceMountPath(volumeName, mount.MountPoint); err != nil {
+				log.Warnf("Disk, Save device mount path failed: %s", err.Error())
+			}
+			return mount.MountPoint
+		}
+	}
+	return ""
+}
+
+// save the global device mount path for volume
+func saveDeviceMountPath(volumeName, mountPath string) error {
+	if err := utils.CreateDest(volumeDir); err != nil {
+		return err
+	}
+	mountFile := path.Join(volumeDir, volumeName+".mnt")
+	return ioutil.WriteFile(mountFile, []byte(mountPath), 0644)
+}
+
+// remove the global device mount path record
+func removeDeviceMountPath(volumeName string) {
+	mountFile := path.Join(volumeDir, volumeName+".mnt")
+	if err := os.Remove(mountFile); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Remove device mount path record failed: %s, %s", mountFile, err.Error())
+	}
+}
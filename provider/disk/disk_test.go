@@ -1,6 +1,21 @@
 package disk
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/journal"
+	"github.com/AliyunContainerService/flexvolume/provider/locking"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	"github.com/denverdino/aliyungo/ecs"
+)
 
 func TestGetDevicePath(t *testing.T) {
 
@@ -11,3 +26,497 @@ func TestGetDevicePath(t *testing.T) {
 	devices := getDevicePath(before, after)
 	t.Log(devices)
 }
+
+func TestBytesToGB(t *testing.T) {
+	cases := map[string]int{
+		"21474836480": 20,
+		"21474836481": 21,
+		"1":           1,
+	}
+	for size, expect := range cases {
+		if got, err := bytesToGB(size); err != nil || got != expect {
+			t.Errorf("bytesToGB(%s) = %d, %v; expect %d", size, got, err, expect)
+		}
+	}
+	if _, err := bytesToGB("-1"); err == nil {
+		t.Errorf("bytesToGB(-1) should fail")
+	}
+}
+
+// fakeCloud count the api calls, attach fails with attachErr if set
+type fakeCloud struct {
+	calls     map[string]int
+	disk      diskInfo
+	attachErr error
+}
+
+func (c *fakeCloud) Metadata(ctx context.Context) (string, string, error) {
+	c.calls["metadata"]++
+	return "cn-hangzhou", "i-1", nil
+}
+func (c *fakeCloud) DescribeDisks(ctx context.Context, regionId string, diskIds []string) ([]diskInfo, error) {
+	c.calls["describe"]++
+	return []diskInfo{c.disk}, nil
+}
+func (c *fakeCloud) AttachDisk(ctx context.Context, instanceId, diskId string) error {
+	c.calls["attach"]++
+	if c.attachErr != nil {
+		return c.attachErr
+	}
+	c.disk.InstanceId, c.disk.Status = instanceId, ecs.DiskStatusInUse
+	return nil
+}
+func (c *fakeCloud) DetachDisk(ctx context.Context, instanceId, diskId string) error {
+	c.calls["detach"]++
+	return errors.New("not implemented")
+}
+func (c *fakeCloud) ResizeDisk(ctx context.Context, diskId string, size int) error {
+	return errors.New("not implemented")
+}
+
+func TestCachedCloud(t *testing.T) {
+	fake := &fakeCloud{calls: map[string]int{}, disk: diskInfo{DiskId: "d-1", Status: ecs.DiskStatusAvailable}}
+	created := 0
+	cloud := newCachedCloud(func(ctx context.Context) (diskCloud, error) {
+		created++
+		return fake, nil
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if region, instance, err := cloud.Metadata(ctx); err != nil || region != "cn-hangzhou" || instance != "i-1" {
+			t.Fatalf("metadata error: %s, %s, %v", region, instance, err)
+		}
+		if disks, err := cloud.DescribeDisks(ctx, "cn-hangzhou", []string{"d-1"}); err != nil || disks[0].Status != ecs.DiskStatusAvailable {
+			t.Fatalf("describe error: %+v, %v", disks, err)
+		}
+	}
+	if fake.calls["metadata"] != 1 || fake.calls["describe"] != 1 || created != 1 {
+		t.Errorf("metadata and disks should be cached: %v, client created %d", fake.calls, created)
+	}
+
+	// the cached disks are dropped after attach
+	if err := cloud.AttachDisk(ctx, "i-1", "d-1"); err != nil {
+		t.Fatalf("attach error: %v", err)
+	}
+	if disks, _ := cloud.DescribeDisks(ctx, "cn-hangzhou", []string{"d-1"}); disks[0].Status != ecs.DiskStatusInUse || fake.calls["describe"] != 2 {
+		t.Errorf("expect describe again after attach, got %+v, %v", disks, fake.calls)
+	}
+	if err := cloud.DetachDisk(ctx, "i-1", "d-1"); err == nil || err.Error() != "not implemented" {
+		t.Errorf("expect the error of cloud, got %v", err)
+	}
+}
+
+func TestCloudOf(t *testing.T) {
+	role := &credentials.Role{Arn: "acs:ram::1234:role/storage"}
+	if cloudOf(nil) != nodeCloud {
+		t.Errorf("expect node cloud without role")
+	}
+	cloud := cloudOf(role)
+	if cloud == nodeCloud || cloudOf(&credentials.Role{Arn: role.Arn}) != cloud || cloud.queue != nodeCloud.queue {
+		t.Errorf("expect the cloud of role shared and queued with node")
+	}
+	if cloudOf(&credentials.Role{Arn: role.Arn, SessionName: "team-a"}) == cloud {
+		t.Errorf("expect the cloud of session")
+	}
+
+	// the role is kept in journal for the recoverer
+	op := &journal.Operation{Data: withRole(map[string]string{DATA_DISK_ID: "d-1"}, role)}
+	if got := roleOf(op); got == nil || *got != *role {
+		t.Errorf("expect role of operation, got %+v", got)
+	}
+	if roleOf(&journal.Operation{Data: map[string]string{DATA_DISK_ID: "d-1"}}) != nil {
+		t.Errorf("expect no role of operation")
+	}
+}
+
+func TestCanResume(t *testing.T) {
+	op := &journal.Operation{
+		Verb: "attach",
+		Step: STEP_ATTACHED,
+		Data: map[string]string{DATA_DISK_ID: "d-1", DATA_INSTANCE_ID: "i-1", DATA_DEVICES: "vda,vda1"},
+	}
+	attached := diskInfo{DiskId: "d-1", InstanceId: "i-1", Status: ecs.DiskStatusInUse}
+	if !canResume(op, "d-1", "i-1", attached) {
+		t.Fatalf("attached disk should be resumed")
+	}
+	if canResume(op, "d-1", "i-2", attached) || canResume(op, "d-2", "i-1", attached) {
+		t.Fatalf("other disk or instance should not be resumed")
+	}
+	if canResume(op, "d-1", "i-1", diskInfo{DiskId: "d-1", Status: ecs.DiskStatusAvailable}) {
+		t.Fatalf("detached disk should not be resumed")
+	}
+	op.Step = STEP_DETACHING
+	if canResume(op, "d-1", "i-1", attached) {
+		t.Fatalf("disk attached by others should not be resumed")
+	}
+	if devices := splitDevices("vda,vda1"); len(devices) != 2 || len(splitDevices("")) != 0 {
+		t.Fatalf("unexpected devices: %v", devices)
+	}
+}
+
+func TestRetryFailedOperation(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.cleanup()
+	locking.SetDir(filepath.Join(node.dir, "locks"))
+	defer locking.SetDir(locking.LOCK_DIR)
+
+	// the plugin is kept as the daemon process, the failed calls are retried in it
+	cloud := &fakeCloud{calls: map[string]int{}, attachErr: errors.New("throttling"), disk: diskInfo{DiskId: "d-1", Status: ecs.DiskStatusAvailable}}
+	plugin := node.plugin()
+	plugin.cloud, plugin.journal = cloud, filepath.Join(node.dir, "journal")
+	opt := &DiskOptions{VolumeName: "pv-1", VolumeId: "d-1"}
+	for i := 1; i <= 2; i++ {
+		result := plugin.Attach(context.Background(), opt, "cn-hangzhou.i-1")
+		if result.Status != "Failure" || result.Code != utils.CODE_DISK_ATTACH_FAILED || cloud.calls["attach"] != i {
+			t.Fatalf("attach %d: %+v, attach calls: %d", i, result, cloud.calls["attach"])
+		}
+		op, err := journal.Load(plugin.journal, JOURNAL_DRIVER, "pv-1")
+		if err != nil || op == nil || op.State() != journal.STATE_ABANDONED {
+			t.Fatalf("attach %d: expect the abandoned operation, got %v, %v", i, op, err)
+		}
+	}
+
+	cloud.disk = diskInfo{DiskId: "d-1", InstanceId: "i-1", Status: ecs.DiskStatusInUse}
+	for i := 1; i <= 2; i++ {
+		result := plugin.Detach(context.Background(), "pv-1", "cn-hangzhou.i-1")
+		if result.Status != "Failure" || result.Code != utils.CODE_DISK_DETACH_FAILED || cloud.calls["detach"] != i {
+			t.Fatalf("detach %d: %+v, detach calls: %d", i, result, cloud.calls["detach"])
+		}
+		op, err := journal.Load(plugin.journal, JOURNAL_DRIVER, "pv-1")
+		if err != nil || op == nil || op.Verb != "detach" || op.State() != journal.STATE_ABANDONED {
+			t.Fatalf("detach %d: expect the abandoned operation, got %v, %v", i, op, err)
+		}
+	}
+}
+
+// fakeNode is the device, mountinfo and volume dir of the plugin, the mount and umount commands change the mountinfo
+type fakeNode struct {
+	dir       string
+	device    string
+	mountInfo string
+	fsType    string
+	size      string
+	exec      *utils.FakeExecutor
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	node := &fakeNode{dir: dir, device: filepath.Join(dir, "vdc"), mountInfo: filepath.Join(dir, "mountinfo")}
+	ioutil.WriteFile(node.device, nil, 0644)
+	ioutil.WriteFile(node.mountInfo, nil, 0644)
+	volumeDir, volumeRemoveDir = filepath.Join(dir, "volumes"), filepath.Join(dir, "volumes", "remove")
+	node.exec = &utils.FakeExecutor{Handler: node.handle}
+	return node
+}
+
+func (n *fakeNode) cleanup() {
+	volumeDir, volumeRemoveDir = VolumeDir, VolumeDirRemove
+	os.RemoveAll(n.dir)
+}
+
+func (n *fakeNode) plugin() *DiskPlugin {
+	return &DiskPlugin{exec: n.exec, mountInfo: n.mountInfo}
+}
+
+func (n *fakeNode) handle(cmd utils.Command) (string, string, error) {
+	switch {
+	case cmd.Name == "lsblk":
+		return n.fsType + "\n", "", nil
+	case strings.HasPrefix(cmd.Name, "mkfs."):
+		n.fsType = strings.TrimPrefix(cmd.Name, "mkfs.")
+	case cmd.Name == "blockdev":
+		return n.size + "\n", "", nil
+	case cmd.Name == "mount":
+		target := cmd.Args[len(cmd.Args)-1]
+		mode := "rw"
+		if strings.Contains(strings.Join(cmd.Args, " "), "-o ro") {
+			mode = "ro"
+		}
+		line := fmt.Sprintf("40 22 253:32 / %s %s,relatime shared:1 - %s %s %s\n", escapeMountPoint(target), mode, n.fsType, escapeMountPoint(n.device), mode)
+		raw, _ := ioutil.ReadFile(n.mountInfo)
+		ioutil.WriteFile(n.mountInfo, append(raw, line...), 0644)
+	case cmd.Name == "umount":
+		target := cmd.Args[len(cmd.Args)-1]
+		raw, _ := ioutil.ReadFile(n.mountInfo)
+		lines := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+			if line != "" && strings.Fields(line)[4] != escapeMountPoint(target) {
+				lines = append(lines, line+"\n")
+			}
+		}
+		ioutil.WriteFile(n.mountInfo, []byte(strings.Join(lines, "")), 0644)
+	}
+	return "", "", nil
+}
+
+// escapeMountPoint escape the spaces of path in mountinfo
+func escapeMountPoint(path string) string {
+	return strings.Replace(path, " ", "\\040", -1)
+}
+
+func TestMountdevice(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.cleanup()
+	plugin := node.plugin()
+	mountPath := filepath.Join(node.dir, "mounts", "d-1")
+	opt := &DiskOptions{VolumeName: "d-1", VolumeId: "d-1"}
+
+	if result := plugin.Mountdevice(context.Background(), mountPath, node.device, opt); result.Status != "Success" {
+		t.Fatalf("mountdevice failed: %s", result.Message)
+	}
+	expect := []string{
+		"lsblk -n -o FSTYPE " + node.device,
+		"mkfs.ext4 -F " + node.device,
+		"mount -t ext4 " + node.device + " " + mountPath,
+	}
+	if lines := node.exec.CommandLines(); strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("commands: %q, expect: %q", lines, expect)
+	}
+	if got := getDeviceMountPath("d-1"); got != mountPath {
+		t.Errorf("device mount path: %q, expect: %q", got, mountPath)
+	}
+
+	// the second call find the mount and do nothing
+	if result := plugin.Mountdevice(context.Background(), mountPath, node.device, opt); result.Status != "Success" {
+		t.Fatalf("second mountdevice failed: %s", result.Message)
+	}
+	if lines := node.exec.CommandLines(); len(lines) != len(expect) {
+		t.Errorf("second mountdevice run commands: %q", lines[len(expect):])
+	}
+
+	// the read only mount is not reused as read write
+	opt.ReadWrite = utils.READ_ONLY
+	if result := plugin.Mountdevice(context.Background(), mountPath, node.device, opt); result.Code != utils.CODE_MOUNT_MODE_MISMATCH {
+		t.Errorf("mountdevice in another mode: %+v", result)
+	}
+}
+
+func TestMountdeviceFormatted(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.cleanup()
+	node.fsType = "xfs"
+	mountPath := filepath.Join(node.dir, "mounts", "d-1")
+	opt := &DiskOptions{VolumeName: "d-1", VolumeId: "d-1", ReadWrite: utils.READ_ONLY}
+
+	if result := node.plugin().Mountdevice(context.Background(), mountPath, node.device, opt); result.Status != "Success" {
+		t.Fatalf("mountdevice failed: %s", result.Message)
+	}
+	// mkfs is skipped, and the disk is mounted read only with the existing filesystem
+	expect := []string{
+		"lsblk -n -o FSTYPE " + node.device,
+		"mount -t xfs -o ro " + node.device + " " + mountPath,
+	}
+	if lines := node.exec.CommandLines(); strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("commands: %q, expect: %q", lines, expect)
+	}
+}
+
+func TestMountUpgraded(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.cleanup()
+	deviceByIdDir = filepath.Join(node.dir, "by-id")
+	defer func() { deviceByIdDir = DEVICE_BY_ID_DIR }()
+	os.MkdirAll(deviceByIdDir, 0755)
+	vdd := filepath.Join(node.dir, "vdd")
+	ioutil.WriteFile(vdd, nil, 0644)
+	os.Symlink(vdd, filepath.Join(deviceByIdDir, "virtio-2"))
+	saveVolumeConfig(&DiskOptions{VolumeName: "pv-2", VolumeId: "d-2"})
+	node.fsType = "ext4"
+	plugin := node.plugin()
+
+	// the device mounted by the old version without the record of device mount path
+	mounts := filepath.Join(node.dir, "kubelet", DEVICE_MOUNT_DIR)
+	cases := []struct {
+		volume          string
+		device          string
+		deviceMountPath string
+	}{
+		{"pv-1", node.device, filepath.Join(mounts, "pv-1")},
+		{"pv-2", vdd, filepath.Join(mounts, "d-2")},
+	}
+	for _, c := range cases {
+		node.device = c.device
+		node.handle(utils.NewCommand("mount", node.device, c.deviceMountPath))
+		mountPath := filepath.Join(node.dir, "pods", c.volume)
+		if result := plugin.Mount(context.Background(), &DiskOptions{VolumeName: c.volume}, mountPath); result.Status != "Success" {
+			t.Fatalf("mount %s failed: %s", c.volume, result.Message)
+		}
+		expect := "mount --bind " + c.deviceMountPath + " " + mountPath
+		if lines := node.exec.CommandLines(); lines[len(lines)-1] != expect {
+			t.Errorf("mount %s commands: %q, expect: %q", c.volume, lines, expect)
+		}
+		if got := getDeviceMountPath(c.volume); got != c.deviceMountPath {
+			t.Errorf("device mount path of %s: %q, expect: %q", c.volume, got, c.deviceMountPath)
+		}
+	}
+
+	// the device not mounted is not found
+	if result := plugin.Mount(context.Background(), &DiskOptions{VolumeName: "pv-3"}, filepath.Join(node.dir, "pods", "pv-3")); result.Code != utils.CODE_DISK_DEVICE_NOT_FOUND {
+		t.Errorf("mount without device: %+v", result)
+	}
+}
+
+func TestMountdeviceReadOnlyUnformatted(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.cleanup()
+	mountPath := filepath.Join(node.dir, "mounts", "d-1")
+	opt := &DiskOptions{VolumeName: "d-1", VolumeId: "d-1", ReadWrite: utils.READ_ONLY}
+
+	if result := node.plugin().Mountdevice(context.Background(), mountPath, node.device, opt); result.Code != utils.CODE_DISK_FORMAT_FAILED {
+		t.Errorf("read only mount of unformatted disk: %+v", result)
+	}
+	for _, line := range node.exec.CommandLines() {
+		if strings.HasPrefix(line, "mkfs") || strings.HasPrefix(line, "mount") {
+			t.Errorf("unexpected command: %s", line)
+		}
+	}
+}
+
+func TestUnmountdevice(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.cleanup()
+	node.fsType = "ext4"
+	plugin := node.plugin()
+	mountPath := filepath.Join(node.dir, "mounts", "d-1")
+	if result := plugin.Mountdevice(context.Background(), mountPath, node.device, &DiskOptions{VolumeName: "d-1"}); result.Status != "Success" {
+		t.Fatalf("mountdevice failed: %s", result.Message)
+	}
+
+	for i := 0; i < 2; i++ {
+		if result := plugin.Unmountdevice(context.Background(), mountPath); result.Status != "Success" {
+			t.Fatalf("unmountdevice failed: %s", result.Message)
+		}
+	}
+	// only the first call umount, the second call find the path removed
+	umounts := 0
+	for _, line := range node.exec.CommandLines() {
+		if strings.HasPrefix(line, "umount") {
+			umounts++
+			if line != "umount -f "+mountPath {
+				t.Errorf("umount command: %s", line)
+			}
+		}
+	}
+	if umounts != 1 {
+		t.Errorf("umount is called %d times", umounts)
+	}
+	if utils.IsFileExisting(mountPath) || getDeviceMountPath("d-1") != "" {
+		t.Errorf("mount path or its record not removed")
+	}
+}
+
+func TestExpandFS(t *testing.T) {
+	cases := map[string]string{
+		"ext4": "resize2fs %[1]s",
+		"ext3": "resize2fs %[1]s",
+		"xfs":  "xfs_growfs %[2]s",
+	}
+	for fsType, expect := range cases {
+		node := newFakeNode(t)
+		node.fsType, node.size = fsType, "21474836480"
+		mountPath := filepath.Join(node.dir, "mounts", "d-1")
+		result := node.plugin().ExpandFS(context.Background(), &DiskOptions{VolumeName: "d-1"}, node.device, mountPath, "21474836480", "10737418240")
+		if result.Status != "Success" {
+			t.Errorf("%s: expandfs failed: %s", fsType, result.Message)
+		}
+		lines := node.exec.CommandLines()
+		if last := lines[len(lines)-1]; last != fmt.Sprintf(expect, node.device, mountPath) {
+			t.Errorf("%s: grow command: %s", fsType, last)
+		}
+		node.cleanup()
+	}
+
+	node := newFakeNode(t)
+	defer node.cleanup()
+	node.fsType, node.size = "btrfs", "21474836480"
+	if result := node.plugin().ExpandFS(context.Background(), &DiskOptions{}, node.device, "/mnt", "21474836480", "0"); result.Code != utils.CODE_DISK_RESIZE_FAILED {
+		t.Errorf("expand unsupported filesystem: %+v", result)
+	}
+}
+
+func TestIsattached(t *testing.T) {
+	cases := []struct {
+		name     string
+		disk     diskInfo
+		device   bool
+		attached bool
+	}{
+		{"attached", diskInfo{DiskId: "d-1", InstanceId: "i-1", Status: ecs.DiskStatusInUse}, true, true},
+		{"detached", diskInfo{DiskId: "d-1", Status: ecs.DiskStatusAvailable}, false, false},
+		{"another instance", diskInfo{DiskId: "d-1", InstanceId: "i-2", Status: ecs.DiskStatusInUse}, false, false},
+		{"device missing", diskInfo{DiskId: "d-1", InstanceId: "i-1", Status: ecs.DiskStatusInUse}, false, false},
+	}
+	for _, c := range cases {
+		node := newFakeNode(t)
+		deviceByIdDir = filepath.Join(node.dir, "by-id")
+		os.MkdirAll(deviceByIdDir, 0755)
+		if c.device {
+			os.Symlink(node.device, filepath.Join(deviceByIdDir, "virtio-1"))
+		}
+		opt := &DiskOptions{VolumeName: "pv-1", VolumeId: "d-1"}
+		saveVolumeConfig(opt)
+
+		plugin := node.plugin()
+		plugin.cloud = &fakeCloud{calls: map[string]int{}, disk: c.disk}
+		result := plugin.Isattached(context.Background(), opt, "cn-hangzhou.i-1")
+		if result.Status != "Success" || result.Attached != c.attached {
+			t.Errorf("%s: isattached: %+v, expect attached: %t", c.name, result, c.attached)
+		}
+		// the config is kept only for the attached volume
+		if saved := getVolumeConfig("pv-1") == "d-1"; saved != c.attached {
+			t.Errorf("%s: volume config saved: %t", c.name, saved)
+		}
+		deviceByIdDir = DEVICE_BY_ID_DIR
+		node.cleanup()
+	}
+}
+
+func TestIsattachedWithoutSerial(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.cleanup()
+	deviceByIdDir = filepath.Join(node.dir, "by-id")
+	defer func() { deviceByIdDir = DEVICE_BY_ID_DIR }()
+	opt := &DiskOptions{VolumeName: "pv-1", VolumeId: "d-1"}
+	plugin := node.plugin()
+	plugin.cloud = &fakeCloud{calls: map[string]int{}, disk: diskInfo{DiskId: "d-1", InstanceId: "i-1", Status: ecs.DiskStatusInUse}}
+
+	// no serial links and no local record, the attachment is not confirmed
+	if result := plugin.Isattached(context.Background(), opt, "cn-hangzhou.i-1"); result.Attached {
+		t.Errorf("attached without local record: %+v", result)
+	}
+	saveVolumeConfig(opt)
+	if result := plugin.Isattached(context.Background(), opt, "cn-hangzhou.i-1"); !result.Attached {
+		t.Errorf("not attached with local record: %+v", result)
+	}
+}
+
+func TestMountdeviceArgs(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.cleanup()
+	// the hostile paths reach mkfs and mount as single arguments, no shell is involved
+	node.device = filepath.Join(node.dir, "vdc $(id)")
+	ioutil.WriteFile(node.device, nil, 0644)
+	mountPath := filepath.Join(node.dir, "mounts", "d-1; rm -rf /")
+	opt := &DiskOptions{VolumeName: "d-1", VolumeId: "d-1", FsType: "xfs"}
+
+	if result := node.plugin().Mountdevice(context.Background(), mountPath, node.device, opt); result.Status != "Success" {
+		t.Fatalf("mountdevice failed: %s", result.Message)
+	}
+	expect := []utils.Command{
+		utils.NewCommand("lsblk", "-n", "-o", "FSTYPE", node.device),
+		utils.NewCommand("mkfs.xfs", "-f", node.device),
+		utils.NewCommand("mount", "-t", "xfs", node.device, mountPath),
+	}
+	if len(node.exec.Commands) != len(expect) {
+		t.Fatalf("commands: %q", node.exec.CommandLines())
+	}
+	for i, cmd := range node.exec.Commands {
+		if cmd.Name != expect[i].Name || strings.Join(cmd.Args, "\x00") != strings.Join(expect[i].Args, "\x00") {
+			t.Errorf("command %d: %q %q, expect: %q %q", i, cmd.Name, cmd.Args, expect[i].Name, expect[i].Args)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package disk
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/journal"
+	"github.com/AliyunContainerService/flexvolume/provider/mountinfo"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	"github.com/denverdino/aliyungo/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+// steps and data keys of disk operations in journal
+const (
+	JOURNAL_DRIVER = "disk"
+
+	STEP_DETACHING    = "detaching"
+	STEP_DETACHED     = "detached"
+	STEP_ATTACHING    = "attaching"
+	STEP_ATTACHED     = "attached"
+	STEP_DEVICE_FOUND = "device_found"
+
+	DATA_DISK_ID       = "diskId"
+	DATA_INSTANCE_ID   = "instanceId"
+	DATA_FROM_INSTANCE = "fromInstance"
+	DATA_DEVICES       = "devices"
+	DATA_DEVICE        = "device"
+	DATA_ROLE_ARN      = "roleArn"
+	DATA_ROLE_SESSION  = "roleSessionName"
+)
+
+// register the recoverer of disk operations, run by monitor
+func init() {
+	journal.RegisterRecoverer(JOURNAL_DRIVER, recoverOperation)
+}
+
+// journal dir of plugin, default is the host journal dir
+func (p *DiskPlugin) journalDir() string {
+	if p.journal == "" {
+		return journal.JOURNAL_DIR
+	}
+	return p.journal
+}
+
+// finishJournal remove the abandoned operation of volume, as the volume is in the expected state
+func (p *DiskPlugin) finishJournal(volumeName string) {
+	op, err := journal.Load(p.journalDir(), JOURNAL_DRIVER, volumeName)
+	if err != nil || op == nil {
+		return
+	}
+	log.Infof("Disk, Finish the abandoned operation: %s", op)
+	if err := op.Finish(); err != nil {
+		log.Warnf("Disk, Finish operation %s error: %s", op, err.Error())
+	}
+}
+
+// endOperation finish the operation if the call succeed, otherwise abandon it,
+// so the failed call never leave a running operation of the daemon process locking the volume
+func endOperation(op *journal.Operation, result *utils.Result) {
+	if result.Status == "Success" {
+		if err := op.Finish(); err != nil {
+			log.Warnf("Disk, Finish operation %s error: %s", op, err.Error())
+		}
+		return
+	}
+	if err := op.Abandon(); err != nil {
+		log.Warnf("Disk, Abandon operation %s error: %s", op, err.Error())
+	}
+}
+
+// canResume check the abandoned attach is the same disk, and the disk was attached to this instance by it,
+// so the device is discovered with the devices recorded before attach.
+func canResume(op *journal.Operation, diskId, instanceId string, disk diskInfo) bool {
+	if op == nil || op.Verb != "attach" || op.Data[DATA_DISK_ID] != diskId || op.Data[DATA_INSTANCE_ID] != instanceId {
+		return false
+	}
+	if op.Step != STEP_ATTACHING && op.Step != STEP_ATTACHED && op.Step != STEP_DEVICE_FOUND {
+		return false
+	}
+	if _, ok := op.Data[DATA_DEVICES]; !ok {
+		return false
+	}
+	return disk.Status == ecs.DiskStatusInUse && disk.InstanceId == instanceId
+}
+
+// splitDevices parse the devices recorded in journal
+func splitDevices(devices string) []string {
+	if devices == "" {
+		return []string{}
+	}
+	return strings.Split(devices, ",")
+}
+
+// withRole add the role of volume to the data of operation, the recoverer detach the disk by it
+func withRole(data map[string]string, role *credentials.Role) map[string]string {
+	if role != nil {
+		data[DATA_ROLE_ARN], data[DATA_ROLE_SESSION] = role.Arn, role.SessionName
+	}
+	return data
+}
+
+// roleOf return the role of operation, nil for the disk of node account
+func roleOf(op *journal.Operation) *credentials.Role {
+	if op.Data[DATA_ROLE_ARN] == "" {
+		return nil
+	}
+	return &credentials.Role{Arn: op.Data[DATA_ROLE_ARN], SessionName: op.Data[DATA_ROLE_SESSION]}
+}
+
+// recoverOperation roll back the abandoned disk operation in monitor, files of host are under root:
+// the disk attached to this node but never used is detached, the detach is finished.
+func recoverOperation(ctx context.Context, root string, op *journal.Operation) error {
+	diskId, instanceId := op.Data[DATA_DISK_ID], op.Data[DATA_INSTANCE_ID]
+	if diskId == "" || instanceId == "" {
+		log.Warnf("Disk, Drop the operation without disk: %s", op)
+		return op.Finish()
+	}
+
+	cloud := cloudOf(roleOf(op))
+	regionId, _, err := nodeCloud.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	disks, err := cloud.DescribeDisks(ctx, regionId, []string{diskId})
+	if err != nil {
+		return err
+	}
+	if len(disks) == 0 || disks[0].InstanceId != instanceId || disks[0].Status != ecs.DiskStatusInUse {
+		log.Infof("Disk, Disk %s is not attached on %s, finish the operation: %s", diskId, instanceId, op)
+		return op.Finish()
+	}
+
+	inUse, err := diskInUse(root, op)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		log.Infof("Disk, Disk %s is in use, finish the operation: %s", diskId, op)
+		return op.Finish()
+	}
+
+	// the attach is not finished by kubelet, and the detach is interrupted
+	log.Warnf("Disk, Detach the unused disk %s from %s for operation: %s", diskId, instanceId, op)
+	if err := cloud.DetachDisk(ctx, instanceId, diskId); err != nil {
+		return err
+	}
+	return op.Finish()
+}
+
+// diskInUse check the volume config is saved or the disk is mounted on host
+func diskInUse(root string, op *journal.Operation) (bool, error) {
+	raw, err := ioutil.ReadFile(path.Join(root, volumeDir, op.Volume+".conf"))
+	if err == nil && strings.TrimSpace(string(raw)) == op.Data[DATA_DISK_ID] && op.Verb == "attach" {
+		return true, nil
+	}
+
+	table, err := mountinfo.Load(mountinfo.HOST_MOUNTINFO)
+	if err != nil {
+		return false, err
+	}
+	if device := op.Data[DATA_DEVICE]; device != "" && len(table.BySource("/dev/"+device)) > 0 {
+		return true, nil
+	}
+	for _, mount := range table {
+		if filepath.Base(mount.MountPoint) == op.Volume && filepath.Base(filepath.Dir(mount.MountPoint)) == "alicloud~disk" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"os"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/audit"
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// auditFile is the audit journal, changed by tests
+var auditFile = audit.AUDIT_FILE
+
+// auditCall append the call to the audit journal, options are sanitized
+func auditCall(fields log.Fields, args []string, result utils.Result, start time.Time) {
+	record := &audit.Record{
+		Time:       start,
+		Result:     result,
+		ExitCode:   utils.ExitCode(result),
+		DurationMs: int64(time.Since(start) / time.Millisecond),
+	}
+	record.Node, _ = os.Hostname()
+	record.OpID, _ = fields["op_id"].(string)
+	record.Driver, _ = fields["driver"].(string)
+	record.Verb, _ = fields["verb"].(string)
+	record.Volume, _ = fields["volume"].(string)
+	record.Pod, _ = fields["pod_uid"].(string)
+
+	optIndex := -1
+	if len(args) > 1 {
+		optIndex, _ = callArgIndex(args[1])
+	}
+	for i := 2; i < len(args); i++ {
+		if i != optIndex {
+			record.Args = append(record.Args, args[i])
+		}
+	}
+	if opts := callOptions(args); opts != nil {
+		record.Options = audit.SanitizeOptions(opts)
+	}
+
+	// the journal is rotated and kept by the policy of log files
+	if _, err := rotateLog(auditFile, newRotatePolicy(config.Get())); err != nil {
+		log.Warnf("Rotate audit journal %s error: %s", auditFile, err.Error())
+	}
+	if err := audit.Append(auditFile, record); err != nil {
+		log.Warnf("Write audit journal %s error: %s", auditFile, err.Error())
+	}
+}
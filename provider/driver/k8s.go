@@ -1,169 +1,363 @@
 package driver
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/AliyunContainerService/flexvolume/provider/cpfs"
-	"github.com/AliyunContainerService/flexvolume/provider/disk"
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/journal"
 	"github.com/AliyunContainerService/flexvolume/provider/monitor"
-	"github.com/AliyunContainerService/flexvolume/provider/nas"
-	"github.com/AliyunContainerService/flexvolume/provider/oss"
+	"github.com/AliyunContainerService/flexvolume/provider/registry"
+	"github.com/AliyunContainerService/flexvolume/provider/transport"
 	"github.com/AliyunContainerService/flexvolume/provider/utils"
 	log "github.com/sirupsen/logrus"
 )
 
-// FluxVolumePlugin: VolumePlugin interface for plugins
-type FluxVolumePlugin interface {
-	NewOptions() interface{} // not called by kubelet
-	Init() utils.Result
-	Getvolumename(opt interface{}) utils.Result
-	Attach(opt interface{}, nodeName string) utils.Result
-	Waitforattach(devicePath string, opt interface{}) utils.Result
-	Mountdevice(mountPath string, opt interface{}) utils.Result
-	Detach(volumeName string, nodeName string) utils.Result
-	Mount(opt interface{}, mountPath string) utils.Result
-	Unmount(mountPoint string) utils.Result
-}
+// FluxVolumePlugin: VolumePlugin interface for plugins, drivers register it in the registry
+type FluxVolumePlugin = registry.Plugin
 
 // const values
 const (
 	MB_SIZE = 1024 * 1024
 
-	TYPE_PLUGIN_DISK  = "disk"
-	TYPE_PLUGIN_NAS   = "nas"
-	TYPE_PLUGIN_OSS   = "oss"
-	TYPE_PLUGIN_CPFS  = "cpfs"
 	PLUGIN_MONITORING = "monitoring"
-	LOGFILE_PREFIX    = "/var/log/alicloud/flexvolume_"
+	LOGFILE_PREFIX    = "flexvolume_"
 )
 
+// CALL_VERBS are the flexvolume calls dispatched to plugins
+var CALL_VERBS = []string{"init", "attach", "isattached", "detach", "waitforattach", "mountdevice", "unmountdevice",
+	"mount", "unmount", "expandvolume", "expandfs", "getvolumename"}
+
 // RunK8sAction run kubernetes command
 func RunK8sAction() {
 	if len(os.Args) < 2 {
-		utils.Finish(utils.Fail("Expected at least one parameter"))
+		utils.Finish(utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Expected at least one parameter"))
 	}
 
-	// set log file
-	setLogAttribute()
+	// monitor run in container, the config of host is mounted under /host
+	if os.Args[1] == PLUGIN_MONITORING {
+		config.SetFile(config.HOST_CONFIG_FILE)
+	}
 
+	// set log file
 	driver := filepath.Base(os.Args[0])
-	if driver == TYPE_PLUGIN_DISK {
-		RunPlugin(&disk.DiskPlugin{})
-	} else if driver == TYPE_PLUGIN_NAS {
-		RunPlugin(&nas.NasPlugin{})
-	} else if driver == TYPE_PLUGIN_OSS {
-		RunPlugin(&oss.OssPlugin{})
-	} else if driver == TYPE_PLUGIN_CPFS {
-		RunPlugin(&cpfs.CpfsPlugin{})
+	setLogAttribute(driver, os.Args[1] == PLUGIN_MONITORING)
+
+	cfg := config.Get()
+	if plugin := registry.NewPlugin(driver); plugin != nil {
+		// every log line of the call carries the call fields
+		fields := callFields(os.Args)
+		log.AddHook(&fieldsHook{fields: fields})
+		setLogConfig(cfg, driver, false)
+		if err := transport.Setup(cfg, ""); err != nil {
+			log.Warnf("Transport of config error: %s, use the default", err.Error())
+		}
+		RunPlugin(plugin, fields)
 	} else if os.Args[1] == PLUGIN_MONITORING {
+		setLogConfig(cfg, driver, true)
 		monitor.Monitoring()
 	} else {
-		utils.Finish(utils.Fail("Not Support Plugin Driver: " + os.Args[0]))
+		utils.Finish(utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Not Support Plugin Driver: "+os.Args[0]))
+	}
+}
+
+// DriversInfo return the registered drivers, names only one per line or the metadata in json
+func DriversInfo(namesOnly bool) string {
+	drivers := registry.Drivers()
+	if namesOnly {
+		names := ""
+		for _, driver := range drivers {
+			names += driver.Name + "\n"
+		}
+		return names
+	}
+	out, err := json.MarshalIndent(drivers, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(out) + "\n"
+}
+
+// VersionInfo return the version, or the build info with compiled-in drivers and verbs in json
+func VersionInfo(asJSON bool) string {
+	info := utils.BuildInfo()
+	if !asJSON {
+		return info.Version
+	}
+	for _, driver := range registry.Drivers() {
+		info.Drivers = append(info.Drivers, driver.Name)
+	}
+	info.Verbs = CALL_VERBS
+	out, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(out) + "\n"
+}
+
+// RunPlugin dispatch the kubelet call to the plugin, the only place exit the process
+func RunPlugin(plugin FluxVolumePlugin, fields log.Fields) {
+	start := time.Now()
+	result := callWithTimeout(plugin, os.Args, true)
+	log.WithFields(resultFields(log.Fields{}, result, start)).Info("Call finished")
+	auditCall(fields, os.Args, result, start)
+	metricsCall(fields, result)
+	utils.Finish(result)
+}
+
+// CallPlugin call the plugin with kubelet style arguments, args[0] is the driver and args[1] is the call.
+// The call is cancelled after the deadline configured for the driver and call, and it is waited until the plugin returns,
+// so the daemon never leave a running call holding the volume.
+func CallPlugin(plugin FluxVolumePlugin, args []string) utils.Result {
+	return callWithTimeout(plugin, args, false)
+}
+
+// callWithTimeout call the plugin with the deadline of driver and call,
+// the call not returned in the grace period after the deadline is interrupted if interrupt, otherwise waited
+func callWithTimeout(plugin FluxVolumePlugin, args []string, interrupt bool) utils.Result {
+	if len(args) < 2 {
+		return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Expected at least one parameter")
+	}
+
+	timeout := config.Get().CallTimeout(filepath.Base(args[0]), args[1])
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return callWithDeadline(ctx, timeout, interrupt, plugin, args)
+}
+
+// callWithDeadline return a timeout result if the plugin failed as deadline exceeded.
+// Every step of plugin return after the deadline, the kubelet call exits after the grace period anyway
+// and the interruption is recorded in the journal of volume.
+func callWithDeadline(ctx context.Context, timeout time.Duration, interrupt bool, plugin FluxVolumePlugin, args []string) utils.Result {
+	done := make(chan utils.Result, 1)
+	go func() {
+		lock, err := lockVolume(ctx, args)
+		if err != nil {
+			done <- utils.FailWithError(err, utils.CODE_LOCK_TIMEOUT, "Lock volume failed: "+err.Error())
+			return
+		}
+		defer lock.Release()
+		done <- dispatch(ctx, plugin, args)
+	}()
+
+	var result utils.Result
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		select {
+		case result = <-done:
+		case <-time.After(callTimeoutGrace):
+			if interrupt {
+				log.Errorf("Call %s is not returned after deadline %s, interrupt it", args[1], timeout)
+				interruptOperation(args, timeout)
+				return utils.Timeout(args[1], timeout)
+			}
+			log.Warnf("Call %s is not returned after deadline %s, wait for it", args[1], timeout)
+			result = <-done
+		}
 	}
+	if result.Status == "Failure" && ctx.Err() == context.DeadlineExceeded {
+		return utils.Timeout(args[1], timeout, result.Message)
+	}
+	return result
 }
 
-// RunPlugin only support attach, detach now
-func RunPlugin(plugin FluxVolumePlugin) {
+// journalDir is the journal of volume operations, changed by tests
+var journalDir = journal.JOURNAL_DIR
+
+// interruptOperation abandon the running operation of volume owned by the process,
+// so it is resumed by the next call or recovered by the monitor after the process exits
+func interruptOperation(args []string, timeout time.Duration) {
+	volume, _ := callFields(args)["volume"].(string)
+	if volume == "" {
+		return
+	}
+	op, err := journal.Load(journalDir, filepath.Base(args[0]), volume)
+	if err != nil || op == nil || op.Pid != os.Getpid() {
+		return
+	}
+	op.Data[journal.DATA_INTERRUPTED] = fmt.Sprintf("%s not returned after deadline %s", args[1], timeout)
+	if err := op.Abandon(); err != nil {
+		log.Warnf("Abandon the interrupted operation %s error: %s", op, err.Error())
+		return
+	}
+	log.Warnf("Abandon the interrupted operation: %s", op)
+}
 
-	switch os.Args[1] {
+// dispatch the call to the plugin method
+func dispatch(ctx context.Context, plugin FluxVolumePlugin, args []string) utils.Result {
+	switch args[1] {
 	case "init":
 		log.Info("Plugin Init")
-		utils.Finish(plugin.Init())
+		return plugin.Init(ctx)
 
 	case "attach":
-		if len(os.Args) != 4 {
-			utils.FinishError("Attach expected exactly 4 arguments; got: " + strings.Join(os.Args, ","))
+		if len(args) != 4 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Attach expected exactly 4 arguments; got: "+strings.Join(args, ","))
+		}
+
+		opt := plugin.NewOptions()
+		if err := json.Unmarshal([]byte(args[2]), opt); err != nil {
+			return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "Attach Options format illegal, except json but got: "+args[2])
+		}
+
+		nodeName := args[3]
+		return plugin.Attach(ctx, opt, nodeName)
+
+	case "isattached":
+		if len(args) != 4 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "isattached expected exactly 4 arguments; got: "+strings.Join(args, ","))
 		}
 
 		opt := plugin.NewOptions()
-		if err := json.Unmarshal([]byte(os.Args[2]), opt); err != nil {
-			utils.FinishError("Attach Options format illegal, except json but got: " + os.Args[2])
+		if err := json.Unmarshal([]byte(args[2]), opt); err != nil {
+			return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "isattached Options format illegal, except json but got: "+args[2])
 		}
 
-		nodeName := os.Args[3]
-		utils.Finish(plugin.Attach(opt, nodeName))
+		nodeName := args[3]
+		return plugin.Isattached(ctx, opt, nodeName)
 
 	case "detach":
-		if len(os.Args) != 4 {
-			utils.FinishError("Detach expect 4 args; got: " + strings.Join(os.Args, ","))
+		if len(args) != 4 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Detach expect 4 args; got: "+strings.Join(args, ","))
 		}
 
-		volumeName := os.Args[2]
-		utils.Finish(plugin.Detach(volumeName, os.Args[3]))
+		volumeName := args[2]
+		return plugin.Detach(ctx, volumeName, args[3])
 
 	case "mount":
-		if len(os.Args) != 4 {
-			utils.FinishError("Mount expected exactly 4 arguments; got: " + strings.Join(os.Args, ","))
+		if len(args) != 4 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Mount expected exactly 4 arguments; got: "+strings.Join(args, ","))
 		}
 
 		opt := plugin.NewOptions()
-		if err := json.Unmarshal([]byte(os.Args[3]), opt); err != nil {
-			utils.FinishError("Mount Options illegal; got: " + os.Args[3])
+		if err := json.Unmarshal([]byte(args[3]), opt); err != nil {
+			return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "Mount Options illegal; got: "+args[3])
 		}
 
-		mountPath := os.Args[2]
-		utils.Finish(plugin.Mount(opt, mountPath))
+		mountPath := args[2]
+		return plugin.Mount(ctx, opt, mountPath)
 
 	case "unmount":
-		if len(os.Args) != 3 {
-			utils.FinishError("Umount expected exactly 3 arguments; got: " + strings.Join(os.Args, ","))
+		if len(args) != 3 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Umount expected exactly 3 arguments; got: "+strings.Join(args, ","))
 		}
 
-		mountPath := os.Args[2]
-		utils.Finish(plugin.Unmount(mountPath))
+		mountPath := args[2]
+		return plugin.Unmount(ctx, mountPath)
 
 	case "waitforattach":
-		if len(os.Args) != 4 {
-			utils.FinishError("waitforattach expected exactly 4 arguments; got: " + strings.Join(os.Args, ","))
+		if len(args) != 4 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "waitforattach expected exactly 4 arguments; got: "+strings.Join(args, ","))
 		}
 		opt := plugin.NewOptions()
-		if err := json.Unmarshal([]byte(os.Args[3]), opt); err != nil {
-			utils.FinishError("waitforattach Options illegal; got: " + os.Args[3])
+		if err := json.Unmarshal([]byte(args[3]), opt); err != nil {
+			return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "waitforattach Options illegal; got: "+args[3])
 		}
 
-		devicePath := os.Args[2]
-		utils.Finish(plugin.Waitforattach(devicePath, opt))
+		devicePath := args[2]
+		return plugin.Waitforattach(ctx, devicePath, opt)
 
-	case "getvolumename":
-		if len(os.Args) != 3 {
-			utils.FinishError("getvolumename expected exactly 3 arguments; got: " + strings.Join(os.Args, ","))
+	case "mountdevice":
+		if len(args) != 5 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "mountdevice expected exactly 5 arguments; got: "+strings.Join(args, ","))
+		}
+		opt := plugin.NewOptions()
+		if err := json.Unmarshal([]byte(args[4]), opt); err != nil {
+			return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "mountdevice Options illegal; got: "+args[4])
+		}
+
+		mountPath := args[2]
+		devicePath := args[3]
+		return plugin.Mountdevice(ctx, mountPath, devicePath, opt)
+
+	case "unmountdevice":
+		if len(args) != 3 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "unmountdevice expected exactly 3 arguments; got: "+strings.Join(args, ","))
+		}
+
+		mountPath := args[2]
+		return plugin.Unmountdevice(ctx, mountPath)
+
+	case "expandvolume":
+		if len(args) != 6 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "expandvolume expected exactly 6 arguments; got: "+strings.Join(args, ","))
 		}
 		opt := plugin.NewOptions()
-		if err := json.Unmarshal([]byte(os.Args[2]), opt); err != nil {
-			utils.FinishError("GetVolumeName Options illegal; got: " + os.Args[3])
+		if err := json.Unmarshal([]byte(args[2]), opt); err != nil {
+			return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "expandvolume Options illegal; got: "+args[2])
 		}
 
-		utils.Finish(plugin.Getvolumename(opt))
+		devicePath, newSize, oldSize := args[3], args[4], args[5]
+		return plugin.ExpandVolume(ctx, opt, devicePath, newSize, oldSize)
+
+	case "expandfs":
+		if len(args) != 7 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "expandfs expected exactly 7 arguments; got: "+strings.Join(args, ","))
+		}
+		opt := plugin.NewOptions()
+		if err := json.Unmarshal([]byte(args[2]), opt); err != nil {
+			return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "expandfs Options illegal; got: "+args[2])
+		}
+
+		devicePath, deviceMountPath, newSize, oldSize := args[3], args[4], args[5], args[6]
+		return plugin.ExpandFS(ctx, opt, devicePath, deviceMountPath, newSize, oldSize)
+
+	case "getvolumename":
+		if len(args) != 3 {
+			return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "getvolumename expected exactly 3 arguments; got: "+strings.Join(args, ","))
+		}
+		opt := plugin.NewOptions()
+		if err := json.Unmarshal([]byte(args[2]), opt); err != nil {
+			return utils.FailWithCode(utils.CODE_INVALID_OPTIONS, "GetVolumeName Options illegal; got: "+args[2])
+		}
 
-	default:
-		utils.Finish(utils.NotSupport(os.Args))
+		return plugin.Getvolumename(ctx, opt)
 	}
 
+	return utils.NotSupport(args)
 }
 
-// rotate log file by 2M bytes
-func setLogAttribute() {
-	driver := filepath.Base(os.Args[0])
-	logFile := LOGFILE_PREFIX + driver + ".log"
+// set log file, rotate it by the policy in flexvolume.conf,
+// daemon keep checking the log file as it is running always, with the policy reloaded
+func setLogAttribute(driver string, daemon bool) {
+	logFile := filepath.Join(config.Get().LogDir, LOGFILE_PREFIX+driver+".log")
+	_, rotateErr := rotateLog(logFile, newRotatePolicy(config.Get()))
+
 	f, err := os.OpenFile(logFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		utils.Finish(utils.Fail("Log File open error"))
+		utils.Finish(utils.FailWithCode(utils.CODE_INTERNAL, "Log File open error"))
+	}
+	log.SetOutput(f)
+	if rotateErr != nil {
+		log.Warnf("Rotate log file %s error: %s", logFile, rotateErr.Error())
 	}
 
-	// rotate the log file if too large
-	if fi, err := f.Stat(); err == nil && fi.Size() > 2*MB_SIZE {
-		f.Close()
-		timeStr := time.Now().Format("-2006-01-02-15:04:05")
-		timedLogfile := LOGFILE_PREFIX + driver + timeStr + ".log"
-		os.Rename(logFile, timedLogfile)
-		f, err = os.OpenFile(logFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			utils.Finish(utils.Fail("Log File open error2"))
-		}
+	if daemon {
+		go func() {
+			for {
+				time.Sleep(LOG_ROTATE_CHECK_PERIOD)
+				rotated, err := rotateLog(logFile, newRotatePolicy(config.Get()))
+				if err != nil {
+					log.Warnf("Rotate log file %s error: %s", logFile, err.Error())
+				}
+				if !rotated {
+					continue
+				}
+				newFile, err := os.OpenFile(logFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+				if err != nil {
+					log.Errorf("Reopen log file %s error: %s", logFile, err.Error())
+					continue
+				}
+				log.SetOutput(newFile)
+				f.Close()
+				f = newFile
+			}
+		}()
 	}
-	log.SetOutput(f)
 }
@@ -0,0 +1,235 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/journal"
+	"github.com/AliyunContainerService/flexvolume/provider/locking"
+	"github.com/AliyunContainerService/flexvolume/provider/registry"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+// the volume locks of calls are under a temp dir
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "locks")
+	if err != nil {
+		panic(err)
+	}
+	locking.SetDir(dir)
+	// TestCliOutput run the test binary as a plugin call
+	if os.Getenv(TEST_CLI_ENV) != "" {
+		runCli(dir)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// TEST_CLI_ENV make the test binary run as the plugin given by the args after it
+const TEST_CLI_ENV = "FLEXVOLUME_TEST_CLI"
+
+// runCli run the plugin call of fake driver as kubelet does, it never returns
+func runCli(dir string) {
+	registry.Register(registry.Driver{Name: "fake", New: func() registry.Plugin { return &fakePlugin{} }})
+	auditFile = filepath.Join(dir, "audit.jsonl")
+	os.Args = append([]string{"fake"}, strings.Fields(os.Getenv(TEST_CLI_ENV))...)
+	RunK8sAction()
+}
+
+func TestCliOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the invalid env is warned in the log file, the output is only the result for kubelet
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(),
+		TEST_CLI_ENV+"=init",
+		"FLEXVOLUME_TIMEOUT=abc",
+		"FLEXVOLUME_LOG_DIR="+dir,
+		"FLEXVOLUME_METRICS_FILE="+filepath.Join(dir, "metrics.prom"))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("plugin call error: %s, output: %s", err.Error(), output)
+	}
+	result := utils.Result{}
+	if err := json.Unmarshal(output, &result); err != nil {
+		t.Fatalf("output is not the json result: %s, output: %s", err.Error(), output)
+	}
+	if result.Status != "Success" {
+		t.Errorf("expect success, got %+v", result)
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, LOGFILE_PREFIX+"fake.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "FLEXVOLUME_TIMEOUT") {
+		t.Errorf("expect the config error in log file, got: %s", raw)
+	}
+}
+
+type fakeOptions struct {
+	VolumeName string `json:"kubernetes.io/pvOrVolumeName"`
+}
+
+// fakePlugin record the last call
+type fakePlugin struct {
+	call string
+	args []string
+	// unmount wait for the deadline, and return failure if returnOnDeadline, otherwise after release is closed
+	returnOnDeadline *bool
+	release          chan struct{}
+}
+
+func (p *fakePlugin) record(call string, args ...string) utils.Result {
+	p.call, p.args = call, args
+	return utils.Succeed()
+}
+
+func (p *fakePlugin) NewOptions() interface{}               { return &fakeOptions{} }
+func (p *fakePlugin) Init(ctx context.Context) utils.Result { return p.record("init") }
+func (p *fakePlugin) Getvolumename(ctx context.Context, opt interface{}) utils.Result {
+	return p.record("getvolumename", opt.(*fakeOptions).VolumeName)
+}
+func (p *fakePlugin) Attach(ctx context.Context, opt interface{}, nodeName string) utils.Result {
+	return p.record("attach", opt.(*fakeOptions).VolumeName, nodeName)
+}
+func (p *fakePlugin) Isattached(ctx context.Context, opt interface{}, nodeName string) utils.Result {
+	return p.record("isattached", opt.(*fakeOptions).VolumeName, nodeName)
+}
+func (p *fakePlugin) Waitforattach(ctx context.Context, devicePath string, opt interface{}) utils.Result {
+	return p.record("waitforattach", devicePath, opt.(*fakeOptions).VolumeName)
+}
+func (p *fakePlugin) Mountdevice(ctx context.Context, mountPath string, devicePath string, opt interface{}) utils.Result {
+	return p.record("mountdevice", mountPath, devicePath, opt.(*fakeOptions).VolumeName)
+}
+func (p *fakePlugin) Unmountdevice(ctx context.Context, mountPath string) utils.Result {
+	return p.record("unmountdevice", mountPath)
+}
+func (p *fakePlugin) Detach(ctx context.Context, volumeName string, nodeName string) utils.Result {
+	return p.record("detach", volumeName, nodeName)
+}
+func (p *fakePlugin) Mount(ctx context.Context, opt interface{}, mountPath string) utils.Result {
+	return p.record("mount", opt.(*fakeOptions).VolumeName, mountPath)
+}
+func (p *fakePlugin) Unmount(ctx context.Context, mountPoint string) utils.Result {
+	if p.returnOnDeadline != nil {
+		<-ctx.Done()
+		if !*p.returnOnDeadline {
+			<-p.release
+		}
+		return utils.Fail("unmount interrupted: " + ctx.Err().Error())
+	}
+	return p.record("unmount", mountPoint)
+}
+func (p *fakePlugin) ExpandVolume(ctx context.Context, opt interface{}, devicePath, newSize, oldSize string) utils.Result {
+	return p.record("expandvolume", opt.(*fakeOptions).VolumeName, devicePath, newSize, oldSize)
+}
+func (p *fakePlugin) ExpandFS(ctx context.Context, opt interface{}, devicePath, deviceMountPath, newSize, oldSize string) utils.Result {
+	return p.record("expandfs", opt.(*fakeOptions).VolumeName, devicePath, deviceMountPath, newSize, oldSize)
+}
+
+func TestCallPlugin(t *testing.T) {
+	opts := `{"kubernetes.io/pvOrVolumeName": "pv1"}`
+	cases := [][]string{
+		{"attach", opts, "node1"},
+		{"isattached", opts, "node1"},
+		{"detach", "pv1", "node1"},
+		{"mountdevice", "/mnt/global", "/dev/vdc", opts},
+		{"unmountdevice", "/mnt/global"},
+		{"expandfs", opts, "/dev/vdc", "/mnt/global", "20", "10"},
+	}
+	for _, args := range cases {
+		plugin := &fakePlugin{}
+		result := CallPlugin(plugin, append([]string{"fake"}, args...))
+		if result.Status != "Success" || plugin.call != args[0] {
+			t.Errorf("call %v, got result %+v, plugin call: %s", args, result, plugin.call)
+		}
+	}
+
+	plugin := &fakePlugin{}
+	if result := CallPlugin(plugin, []string{"fake", "mount", "/mnt"}); result.Status != "Failure" || plugin.call != "" {
+		t.Errorf("mount with missing options should fail, got %+v", result)
+	}
+	if result := CallPlugin(plugin, []string{"fake", "attach", "not json", "node1"}); result.Status != "Failure" {
+		t.Errorf("attach with illegal options should fail, got %+v", result)
+	}
+	if result := CallPlugin(plugin, []string{"fake", "unknown"}); result.Status != "Not supported" {
+		t.Errorf("unknown call should not be supported, got %+v", result)
+	}
+	// the verbs reported by version are all dispatched
+	for _, verb := range CALL_VERBS {
+		if result := CallPlugin(plugin, []string{"fake", verb}); result.Status == "Not supported" {
+			t.Errorf("verb %s is not dispatched", verb)
+		}
+	}
+}
+
+func TestCallWithDeadline(t *testing.T) {
+	callTimeoutGrace = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journalDir = dir
+	defer func() { journalDir = journal.JOURNAL_DIR }()
+
+	for _, returnOnDeadline := range []bool{true, false} {
+		op, err := journal.Begin(dir, "fake", "unmount", "mnt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plugin := &fakePlugin{returnOnDeadline: &returnOnDeadline, release: make(chan struct{})}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		result := callWithDeadline(ctx, 10*time.Millisecond, true, plugin, []string{"fake", "unmount", "/mnt"})
+		cancel()
+		close(plugin.release)
+		if result.Status != "Failure" || !strings.HasPrefix(result.Message, "Timeout: unmount not finished in 10ms") {
+			t.Errorf("expect timeout result, got %+v", result)
+		}
+		// the operation of the call not returned is abandoned before the process exits
+		op, err = journal.Load(dir, "fake", "mnt")
+		if err != nil || op == nil {
+			t.Fatalf("load operation: %v, %v", op, err)
+		}
+		interrupted := op.Data[journal.DATA_INTERRUPTED] != "" && op.State() == journal.STATE_ABANDONED
+		if interrupted == returnOnDeadline {
+			t.Errorf("returnOnDeadline %t: unexpected operation %s, state %s", returnOnDeadline, op, op.State())
+		}
+		op.Finish()
+	}
+}
+
+func TestCallWithDeadlineWait(t *testing.T) {
+	callTimeoutGrace = 10 * time.Millisecond
+	returnOnDeadline := false
+	plugin := &fakePlugin{returnOnDeadline: &returnOnDeadline, release: make(chan struct{})}
+	released := make(chan bool, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		released <- true
+		close(plugin.release)
+	}()
+
+	// the daemon wait for the plugin returned after the grace period, the volume is never left in operation
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	result := callWithDeadline(ctx, 10*time.Millisecond, false, plugin, []string{"fake", "unmount", "/mnt/wait"})
+	if len(released) != 1 {
+		t.Errorf("expect the call returned after the plugin")
+	}
+	if result.Status != "Failure" || !strings.Contains(result.Message, "unmount interrupted") {
+		t.Errorf("expect timeout result of plugin, got %+v", result)
+	}
+}
@@ -0,0 +1,33 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/AliyunContainerService/flexvolume/provider/locking"
+)
+
+// LOCKED_VERBS change the volume on node, calls of the same volume are serialized,
+// and calls of different volumes run in parallel.
+var LOCKED_VERBS = map[string]bool{
+	"attach":        true,
+	"detach":        true,
+	"mountdevice":   true,
+	"unmountdevice": true,
+	"mount":         true,
+	"unmount":       true,
+	"expandvolume":  true,
+	"expandfs":      true,
+}
+
+// lockVolume wait for the volume lock of the call, nil if the call is not locked
+func lockVolume(ctx context.Context, args []string) (*locking.Lock, error) {
+	if !LOCKED_VERBS[args[1]] {
+		return nil, nil
+	}
+	volume, _ := callFields(args)["volume"].(string)
+	if volume == "" {
+		return nil, nil
+	}
+	return locking.Volume(ctx, filepath.Base(args[0]), volume)
+}
@@ -0,0 +1,209 @@
+package driver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values for log config in flexvolume.conf:
+//
+//	log_format: json
+//	log_level: debug
+//	log_sink: syslog
+const (
+	LOG_FORMAT_JSON = "json"
+	LOG_SINK_STDERR = "stderr"
+	LOG_SINK_SYSLOG = "syslog"
+
+	OPTION_VOLUME_NAME = "kubernetes.io/pvOrVolumeName"
+	OPTION_POD_UID     = "kubernetes.io/pod.uid"
+)
+
+// setLogConfig set the log format, level and the extra sink.
+// kubelet read the combined output of plugin call, so stderr sink only works for daemon.
+func setLogConfig(cfg *config.Config, driver string, daemon bool) {
+	// the config errors are logged only after the log file is set, stderr is parsed by kubelet with the result
+	defer func() {
+		if err := config.LoadError(); err != nil {
+			log.Warnf("%s", err.Error())
+		}
+	}()
+	if strings.ToLower(cfg.LogFormat) == LOG_FORMAT_JSON {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
+	if cfg.LogLevel != "" {
+		if level, err := log.ParseLevel(cfg.LogLevel); err == nil {
+			log.SetLevel(level)
+		} else {
+			log.Warnf("Illegal log level: %s, ignored", cfg.LogLevel)
+		}
+	}
+
+	switch strings.ToLower(cfg.LogSink) {
+	case "":
+	case LOG_SINK_STDERR:
+		if daemon {
+			log.AddHook(&writerHook{write: func(line []byte) error {
+				_, err := os.Stderr.Write(line)
+				return err
+			}})
+		}
+	case LOG_SINK_SYSLOG:
+		writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "flexvolume-"+driver)
+		if err != nil {
+			log.Warnf("Connect to syslog error: %s", err.Error())
+			return
+		}
+		log.AddHook(&writerHook{write: func(line []byte) error {
+			_, err := writer.Write(line)
+			return err
+		}})
+	default:
+		log.Warnf("Illegal log sink: %s, ignored", cfg.LogSink)
+	}
+}
+
+// writerHook copy the log entries to another sink, errors are dropped
+// as logrus print the hook errors to stderr, which is read by kubelet.
+type writerHook struct {
+	write func(line []byte) error
+}
+
+func (h *writerHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *writerHook) Fire(entry *log.Entry) error {
+	line, err := entry.Logger.Formatter.Format(entry)
+	if err == nil {
+		h.write(line)
+	}
+	return nil
+}
+
+// fieldsHook add the invocation fields to every log entry
+type fieldsHook struct {
+	fields log.Fields
+}
+
+func (h *fieldsHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *fieldsHook) Fire(entry *log.Entry) error {
+	for key, value := range h.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
+
+// resultFields add the result of the call to the log fields
+func resultFields(fields log.Fields, result utils.Result, start time.Time) log.Fields {
+	fields["status"] = result.Status
+	fields["duration_ms"] = int64(time.Since(start) / time.Millisecond)
+	if result.Code != "" {
+		fields["code"] = result.Code
+		fields["retryable"] = result.Retryable
+	}
+	return fields
+}
+
+// newOperationID return a random id to correlate the log lines of one call
+func newOperationID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// callFields return the log fields of the call with kubelet style arguments
+func callFields(args []string) log.Fields {
+	fields := log.Fields{"op_id": newOperationID()}
+	if len(args) < 2 {
+		return fields
+	}
+	fields["driver"] = filepath.Base(args[0])
+	fields["verb"] = args[1]
+
+	if args[1] == "detach" && len(args) > 2 {
+		fields["volume"] = args[2]
+	}
+	if opts := callOptions(args); opts != nil {
+		if volume, ok := opts[OPTION_VOLUME_NAME].(string); ok && volume != "" {
+			fields["volume"] = volume
+		}
+		if pod, ok := opts[OPTION_POD_UID].(string); ok && pod != "" {
+			fields["pod_uid"] = pod
+		}
+	}
+	if _, pathIndex := callArgIndex(args[1]); pathIndex > 0 && len(args) > pathIndex {
+		path := args[pathIndex]
+		if _, ok := fields["volume"]; !ok {
+			fields["volume"] = filepath.Base(path)
+		}
+		if _, ok := fields["pod_uid"]; !ok {
+			if pod := podUIDFromPath(path); pod != "" {
+				fields["pod_uid"] = pod
+			}
+		}
+	}
+	return fields
+}
+
+// callArgIndex return the position of json options and the volume path for the call, -1 if not exist
+func callArgIndex(call string) (int, int) {
+	switch call {
+	case "attach", "isattached", "expandvolume", "expandfs", "getvolumename":
+		return 2, -1
+	case "waitforattach":
+		return 3, -1
+	case "mount":
+		return 3, 2
+	case "mountdevice":
+		return 4, 2
+	case "unmount", "unmountdevice":
+		return -1, 2
+	}
+	return -1, -1
+}
+
+// callOptions return the json options of the call, nil if not exist or illegal
+func callOptions(args []string) map[string]interface{} {
+	if len(args) < 2 {
+		return nil
+	}
+	optIndex, _ := callArgIndex(args[1])
+	if optIndex < 0 || len(args) <= optIndex {
+		return nil
+	}
+	opts := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(args[optIndex]), &opts); err != nil {
+		return nil
+	}
+	return opts
+}
+
+// get pod uid from the volume path: /var/lib/kubelet/pods/<uid>/volumes/alicloud~nas/<volume>
+func podUIDFromPath(path string) string {
+	parts := strings.Split(filepath.Clean(path), "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if parts[i] == "pods" && parts[i+2] == "volumes" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package driver
+
+import "testing"
+
+func TestCallFields(t *testing.T) {
+	opts := `{"kubernetes.io/pvOrVolumeName": "pv1", "kubernetes.io/pod.uid": "uid-1"}`
+	cases := []struct {
+		args   []string
+		volume string
+		pod    string
+	}{
+		{[]string{"/usr/libexec/alicloud~nas/nas", "mount", "/var/lib/kubelet/pods/uid-1/volumes/alicloud~nas/pv1", opts}, "pv1", "uid-1"},
+		{[]string{"nas", "unmount", "/var/lib/kubelet/pods/uid-2/volumes/alicloud~nas/pv2"}, "pv2", "uid-2"},
+		{[]string{"disk", "attach", `{"kubernetes.io/pvOrVolumeName": "pv3"}`, "node1"}, "pv3", ""},
+		{[]string{"disk", "detach", "pv4", "node1"}, "pv4", ""},
+	}
+	for _, c := range cases {
+		fields := callFields(c.args)
+		if fields["op_id"] == "" || fields["verb"] != c.args[1] {
+			t.Errorf("%v: missing op_id or verb: %v", c.args, fields)
+		}
+		if fields["volume"] != c.volume {
+			t.Errorf("%v: expect volume %s, got %v", c.args, c.volume, fields["volume"])
+		}
+		if pod, _ := fields["pod_uid"].(string); pod != c.pod {
+			t.Errorf("%v: expect pod %s, got %s", c.args, c.pod, pod)
+		}
+	}
+	if callFields([]string{"nas", "mount"})["op_id"] == callFields([]string{"nas", "mount"})["op_id"] {
+		t.Errorf("operation id should be unique")
+	}
+}
@@ -0,0 +1,158 @@
+package driver
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+)
+
+// const values for log rotation, the policy is configured in flexvolume.conf as:
+//
+//	log_max_size_mb: 2
+//	log_max_backups: 5
+//	log_max_age_days: 7
+//	log_compress: true
+const (
+	LOG_ROTATE_TIME_FORMAT  = "-2006-01-02-15:04:05.000"
+	LOG_ROTATE_CHECK_PERIOD = time.Minute
+)
+
+// rotatePolicy decide when to rotate the log file and how many backups are kept,
+// zero MaxBackups or MaxAge means no limit.
+type rotatePolicy struct {
+	MaxSize    int64
+	MaxBackups int
+	MaxAge     time.Duration
+	Compress   bool
+}
+
+// newRotatePolicy return the policy of config, illegal values are replaced by default when loaded
+func newRotatePolicy(cfg *config.Config) rotatePolicy {
+	return rotatePolicy{
+		MaxSize:    int64(cfg.LogMaxSizeMB) * MB_SIZE,
+		MaxBackups: cfg.LogMaxBackups,
+		MaxAge:     time.Duration(cfg.LogMaxAgeDays) * 24 * time.Hour,
+		Compress:   cfg.LogCompress,
+	}
+}
+
+// rotateLog rename the log file to a timestamped backup if it is larger than the max size,
+// and remove the backups out of retention. Return true if the log file is rotated.
+// The backup keep the extension of file: flexvolume_disk-<time>.log, flexvolume_audit-<time>.jsonl.
+// Plugin processes of the same driver rotate the same file, so it is done with the lock file held.
+func rotateLog(logFile string, policy rotatePolicy) (bool, error) {
+	if fi, err := os.Stat(logFile); err != nil || fi.Size() <= policy.MaxSize {
+		return false, nil
+	}
+
+	lock, err := os.OpenFile(logFile+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return false, err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	// rotated by other process while waiting for the lock
+	if fi, err := os.Stat(logFile); err != nil || fi.Size() <= policy.MaxSize {
+		return false, nil
+	}
+
+	ext := filepath.Ext(logFile)
+	base := strings.TrimSuffix(logFile, ext)
+	backup := base + time.Now().Format(LOG_ROTATE_TIME_FORMAT) + ext
+	if err := os.Rename(logFile, backup); err != nil {
+		return false, err
+	}
+
+	backups := listLogBackups(base, ext)
+	if policy.Compress {
+		// the newest backup may be written by the process opened it before rename,
+		// it is compressed in the next rotation.
+		for _, file := range backups {
+			if file != backup && strings.HasSuffix(file, ext) {
+				if err := compressFile(file); err != nil {
+					return true, err
+				}
+			}
+		}
+		backups = listLogBackups(base, ext)
+	}
+	return true, removeExpiredBackups(backups, policy)
+}
+
+// listLogBackups return the backups of log file, newest first
+func listLogBackups(base, ext string) []string {
+	files, _ := filepath.Glob(base + "-*" + ext + "*")
+	backups := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file, ext) || strings.HasSuffix(file, ext+".gz") {
+			backups = append(backups, file)
+		}
+	}
+	// the timestamp in name is sortable
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups
+}
+
+// remove the backups beyond the max count or older than max age
+func removeExpiredBackups(backups []string, policy rotatePolicy) error {
+	var lastErr error
+	for i, file := range backups {
+		expired := policy.MaxBackups > 0 && i >= policy.MaxBackups
+		if fi, err := os.Stat(file); err == nil && policy.MaxAge > 0 && time.Since(fi.ModTime()) > policy.MaxAge {
+			expired = true
+		}
+		if expired {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// compress the file to file.gz, and remove the origin file
+func compressFile(file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(file+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(dst)
+	if _, err := io.Copy(writer, src); err != nil {
+		dst.Close()
+		os.Remove(file + ".gz")
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		dst.Close()
+		os.Remove(file + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(file + ".gz")
+		return err
+	}
+	// keep the modify time for max age check
+	os.Chtimes(file+".gz", fi.ModTime(), fi.ModTime())
+	return os.Remove(file)
+}
@@ -0,0 +1,95 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/audit"
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRotateLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logrotate")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "flexvolume_disk.log")
+	policy := rotatePolicy{MaxSize: 10, MaxBackups: 2, MaxAge: time.Hour, Compress: true}
+
+	// old backup out of max age
+	expired := filepath.Join(dir, "flexvolume_disk-2018-01-01-00:00:00.log")
+	ioutil.WriteFile(expired, []byte("old"), 0644)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(expired, old, old)
+
+	ioutil.WriteFile(logFile, []byte("small"), 0644)
+	if rotated, err := rotateLog(logFile, policy); err != nil || rotated {
+		t.Fatalf("small log should not be rotated: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		ioutil.WriteFile(logFile, []byte("log content larger than max size"), 0644)
+		if rotated, err := rotateLog(logFile, policy); err != nil || !rotated {
+			t.Fatalf("log should be rotated: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups := listLogBackups(filepath.Join(dir, "flexvolume_disk"), ".log")
+	if len(backups) != 2 {
+		t.Fatalf("expect 2 backups, got %v", backups)
+	}
+	if !strings.HasSuffix(backups[0], ".log") || !strings.HasSuffix(backups[1], ".log.gz") {
+		t.Errorf("only the newest backup should not be compressed: %v", backups)
+	}
+	if _, err := os.Stat(logFile); !os.IsNotExist(err) {
+		t.Errorf("log file should be renamed")
+	}
+}
+
+func TestNewRotatePolicy(t *testing.T) {
+	cfg, _ := config.Parse([]byte("log_max_size_mb: 10\nlog_max_backups: -1\nlog_compress: false\n"))
+	policy := newRotatePolicy(cfg)
+	if policy.MaxSize != 10*MB_SIZE || policy.MaxBackups != config.DEFAULT_LOG_MAX_BACKUPS || policy.Compress {
+		t.Errorf("unexpected policy: %+v", policy)
+	}
+}
+
+func TestAuditRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	auditFile = filepath.Join(dir, "flexvolume_audit.jsonl")
+	defer func() { auditFile = audit.AUDIT_FILE }()
+	cfg := config.Default()
+	cfg.LogMaxSizeMB, cfg.LogMaxBackups, cfg.LogCompress = 1, 1, false
+	config.Set(cfg)
+	defer config.Set(nil)
+
+	// the journal larger than the max size is rotated before the call is appended
+	fields := log.Fields{"driver": "nas", "verb": "mount", "volume": "pv1"}
+	args := []string{"nas", "mount", "/mnt/pv1", `{"kubernetes.io/pvOrVolumeName": "pv1"}`}
+	for i := 0; i < 3; i++ {
+		ioutil.WriteFile(auditFile, []byte(strings.Repeat("x", MB_SIZE+1)), 0600)
+		auditCall(fields, args, utils.Succeed(), time.Now())
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if backups := audit.Backups(auditFile); len(backups) != 1 || !strings.HasSuffix(backups[0], ".jsonl") {
+		t.Errorf("expect one backup kept, got %v", backups)
+	}
+	records, err := audit.Read(auditFile, &audit.Filter{})
+	if err != nil || len(records) != 1 || records[0].Volume != "pv1" {
+		t.Errorf("expect the call in the new journal, got %v, %v", records, err)
+	}
+}
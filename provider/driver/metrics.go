@@ -0,0 +1,20 @@
+package driver
+
+import (
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/metrics"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// metricsCall count the call result in the node metrics file, configured in flexvolume.conf as:
+//
+//	metrics_file: /var/lib/node_exporter/textfile/flexvolume.prom
+func metricsCall(fields log.Fields, result utils.Result) {
+	driver, _ := fields["driver"].(string)
+	verb, _ := fields["verb"].(string)
+	file := config.Get().MetricsFile
+	if err := metrics.RecordCall(file, driver, verb, result.Status, string(result.Code)); err != nil {
+		log.Warnf("Write metrics %s error: %s", file, err.Error())
+	}
+}
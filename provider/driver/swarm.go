@@ -1,6 +1,509 @@
 package driver
 
-// RunningInSwarm expect to support running on swarm
-func RunningInSwarm() {
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/registry"
+	"github.com/AliyunContainerService/flexvolume/provider/transport"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values for swarm
+const (
+	SWARM_PLUGIN_DIR    = "/run/docker/plugins/"
+	SWARM_PLUGIN_PREFIX = "alicloud-"
+	SWARM_CATALOG_FILE  = "/var/lib/alicloud/flexvolume/volumes.json"
+	SWARM_MOUNT_DIR     = "/var/lib/alicloud/flexvolume/mounts/"
+	SWARM_CONTENT_TYPE  = "application/vnd.docker.plugins.v1+json"
+	PLUGIN_SWARM        = "swarm"
+)
+
+// pluginCaller call the plugin with the same arguments as kubelet
+type pluginCaller func(driver string, args ...string) utils.Result
+
+// swarmServer implement docker volume plugin protocol for one driver
+type swarmServer struct {
+	driver string
+	// attachable driver is attached and mounted by mountdevice
+	attachable bool
+	catalog    *swarmCatalog
+	call       pluginCaller
+	// serialize the operations of the same volume, volumes are operated in parallel
+	locks *volumeLocks
+}
+
+// docker volume plugin request
+type swarmRequest struct {
+	Name string            `json:"Name"`
+	ID   string            `json:"ID,omitempty"`
+	Opts map[string]string `json:"Opts,omitempty"`
+}
+
+// docker volume plugin volume info
+type swarmVolumeInfo struct {
+	Name       string `json:"Name"`
+	Mountpoint string `json:"Mountpoint,omitempty"`
+}
+
+// docker volume plugin response
+type swarmResponse struct {
+	Err          string             `json:"Err"`
+	Mountpoint   string             `json:"Mountpoint,omitempty"`
+	Volume       *swarmVolumeInfo   `json:"Volume,omitempty"`
+	Volumes      []*swarmVolumeInfo `json:"Volumes,omitempty"`
+	Capabilities map[string]string  `json:"Capabilities,omitempty"`
+}
+
+// RunningInSwarm running as docker volume plugin, every driver is served on its own socket:
+// /run/docker/plugins/alicloud-disk.sock, used as: docker volume create -d alicloud-disk -o volumeId=d-xxx.
+// It return the error of startup, or the first plugin server exited.
+func RunningInSwarm() error {
+	setLogAttribute(PLUGIN_SWARM, true)
+	setLogConfig(config.Get(), PLUGIN_SWARM, true)
+	if err := transport.Setup(config.Get(), ""); err != nil {
+		log.Warnf("Swarm, Transport of config error: %s, use the default", err.Error())
+	}
+
+	catalog, err := loadSwarmCatalog(SWARM_CATALOG_FILE)
+	if err != nil {
+		return fmt.Errorf("Swarm, Load volume catalog error: %s", err.Error())
+	}
+	if err := utils.CreateDest(SWARM_PLUGIN_DIR); err != nil {
+		return fmt.Errorf("Swarm, Create plugin directory error: %s", err.Error())
+	}
+
+	drivers := registry.Drivers()
+	errChan := make(chan error, len(drivers))
+	for _, driver := range drivers {
+		server := &swarmServer{driver: driver.Name, attachable: driver.Capabilities.Attach, catalog: catalog, call: callPlugin, locks: newVolumeLocks()}
+		socket := filepath.Join(SWARM_PLUGIN_DIR, SWARM_PLUGIN_PREFIX+driver.Name+".sock")
+		go func() {
+			errChan <- server.serve(socket)
+		}()
+	}
+	err = <-errChan
+	log.Errorf("Swarm, Plugin server exit: %s", err.Error())
+	return fmt.Errorf("Swarm, Plugin server exit: %s", err.Error())
+}
+
+// serve listen on the unix socket and handle docker requests
+func (s *swarmServer) serve(socket string) error {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	log.Infof("Swarm, Plugin %s is listening on: %s", s.driver, socket)
+	if err := http.Serve(listener, s.handler()); err != nil {
+		return fmt.Errorf("serve %s on %s error: %s", s.driver, socket, err.Error())
+	}
+	return nil
+}
+
+func (s *swarmServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		writeSwarmResponse(w, map[string][]string{"Implements": {"VolumeDriver"}})
+	})
+	mux.HandleFunc("/VolumeDriver.Create", s.wrap(s.create))
+	mux.HandleFunc("/VolumeDriver.Remove", s.wrap(s.remove))
+	mux.HandleFunc("/VolumeDriver.Mount", s.wrap(s.mount))
+	mux.HandleFunc("/VolumeDriver.Unmount", s.wrap(s.unmount))
+	mux.HandleFunc("/VolumeDriver.Path", s.wrap(s.path))
+	mux.HandleFunc("/VolumeDriver.Get", s.wrap(s.get))
+	mux.HandleFunc("/VolumeDriver.List", s.wrap(s.list))
+	mux.HandleFunc("/VolumeDriver.Capabilities", func(w http.ResponseWriter, r *http.Request) {
+		writeSwarmResponse(w, &swarmResponse{Capabilities: map[string]string{"Scope": "local"}})
+	})
+	return mux
+}
+
+// wrap decode the request and serialize the operations of the volume
+func (s *swarmServer) wrap(fn func(req *swarmRequest) *swarmResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &swarmRequest{}
+		if r.Body != nil {
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil && err.Error() != "EOF" {
+				writeSwarmResponse(w, &swarmResponse{Err: "Request format illegal: " + err.Error()})
+				return
+			}
+		}
+
+		if req.Name != "" {
+			unlock := s.locks.lock(req.Name)
+			defer unlock()
+		}
+		log.Infof("Swarm, %s %s: %s", s.driver, r.URL.Path, req.Name)
+		resp := fn(req)
+		if resp.Err != "" {
+			log.Errorf("Swarm, %s %s: %s failed: %s", s.driver, r.URL.Path, req.Name, resp.Err)
+		}
+		writeSwarmResponse(w, resp)
+	}
+}
+
+func writeSwarmResponse(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", SWARM_CONTENT_TYPE)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Swarm, Write response error: %s", err.Error())
+	}
+}
+
+// create only record the volume, plugin is called when mount
+func (s *swarmServer) create(req *swarmRequest) *swarmResponse {
+	if req.Name == "" {
+		return &swarmResponse{Err: "Volume name is empty"}
+	}
+	if vol := s.catalog.get(s.driver, req.Name); vol != nil {
+		return &swarmResponse{}
+	}
+	vol := &swarmVolume{
+		Name:       req.Name,
+		Driver:     s.driver,
+		Options:    req.Opts,
+		Mountpoint: filepath.Join(SWARM_MOUNT_DIR, s.driver, req.Name),
+	}
+	if err := s.catalog.put(vol); err != nil {
+		return &swarmResponse{Err: "Save volume catalog error: " + err.Error()}
+	}
+	return &swarmResponse{}
+}
+
+func (s *swarmServer) remove(req *swarmRequest) *swarmResponse {
+	vol := s.catalog.get(s.driver, req.Name)
+	if vol == nil {
+		return &swarmResponse{}
+	}
+	if len(vol.MountIDs) != 0 {
+		return &swarmResponse{Err: "Volume is in use by: " + strings.Join(vol.MountIDs, ",")}
+	}
+	if err := s.catalog.remove(s.driver, req.Name); err != nil {
+		return &swarmResponse{Err: "Save volume catalog error: " + err.Error()}
+	}
+	return &swarmResponse{}
+}
+
+// mount the volume for the first container, attachable volume is attached and mounted to mountpoint directly
+func (s *swarmServer) mount(req *swarmRequest) *swarmResponse {
+	vol := s.catalog.get(s.driver, req.Name)
+	if vol == nil {
+		return &swarmResponse{Err: "Volume not found: " + req.Name}
+	}
+	if len(vol.MountIDs) != 0 {
+		vol.addMountID(req.ID)
+		if err := s.catalog.put(vol); err != nil {
+			return &swarmResponse{Err: "Save volume catalog error: " + err.Error()}
+		}
+		return &swarmResponse{Mountpoint: vol.Mountpoint}
+	}
+
+	opts, err := vol.pluginOptions()
+	if err != nil {
+		return &swarmResponse{Err: err.Error()}
+	}
+	if s.attachable {
+		result := s.call(s.driver, "attach", opts, nodeName())
+		if result.Status != "Success" {
+			return &swarmResponse{Err: "Attach failed: " + result.Message}
+		}
+		vol.Device = result.Device
+		result = s.call(s.driver, "mountdevice", vol.Mountpoint, vol.Device, opts)
+		if result.Status != "Success" {
+			return &swarmResponse{Err: "Mount device failed: " + result.Message}
+		}
+	} else {
+		result := s.call(s.driver, "mount", vol.Mountpoint, opts)
+		if result.Status != "Success" {
+			return &swarmResponse{Err: "Mount failed: " + result.Message}
+		}
+	}
+
+	vol.addMountID(req.ID)
+	if err := s.catalog.put(vol); err != nil {
+		return &swarmResponse{Err: "Save volume catalog error: " + err.Error()}
+	}
+	return &swarmResponse{Mountpoint: vol.Mountpoint}
+}
+
+// unmount the volume after the last container stopped
+func (s *swarmServer) unmount(req *swarmRequest) *swarmResponse {
+	vol := s.catalog.get(s.driver, req.Name)
+	if vol == nil {
+		return &swarmResponse{Err: "Volume not found: " + req.Name}
+	}
+	vol.removeMountID(req.ID)
+	if len(vol.MountIDs) != 0 {
+		if err := s.catalog.put(vol); err != nil {
+			return &swarmResponse{Err: "Save volume catalog error: " + err.Error()}
+		}
+		return &swarmResponse{}
+	}
+
+	if s.attachable {
+		result := s.call(s.driver, "unmountdevice", vol.Mountpoint)
+		if result.Status != "Success" {
+			return &swarmResponse{Err: "Unmount device failed: " + result.Message}
+		}
+		result = s.call(s.driver, "detach", vol.Name, nodeName())
+		if result.Status != "Success" {
+			return &swarmResponse{Err: "Detach failed: " + result.Message}
+		}
+		vol.Device = ""
+	} else {
+		result := s.call(s.driver, "unmount", vol.Mountpoint)
+		if result.Status != "Success" {
+			return &swarmResponse{Err: "Unmount failed: " + result.Message}
+		}
+	}
+
+	if err := s.catalog.put(vol); err != nil {
+		return &swarmResponse{Err: "Save volume catalog error: " + err.Error()}
+	}
+	return &swarmResponse{}
+}
+
+func (s *swarmServer) path(req *swarmRequest) *swarmResponse {
+	vol := s.catalog.get(s.driver, req.Name)
+	if vol == nil {
+		return &swarmResponse{Err: "Volume not found: " + req.Name}
+	}
+	return &swarmResponse{Mountpoint: vol.mountedPath()}
+}
+
+func (s *swarmServer) get(req *swarmRequest) *swarmResponse {
+	vol := s.catalog.get(s.driver, req.Name)
+	if vol == nil {
+		return &swarmResponse{Err: "Volume not found: " + req.Name}
+	}
+	return &swarmResponse{Volume: vol.info()}
+}
+
+func (s *swarmServer) list(req *swarmRequest) *swarmResponse {
+	resp := &swarmResponse{Volumes: []*swarmVolumeInfo{}}
+	for _, vol := range s.catalog.list(s.driver) {
+		resp.Volumes = append(resp.Volumes, vol.info())
+	}
+	return resp
+}
+
+// callPlugin call the plugin in process with kubelet style arguments
+func callPlugin(driver string, args ...string) utils.Result {
+	plugin := registry.NewPlugin(driver)
+	if plugin == nil {
+		return utils.FailWithCode(utils.CODE_INVALID_ARGUMENTS, "Not Support Plugin Driver: "+driver)
+	}
+	// calls are served concurrently, so the call fields are only logged with the result
+	args = append([]string{driver}, args...)
+	start := time.Now()
+	result := CallPlugin(plugin, args)
+	fields := callFields(args)
+	log.WithFields(resultFields(fields, result, start)).Info("Call finished")
+	auditCall(fields, args, result, start)
+	metricsCall(fields, result)
+	return result
+}
+
+// nodeName used for attach/detach, disk plugin get instance from metadata
+func nodeName() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// swarmVolume is the volume recorded in catalog
+type swarmVolume struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	Options    map[string]string `json:"options,omitempty"`
+	Mountpoint string            `json:"mountpoint"`
+	Device     string            `json:"device,omitempty"`
+	MountIDs   []string          `json:"mountIDs,omitempty"`
+}
+
+// pluginOptions convert docker volume options to flexvolume json options
+func (v *swarmVolume) pluginOptions() (string, error) {
+	opts := map[string]string{}
+	for key, value := range v.Options {
+		opts[key] = value
+	}
+	opts["kubernetes.io/pvOrVolumeName"] = v.Name
+	raw, err := json.Marshal(opts)
+	if err != nil {
+		return "", fmt.Errorf("Volume options illegal: %s", err.Error())
+	}
+	return string(raw), nil
+}
+
+func (v *swarmVolume) mountedPath() string {
+	if len(v.MountIDs) == 0 {
+		return ""
+	}
+	return v.Mountpoint
+}
+
+func (v *swarmVolume) info() *swarmVolumeInfo {
+	return &swarmVolumeInfo{Name: v.Name, Mountpoint: v.mountedPath()}
+}
+
+func (v *swarmVolume) addMountID(id string) {
+	for _, mountID := range v.MountIDs {
+		if mountID == id {
+			return
+		}
+	}
+	v.MountIDs = append(v.MountIDs, id)
+}
+
+func (v *swarmVolume) removeMountID(id string) {
+	mountIDs := []string{}
+	for _, mountID := range v.MountIDs {
+		if mountID != id {
+			mountIDs = append(mountIDs, mountID)
+		}
+	}
+	v.MountIDs = mountIDs
+}
+
+// copy return the volume not shared with catalog, it is changed and put back by the operation
+func (v *swarmVolume) copy() *swarmVolume {
+	vol := *v
+	vol.MountIDs = append([]string{}, v.MountIDs...)
+	return &vol
+}
+
+// swarmCatalog persist the volumes to file, so volumes survive the plugin restart.
+// The volumes are copied in and out, so the catalog is saved without racing the operations.
+type swarmCatalog struct {
+	file    string
+	mutex   sync.Mutex
+	Volumes map[string]*swarmVolume `json:"volumes"`
+}
+
+func loadSwarmCatalog(file string) (*swarmCatalog, error) {
+	catalog := &swarmCatalog{file: file, Volumes: map[string]*swarmVolume{}}
+	if !utils.IsFileExisting(file) {
+		return catalog, nil
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, catalog); err != nil {
+		return nil, err
+	}
+	if catalog.Volumes == nil {
+		catalog.Volumes = map[string]*swarmVolume{}
+	}
+	return catalog, nil
+}
+
+func swarmVolumeKey(driver, name string) string {
+	return driver + "/" + name
+}
+
+func (c *swarmCatalog) get(driver, name string) *swarmVolume {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if vol := c.Volumes[swarmVolumeKey(driver, name)]; vol != nil {
+		return vol.copy()
+	}
+	return nil
+}
+
+func (c *swarmCatalog) list(driver string) []*swarmVolume {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	volumes := []*swarmVolume{}
+	for _, vol := range c.Volumes {
+		if vol.Driver == driver {
+			volumes = append(volumes, vol.copy())
+		}
+	}
+	return volumes
+}
+
+// put add or update the volume and save the catalog
+func (c *swarmCatalog) put(vol *swarmVolume) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Volumes[swarmVolumeKey(vol.Driver, vol.Name)] = vol.copy()
+	return c.save()
+}
+
+func (c *swarmCatalog) remove(driver, name string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.Volumes, swarmVolumeKey(driver, name))
+	return c.save()
+}
+
+// save write the catalog to a temp file and rename, never leave a broken catalog, called with the mutex held
+func (c *swarmCatalog) save() error {
+	if c.file == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.CreateDest(filepath.Dir(c.file)); err != nil {
+		return err
+	}
+	tmpFile := c.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, c.file)
+}
+
+// volumeLocks serialize the operations of the same volume in the plugin process. The plugin calls
+// take the node lock of volume themselves, so the lock is in memory and the flock is not taken twice.
+type volumeLocks struct {
+	mutex sync.Mutex
+	locks map[string]*volumeLock
+}
+
+type volumeLock struct {
+	sync.Mutex
+	// the operations holding or waiting the lock, it is dropped with the last one
+	refs int
+}
+
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{locks: map[string]*volumeLock{}}
+}
+
+// lock wait for the lock of volume and return the function to release it
+func (l *volumeLocks) lock(name string) func() {
+	l.mutex.Lock()
+	lock := l.locks[name]
+	if lock == nil {
+		lock = &volumeLock{}
+		l.locks[name] = lock
+	}
+	lock.refs++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, name)
+		}
+		l.mutex.Unlock()
+	}
 }
@@ -0,0 +1,161 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+func postSwarm(t *testing.T, handler http.Handler, path string, req interface{}) *swarmResponse {
+	body, _ := json.Marshal(req)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", path, bytes.NewReader(body)))
+	resp := &swarmResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), resp); err != nil {
+		t.Fatalf("%s response illegal: %s", path, recorder.Body.String())
+	}
+	return resp
+}
+
+func TestSwarmDiskVolumeLifecycle(t *testing.T) {
+	calls := []string{}
+	caller := func(driver string, args ...string) utils.Result {
+		calls = append(calls, driver+" "+args[0])
+		if args[0] == "attach" {
+			return utils.Result{Status: "Success", Device: "/dev/vdc"}
+		}
+		return utils.Succeed()
+	}
+	tmpDir, _ := ioutil.TempDir("", "swarm")
+	defer os.RemoveAll(tmpDir)
+	catalogFile := filepath.Join(tmpDir, "volumes.json")
+	catalog, _ := loadSwarmCatalog(catalogFile)
+	server := &swarmServer{driver: "disk", attachable: true, catalog: catalog, call: caller, locks: newVolumeLocks()}
+	handler := server.handler()
+
+	if resp := postSwarm(t, handler, "/VolumeDriver.Create", &swarmRequest{Name: "vol1", Opts: map[string]string{"volumeId": "d-1"}}); resp.Err != "" {
+		t.Fatalf("create failed: %s", resp.Err)
+	}
+	resp := postSwarm(t, handler, "/VolumeDriver.Mount", &swarmRequest{Name: "vol1", ID: "c1"})
+	if resp.Err != "" || !strings.HasSuffix(resp.Mountpoint, "disk/vol1") {
+		t.Fatalf("mount failed: %+v", resp)
+	}
+	postSwarm(t, handler, "/VolumeDriver.Mount", &swarmRequest{Name: "vol1", ID: "c2"})
+	if resp := postSwarm(t, handler, "/VolumeDriver.Remove", &swarmRequest{Name: "vol1"}); resp.Err == "" {
+		t.Errorf("remove in use volume should fail")
+	}
+
+	// catalog survive restart
+	reloaded, err := loadSwarmCatalog(catalogFile)
+	if err != nil || len(reloaded.get("disk", "vol1").MountIDs) != 2 {
+		t.Fatalf("catalog not persisted: %v", err)
+	}
+
+	postSwarm(t, handler, "/VolumeDriver.Unmount", &swarmRequest{Name: "vol1", ID: "c1"})
+	postSwarm(t, handler, "/VolumeDriver.Unmount", &swarmRequest{Name: "vol1", ID: "c2"})
+	if resp := postSwarm(t, handler, "/VolumeDriver.Remove", &swarmRequest{Name: "vol1"}); resp.Err != "" {
+		t.Errorf("remove failed: %s", resp.Err)
+	}
+	if resp := postSwarm(t, handler, "/VolumeDriver.List", &swarmRequest{}); len(resp.Volumes) != 0 {
+		t.Errorf("volume not removed: %+v", resp.Volumes)
+	}
+
+	expect := "disk attach,disk mountdevice,disk unmountdevice,disk detach"
+	if strings.Join(calls, ",") != expect {
+		t.Errorf("plugin calls: %s, expect: %s", strings.Join(calls, ","), expect)
+	}
+}
+
+func TestSwarmVolumesInParallel(t *testing.T) {
+	// the mount of vol1 is blocked until vol2 is mounted, it hangs if the volumes are serialized
+	vol2Mounted := make(chan bool)
+	caller := func(driver string, args ...string) utils.Result {
+		if strings.HasSuffix(args[1], "vol1") {
+			select {
+			case <-vol2Mounted:
+			case <-time.After(5 * time.Second):
+				return utils.Fail("vol2 not mounted in parallel")
+			}
+		} else {
+			close(vol2Mounted)
+		}
+		return utils.Succeed()
+	}
+	catalog, _ := loadSwarmCatalog("")
+	server := &swarmServer{driver: "nas", catalog: catalog, call: caller, locks: newVolumeLocks()}
+	handler := server.handler()
+	postSwarm(t, handler, "/VolumeDriver.Create", &swarmRequest{Name: "vol1"})
+	postSwarm(t, handler, "/VolumeDriver.Create", &swarmRequest{Name: "vol2"})
+
+	errs := make(chan string, 2)
+	for _, name := range []string{"vol1", "vol2"} {
+		go func(name string) {
+			errs <- postSwarm(t, handler, "/VolumeDriver.Mount", &swarmRequest{Name: name, ID: "c1"}).Err
+		}(name)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != "" {
+			t.Errorf("mount failed: %s", err)
+		}
+	}
+}
+
+func TestSwarmVolumeSerialized(t *testing.T) {
+	running, maxRunning := 0, 0
+	mutex := sync.Mutex{}
+	caller := func(driver string, args ...string) utils.Result {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return utils.Succeed()
+	}
+	catalog, _ := loadSwarmCatalog("")
+	server := &swarmServer{driver: "nas", catalog: catalog, call: caller, locks: newVolumeLocks()}
+	handler := server.handler()
+	postSwarm(t, handler, "/VolumeDriver.Create", &swarmRequest{Name: "vol1"})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("c%d", i)
+			postSwarm(t, handler, "/VolumeDriver.Mount", &swarmRequest{Name: "vol1", ID: id})
+			postSwarm(t, handler, "/VolumeDriver.Unmount", &swarmRequest{Name: "vol1", ID: id})
+		}(i)
+	}
+	wg.Wait()
+	if maxRunning != 1 {
+		t.Errorf("plugin calls of one volume run in parallel: %d", maxRunning)
+	}
+	if len(server.locks.locks) != 0 {
+		t.Errorf("volume locks not released: %v", server.locks.locks)
+	}
+}
+
+func TestSwarmServeError(t *testing.T) {
+	catalog, _ := loadSwarmCatalog("")
+	server := &swarmServer{driver: "nas", catalog: catalog, call: callPlugin, locks: newVolumeLocks()}
+	err := server.serve("/nonexistent/alicloud-nas.sock")
+	if err == nil {
+		t.Fatalf("serve on missing dir should fail")
+	}
+}
@@ -0,0 +1,9 @@
+package driver
+
+import (
+	"time"
+)
+
+// wait the plugin to return its own result after the deadline, the deadline is configured
+// in flexvolume.conf by timeout, <driver>_timeout and <driver>_<verb>_timeout
+var callTimeoutGrace = 5 * time.Second
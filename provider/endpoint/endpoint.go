@@ -0,0 +1,203 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/metadata"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/location"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values of the endpoint resolver
+const (
+	SERVICE_ECS = "ecs"
+	SERVICE_NAS = "nas"
+	SERVICE_OSS = "oss"
+	SERVICE_STS = "sts"
+	// CACHE_DIR is on tmpfs of host, the endpoints of location service are shared by the plugin processes of node
+	CACHE_DIR = "/var/run/alicloud/endpoints"
+	CACHE_TTL = 24 * time.Hour
+	// the location service is skipped if it is not responded in the timeout
+	LOCATION_TIMEOUT = 5 * time.Second
+	// the endpoint types of location service, innerAPI is the vpc endpoint
+	LOCATION_VPC_TYPE    = "innerAPI"
+	LOCATION_PUBLIC_TYPE = "openAPI"
+	NETWORK_TYPE_TAG     = "network-type"
+	NETWORK_CLASSIC      = "classic"
+)
+
+// cacheDir is the dir of endpoint cache
+var cacheDir = CACHE_DIR
+
+// SetCacheDir change the dir of endpoint cache, used by tests
+func SetCacheDir(dir string) {
+	cacheDir = dir
+}
+
+// locate describe the endpoints by location service, replaced by tests
+var locate = describeEndpoints
+
+// Options of the endpoint to resolve
+type Options struct {
+	// Region of the endpoint, default the region of node
+	Region string
+	// Local is the endpoint of local file, eg: /etc/.volumeak/diskEcsEndpoint of Apsara Stack
+	Local string
+	// Credential call the location service, nil skip it
+	Credential *credentials.Credential
+}
+
+// Resolve return the endpoint of service, the first found in: the override of config, the local endpoint,
+// the cache, the location service and the table. The vpc endpoint is preferred unless the node is in classic network.
+func Resolve(ctx context.Context, service string, opts Options) (string, error) {
+	cfg := config.Get()
+	if ep := cfg.Endpoints[service]; ep != "" {
+		return ep, nil
+	}
+	if service == SERVICE_ECS && cfg.EcsEndpoint != "" {
+		return cfg.EcsEndpoint, nil
+	}
+	if opts.Local != "" {
+		return opts.Local, nil
+	}
+
+	region := opts.Region
+	if region == "" {
+		var err error
+		if region, err = metadata.RegionID(ctx); err != nil {
+			return "", fmt.Errorf("get region of %s endpoint error: %s", service, err.Error())
+		}
+	}
+	vpc := inVPC(ctx)
+	network := "vpc"
+	if !vpc {
+		network = "public"
+	}
+	file := filepath.Join(cacheDir, service+"-"+region+"-"+network)
+	if ep := readCache(file); ep != "" {
+		return ep, nil
+	}
+
+	if opts.Credential != nil {
+		endpoints, err := locate(ctx, service, region, opts.Credential)
+		if ep := pick(endpoints, vpc); err == nil && ep != "" {
+			if err := writeCache(file, ep); err != nil {
+				log.Warnf("Endpoint, cache %s error: %s", file, err.Error())
+			}
+			return ep, nil
+		}
+		if err != nil {
+			log.Warnf("Endpoint, describe %s endpoints of %s error: %s, use the table", service, region, err.Error())
+		}
+	}
+
+	endpoints, ok := lookup(service, region)
+	if !ok {
+		return "", fmt.Errorf("no endpoint of %s in region %s", service, region)
+	}
+	return pick(endpoints, vpc), nil
+}
+
+// URL add the https scheme to the endpoint of cloud api
+func URL(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	return "https://" + endpoint
+}
+
+func pick(endpoints Endpoints, vpc bool) string {
+	if vpc && endpoints.VPC != "" {
+		return endpoints.VPC
+	}
+	return endpoints.Public
+}
+
+// inVPC check the network of node, the node of kubernetes is in vpc if the metadata is unknown
+func inVPC(ctx context.Context) bool {
+	network, err := metadata.Default().Get(ctx, NETWORK_TYPE_TAG)
+	if err != nil {
+		log.Debugf("Endpoint, get network type error: %s, prefer vpc", err.Error())
+		return true
+	}
+	return network != NETWORK_CLASSIC
+}
+
+// describeEndpoints call the location service, the sdk client has no context so the call is abandoned on timeout
+func describeEndpoints(ctx context.Context, service, region string, cred *credentials.Credential) (Endpoints, error) {
+	client := location.NewClient(cred.AccessKeyID, cred.AccessKeySecret)
+	client.SetSecurityToken(cred.SecurityToken)
+
+	type result struct {
+		resp *location.DescribeEndpointsResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := client.DescribeEndpoints(&location.DescribeEndpointsArgs{Id: common.Region(region), ServiceCode: service})
+		done <- result{resp: resp, err: err}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, LOCATION_TIMEOUT)
+	defer cancel()
+	endpoints := Endpoints{}
+	select {
+	case <-ctx.Done():
+		return endpoints, fmt.Errorf("location service not responded: %s", ctx.Err())
+	case r := <-done:
+		if r.err != nil {
+			return endpoints, r.err
+		}
+		for _, item := range r.resp.Endpoints.Endpoint {
+			switch item.Type {
+			case LOCATION_VPC_TYPE:
+				endpoints.VPC = item.Endpoint
+			case LOCATION_PUBLIC_TYPE:
+				endpoints.Public = item.Endpoint
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// readCache return empty if the cache is missing or expired
+func readCache(file string) string {
+	info, err := os.Stat(file)
+	if err != nil || time.Since(info.ModTime()) > CACHE_TTL {
+		return ""
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// writeCache replace the cache file by rename, the readers never see a partial endpoint
+func writeCache(file, endpoint string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(endpoint)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,99 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/metadata"
+)
+
+// fakeMetadata serve the metadata of node
+type fakeMetadata map[string]string
+
+func (m fakeMetadata) Get(ctx context.Context, resource string) (string, error) {
+	if value, ok := m[resource]; ok {
+		return value, nil
+	}
+	return "", &metadata.NotFoundError{Resource: resource}
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "endpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetCacheDir(dir)
+	defer SetCacheDir(CACHE_DIR)
+
+	node := fakeMetadata{metadata.REGIONID_TAG: "cn-zhangjiakou"}
+	metadata.Set(node)
+	defer metadata.Set(nil)
+	cfg := config.Default()
+	config.Set(cfg)
+	defer config.Set(nil)
+
+	calls := 0
+	locate = func(ctx context.Context, service, region string, cred *credentials.Credential) (Endpoints, error) {
+		calls++
+		if service != SERVICE_ECS {
+			return Endpoints{}, fmt.Errorf("service %s not found", service)
+		}
+		return Endpoints{VPC: "ecs-vpc.located.aliyuncs.com", Public: "ecs.located.aliyuncs.com"}, nil
+	}
+	defer func() { locate = describeEndpoints }()
+	ctx := context.Background()
+	cred := &credentials.Credential{AccessKeyID: "id", AccessKeySecret: "secret"}
+
+	cases := []struct {
+		service string
+		opts    Options
+		expect  string
+	}{
+		// the table and pattern of region without location service
+		{SERVICE_OSS, Options{}, "oss-cn-zhangjiakou-internal.aliyuncs.com"},
+		{SERVICE_ECS, Options{Region: "cn-hangzhou"}, "ecs-vpc.cn-hangzhou.aliyuncs.com"},
+		// the location service fallback to table
+		{SERVICE_NAS, Options{Credential: cred}, "nas-vpc.cn-zhangjiakou.aliyuncs.com"},
+		// the location service, and the cache
+		{SERVICE_ECS, Options{Credential: cred}, "ecs-vpc.located.aliyuncs.com"},
+		{SERVICE_ECS, Options{Credential: cred}, "ecs-vpc.located.aliyuncs.com"},
+		// the local file
+		{SERVICE_ECS, Options{Credential: cred, Local: "ecs.apsara.local"}, "ecs.apsara.local"},
+	}
+	for _, c := range cases {
+		if ep, err := Resolve(ctx, c.service, c.opts); err != nil || ep != c.expect {
+			t.Errorf("resolve %s %+v: expect %s, got %s, %v", c.service, c.opts, c.expect, ep, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expect the located endpoint cached, got %d calls", calls)
+	}
+
+	// the public endpoint in classic network
+	node[NETWORK_TYPE_TAG] = NETWORK_CLASSIC
+	if ep, _ := Resolve(ctx, SERVICE_OSS, Options{}); ep != "oss-cn-zhangjiakou.aliyuncs.com" {
+		t.Errorf("expect public oss endpoint, got %s", ep)
+	}
+	if ep, _ := Resolve(ctx, SERVICE_ECS, Options{Credential: cred}); ep != "ecs.located.aliyuncs.com" || calls != 3 {
+		t.Errorf("expect public located endpoint, got %s", ep)
+	}
+
+	// the override of config
+	cfg.EcsEndpoint = "https://ecs.legacy"
+	cfg.Endpoints[SERVICE_OSS] = "oss-override.aliyuncs.com"
+	if ep, _ := Resolve(ctx, SERVICE_ECS, Options{Local: "ecs.apsara.local"}); ep != "https://ecs.legacy" {
+		t.Errorf("expect ecs_endpoint, got %s", ep)
+	}
+	if ep, _ := Resolve(ctx, SERVICE_OSS, Options{}); ep != "oss-override.aliyuncs.com" {
+		t.Errorf("expect override endpoint, got %s", ep)
+	}
+	if URL("ecs.aliyuncs.com") != "https://ecs.aliyuncs.com" || URL("http://ecs.local") != "http://ecs.local" {
+		t.Errorf("unexpected url")
+	}
+}
@@ -0,0 +1,63 @@
+package endpoint
+
+import "strings"
+
+// Endpoints of the service in region, the vpc endpoint is reachable only in vpc
+type Endpoints struct {
+	VPC    string
+	Public string
+}
+
+// PATTERNS are the endpoints of services in the regions not in TABLE, {region} is replaced by the region
+var PATTERNS = map[string]Endpoints{
+	SERVICE_ECS: {VPC: "ecs-vpc.{region}.aliyuncs.com", Public: "ecs.{region}.aliyuncs.com"},
+	SERVICE_NAS: {VPC: "nas-vpc.{region}.aliyuncs.com", Public: "nas.{region}.aliyuncs.com"},
+	SERVICE_OSS: {VPC: "oss-{region}-internal.aliyuncs.com", Public: "oss-{region}.aliyuncs.com"},
+	SERVICE_STS: {VPC: "sts-vpc.{region}.aliyuncs.com", Public: "sts.aliyuncs.com"},
+}
+
+// TABLE is the region -> service -> endpoints not following the patterns
+var TABLE = map[string]map[string]Endpoints{
+	"cn-hangzhou": {
+		SERVICE_ECS: {VPC: "ecs-vpc.cn-hangzhou.aliyuncs.com", Public: "ecs-cn-hangzhou.aliyuncs.com"},
+	},
+	"cn-beijing": {
+		SERVICE_ECS: {VPC: "ecs-vpc.cn-beijing.aliyuncs.com", Public: "ecs.aliyuncs.com"},
+	},
+	"cn-qingdao": {
+		SERVICE_ECS: {VPC: "ecs-vpc.cn-qingdao.aliyuncs.com", Public: "ecs.aliyuncs.com"},
+	},
+	"cn-shanghai": {
+		SERVICE_ECS: {VPC: "ecs-vpc.cn-shanghai.aliyuncs.com", Public: "ecs.aliyuncs.com"},
+	},
+	"cn-shenzhen": {
+		SERVICE_ECS: {VPC: "ecs-vpc.cn-shenzhen.aliyuncs.com", Public: "ecs.aliyuncs.com"},
+	},
+	"cn-hongkong": {
+		SERVICE_ECS: {VPC: "ecs-vpc.cn-hongkong.aliyuncs.com", Public: "ecs.aliyuncs.com"},
+	},
+	"ap-southeast-1": {
+		SERVICE_ECS: {VPC: "ecs-vpc.ap-southeast-1.aliyuncs.com", Public: "ecs.aliyuncs.com"},
+	},
+	"us-west-1": {
+		SERVICE_ECS: {VPC: "ecs-vpc.us-west-1.aliyuncs.com", Public: "ecs.aliyuncs.com"},
+	},
+	"us-east-1": {
+		SERVICE_ECS: {VPC: "ecs-vpc.us-east-1.aliyuncs.com", Public: "ecs.aliyuncs.com"},
+	},
+}
+
+// lookup the endpoints of service in region in the table, or by the pattern
+func lookup(service, region string) (Endpoints, bool) {
+	if endpoints, ok := TABLE[region][service]; ok {
+		return endpoints, true
+	}
+	pattern, ok := PATTERNS[service]
+	if !ok || region == "" {
+		return Endpoints{}, false
+	}
+	return Endpoints{
+		VPC:    strings.Replace(pattern.VPC, "{region}", region, -1),
+		Public: strings.Replace(pattern.Public, "{region}", region, -1),
+	}, true
+}
@@ -0,0 +1,337 @@
+package journal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// const values for the operation journal
+const (
+	// JOURNAL_DIR keep one file for every unfinished operation, under /etc as the monitor read it from host
+	JOURNAL_DIR = "/etc/kubernetes/volumes/journal"
+	// the operation is recovered by monitor if the process is gone longer than it
+	STALE_PERIOD = 5 * time.Minute
+
+	STATE_RUNNING   = "running"
+	STATE_ABANDONED = "abandoned"
+	// DATA_INTERRUPTED record the call interrupted after its deadline
+	DATA_INTERRUPTED = "interrupted"
+
+	// BOOT_ID_FILE is changed by every boot, the operations written before the reboot are abandoned
+	BOOT_ID_FILE = "sys/kernel/random/boot_id"
+	// the start time of process is the 22nd field of /proc/<pid>/stat
+	STAT_START_FIELD = 22
+)
+
+// procDir is the proc filesystem of host, changed by tests
+var procDir = "/proc"
+
+// Operation is a multi-step volume operation written ahead of every step,
+// the file is removed when the operation is finished.
+type Operation struct {
+	ID     string `json:"id"`
+	Driver string `json:"driver"`
+	Verb   string `json:"verb"`
+	Volume string `json:"volume"`
+	Pid    int    `json:"pid"`
+	// PidStart and BootID identify the process, the pid is reused after reboot or wrap
+	PidStart uint64            `json:"pidStart,omitempty"`
+	BootID   string            `json:"bootId,omitempty"`
+	Step     string            `json:"step"`
+	Started  time.Time         `json:"started"`
+	Updated  time.Time         `json:"updated"`
+	Data     map[string]string `json:"data,omitempty"`
+
+	dir string
+}
+
+// Recoverer resume or roll back the abandoned operation of driver, files of host are under root
+type Recoverer func(ctx context.Context, root string, op *Operation) error
+
+var (
+	mutex      sync.RWMutex
+	recoverers = map[string]Recoverer{}
+)
+
+// RegisterRecoverer set the recoverer of driver, called in init() of the driver package
+func RegisterRecoverer(driver string, recoverer Recoverer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	recoverers[driver] = recoverer
+}
+
+// operation file of the volume, one operation for a volume at a time
+func operationFile(dir, driver, volume string) string {
+	return filepath.Join(dir, driver+"-"+filepath.Base(volume)+".json")
+}
+
+// Begin write the operation with the first step, the unfinished operation of the volume is replaced
+func Begin(dir, driver, verb, volume string, data map[string]string) (*Operation, error) {
+	now := time.Now()
+	op := &Operation{
+		ID:      newID(),
+		Driver:  driver,
+		Verb:    verb,
+		Volume:  volume,
+		Step:    "begin",
+		Started: now,
+		Updated: now,
+		Data:    map[string]string{},
+		dir:     dir,
+	}
+	for key, value := range data {
+		op.Data[key] = value
+	}
+	op.stamp()
+	return op, op.write()
+}
+
+// stamp record the current process as the owner of operation
+func (op *Operation) stamp() {
+	op.Pid = os.Getpid()
+	op.PidStart, _ = processStart(op.Pid)
+	op.BootID = bootID()
+}
+
+// newID return a random id of operation
+func newID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Load return the unfinished operation of the volume, nil if not exist
+func Load(dir, driver, volume string) (*Operation, error) {
+	return load(operationFile(dir, driver, volume))
+}
+
+func load(file string) (*Operation, error) {
+	raw, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	op := &Operation{}
+	if err := json.Unmarshal(raw, op); err != nil {
+		return nil, fmt.Errorf("parse journal %s error: %s", file, err.Error())
+	}
+	op.dir = filepath.Dir(file)
+	if op.Data == nil {
+		op.Data = map[string]string{}
+	}
+	return op, nil
+}
+
+// List return the unfinished operations in the dir, oldest first
+func List(dir string) ([]*Operation, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	ops := []*Operation{}
+	for _, file := range files {
+		op, err := load(file)
+		if err != nil {
+			log.Warnf("Journal, %s", err.Error())
+			continue
+		}
+		if op != nil {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Started.Before(ops[j].Started) })
+	return ops, nil
+}
+
+// Next record the step before doing it, the data is merged into the operation.
+// The caller should stop if the journal can not be written.
+func (op *Operation) Next(step string, data map[string]string) error {
+	op.Step = step
+	op.stamp()
+	op.Updated = time.Now()
+	for key, value := range data {
+		op.Data[key] = value
+	}
+	return op.write()
+}
+
+// Finish remove the operation from journal
+func (op *Operation) Finish() error {
+	err := os.Remove(operationFile(op.dir, op.Driver, op.Volume))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(op.dir)
+}
+
+// Abandon release the operation by the failed call, the process of daemon keep living after it.
+// The next call of volume resume or restart it, and it is recovered when it is stale.
+func (op *Operation) Abandon() error {
+	op.Pid, op.PidStart, op.BootID = 0, 0, ""
+	op.Updated = time.Now()
+	return op.write()
+}
+
+// State return running if the process of operation is alive, otherwise abandoned
+func (op *Operation) State() string {
+	if op.ownerAlive() {
+		return STATE_RUNNING
+	}
+	return STATE_ABANDONED
+}
+
+// ownerAlive check the process of operation is alive in this boot, and the pid is not reused by another process.
+// The operation written without the process identity only check the pid.
+func (op *Operation) ownerAlive() bool {
+	if op.Pid <= 0 {
+		return false
+	}
+	if op.BootID != "" && op.BootID != bootID() {
+		return false
+	}
+	if op.PidStart != 0 {
+		start, ok := processStart(op.Pid)
+		return ok && start == op.PidStart
+	}
+	return op.Pid == os.Getpid() || processAlive(op.Pid)
+}
+
+// Stale check the operation is abandoned longer than the stale period
+func (op *Operation) Stale() bool {
+	return op.State() == STATE_ABANDONED && time.Since(op.Updated) > STALE_PERIOD
+}
+
+// write the operation file atomically and durably
+func (op *Operation) write() error {
+	if err := os.MkdirAll(op.dir, 0755); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(op.dir, ".journal")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), operationFile(op.dir, op.Driver, op.Volume)); err != nil {
+		return err
+	}
+	return syncDir(op.dir)
+}
+
+// sync the dir to persist the rename and remove of files
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// processAlive check the process exist, the pid namespace of host is shared by monitor
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// bootID return the id of current boot, empty if unknown
+func bootID() string {
+	raw, err := ioutil.ReadFile(filepath.Join(procDir, BOOT_ID_FILE))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// processStart return the start time of process in clock ticks after boot, false if the process not exist.
+// The command name in stat may have spaces and parentheses, the fields are counted after the last ')'.
+func processStart(pid int) (uint64, bool) {
+	raw, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, false
+	}
+	stat := string(raw)
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return 0, false
+	}
+	// the fields after command name start from the 3rd field, state
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < STAT_START_FIELD-2 {
+		return 0, false
+	}
+	start, err := strconv.ParseUint(fields[STAT_START_FIELD-3], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+// Recover call the recoverers on the stale operations in the journal under root
+func Recover(ctx context.Context, root string) {
+	ops, err := List(filepath.Join(root, JOURNAL_DIR))
+	if err != nil {
+		log.Warnf("Journal, List operations error: %s", err.Error())
+		return
+	}
+	for _, op := range ops {
+		if !op.Stale() {
+			continue
+		}
+		mutex.RLock()
+		recoverer, ok := recoverers[op.Driver]
+		mutex.RUnlock()
+		if !ok {
+			log.Warnf("Journal, No recoverer for %s operation: %s %s", op.Driver, op.Verb, op.Volume)
+			continue
+		}
+		log.Infof("Journal, Recover %s operation: %s %s at step %s, started at %s", op.Driver, op.Verb, op.Volume, op.Step, op.Started.Format(time.RFC3339))
+		if err := recoverer(ctx, root, op); err != nil {
+			log.Errorf("Journal, Recover %s operation %s %s error: %s", op.Driver, op.Verb, op.Volume, err.Error())
+		}
+	}
+}
+
+// String describe the operation in log
+func (op *Operation) String() string {
+	data := []string{}
+	for key, value := range op.Data {
+		data = append(data, key+"="+value)
+	}
+	sort.Strings(data)
+	return fmt.Sprintf("%s %s %s at step %s (%s)", op.Driver, op.Verb, op.Volume, op.Step, strings.Join(data, ", "))
+}
@@ -0,0 +1,202 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOperation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	op, err := Begin(dir, "disk", "attach", "pv-disk", map[string]string{"diskId": "d-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Next("attaching", map[string]string{"devices": "vda,vda1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(dir, "disk", "pv-disk")
+	if err != nil || loaded == nil {
+		t.Fatalf("load operation: %v, %v", loaded, err)
+	}
+	if loaded.ID != op.ID || loaded.Step != "attaching" || loaded.Data["diskId"] != "d-1" || loaded.Data["devices"] != "vda,vda1" {
+		t.Fatalf("unexpected operation: %+v", loaded)
+	}
+	if loaded.State() != STATE_RUNNING || loaded.Stale() {
+		t.Fatalf("operation of this process should be running")
+	}
+
+	ops, err := List(dir)
+	if err != nil || len(ops) != 1 {
+		t.Fatalf("list operations: %v, %v", ops, err)
+	}
+
+	// the abandoned operation of living process is not running, and is recovered when it is stale
+	if err := loaded.Abandon(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := Load(dir, "disk", "pv-disk"); err != nil || loaded.State() != STATE_ABANDONED || loaded.Step != "attaching" {
+		t.Fatalf("operation should be abandoned: %v, %v", loaded, err)
+	}
+
+	if err := loaded.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := Load(dir, "disk", "pv-disk"); err != nil || loaded != nil {
+		t.Fatalf("operation should be removed: %v, %v", loaded, err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	root, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := root + JOURNAL_DIR
+
+	fresh, err := Begin(dir, "test", "attach", "pv-fresh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh.Pid = -1
+	stale, err := Begin(dir, "test", "attach", "pv-stale", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Pid, stale.Updated = -1, time.Now().Add(-2*STALE_PERIOD)
+	for _, op := range []*Operation{fresh, stale} {
+		if err := op.write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recovered := []string{}
+	RegisterRecoverer("test", func(ctx context.Context, r string, op *Operation) error {
+		if r != root {
+			t.Errorf("unexpected root: %s", r)
+		}
+		recovered = append(recovered, op.Volume)
+		return op.Finish()
+	})
+	Recover(context.Background(), root)
+	if len(recovered) != 1 || recovered[0] != "pv-stale" {
+		t.Fatalf("only the stale operation should be recovered: %v", recovered)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Query([]string{"--dir", dir, "--driver", "test"}, out); err != nil {
+		t.Fatal(err)
+	}
+	state := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &state); err != nil {
+		t.Fatalf("parse %q: %s", out.String(), err)
+	}
+	if state["volume"] != "pv-fresh" || state["state"] != STATE_ABANDONED {
+		t.Fatalf("unexpected query output: %s", out.String())
+	}
+}
+
+func TestOwnerAlive(t *testing.T) {
+	proc, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(proc)
+	procDir = proc
+	defer func() { procDir = "/proc" }()
+
+	writeProc := func(file, content string) {
+		file = filepath.Join(proc, file)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the command name with spaces and parentheses
+	stat := func(start int) string {
+		return fmt.Sprintf("4242 (flex vol) (x)) S 1 4242 4242 0 -1 4194304 80 0 0 0 0 0 0 0 20 0 1 0 %d 2703360 287\n", start)
+	}
+	writeProc(BOOT_ID_FILE, "boot-1\n")
+	writeProc("4242/stat", stat(1000))
+	if start, ok := processStart(4242); !ok || start != 1000 {
+		t.Fatalf("expect start time 1000, got %d, %t", start, ok)
+	}
+
+	op := &Operation{Pid: 4242, PidStart: 1000, BootID: "boot-1"}
+	if op.State() != STATE_RUNNING {
+		t.Errorf("expect the owner running")
+	}
+
+	// the pid is reused by another process
+	writeProc("4242/stat", stat(2000))
+	if op.State() != STATE_ABANDONED {
+		t.Errorf("expect abandoned of reused pid")
+	}
+
+	// the operation written before reboot, the pid is alive with the same start time in this boot
+	writeProc("4242/stat", stat(1000))
+	writeProc(BOOT_ID_FILE, "boot-2\n")
+	op.Updated = time.Now().Add(-2 * STALE_PERIOD)
+	if op.State() != STATE_ABANDONED || !op.Stale() {
+		t.Errorf("expect stale operation written before reboot")
+	}
+
+	// the process is gone
+	os.RemoveAll(filepath.Join(proc, "4242"))
+	op.BootID = "boot-2"
+	if op.State() != STATE_ABANDONED {
+		t.Errorf("expect abandoned of exited process")
+	}
+}
+
+func TestRecoverReusedPid(t *testing.T) {
+	root, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := root + JOURNAL_DIR
+
+	// the pid of this process is alive, but it is not the owner of operations
+	reused, err := Begin(dir, "reused", "attach", "pv-reused", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused.PidStart == 0 || reused.BootID == "" {
+		t.Fatalf("expect the process identity recorded: %+v", reused)
+	}
+	reused.PidStart, reused.Updated = reused.PidStart+1, time.Now().Add(-2*STALE_PERIOD)
+	rebooted, err := Begin(dir, "reused", "detach", "pv-rebooted", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebooted.BootID, rebooted.Updated = "before-reboot", time.Now().Add(-2*STALE_PERIOD)
+	for _, op := range []*Operation{reused, rebooted} {
+		if err := op.write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recovered := []string{}
+	RegisterRecoverer("reused", func(ctx context.Context, r string, op *Operation) error {
+		recovered = append(recovered, op.Volume)
+		return op.Finish()
+	})
+	Recover(context.Background(), root)
+	if len(recovered) != 2 {
+		t.Fatalf("expect the operations of reused pid recovered: %v", recovered)
+	}
+}
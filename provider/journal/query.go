@@ -0,0 +1,41 @@
+package journal
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+)
+
+// operationState is the operation printed with its state
+type operationState struct {
+	*Operation
+	State string `json:"state"`
+}
+
+// Query run the journal subcommand, print the unfinished operations as json lines:
+// flexvolume journal --volume pv-disk
+func Query(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("journal", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dir := flags.String("dir", JOURNAL_DIR, "journal directory")
+	volume := flags.String("volume", "", "volume name")
+	driver := flags.String("driver", "", "driver name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ops, err := List(*dir)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	for _, op := range ops {
+		if (*volume != "" && op.Volume != *volume) || (*driver != "" && op.Driver != *driver) {
+			continue
+		}
+		if err := encoder.Encode(&operationState{Operation: op, State: op.State()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package locking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values for the node locks
+const (
+	// LOCK_DIR is on tmpfs of host, the locks are gone with reboot
+	LOCK_DIR = "/var/run/alicloud/locks"
+	// default time to wait for the lock, the call timeout is the upper limit
+	LOCK_TIMEOUT = 2 * time.Minute
+	// the holder is reported as stuck if the lock is held longer than it
+	STALE_PERIOD = 10 * time.Minute
+	// interval to try the lock again
+	POLL_INTERVAL = 100 * time.Millisecond
+)
+
+// lockDir is the dir of lock files
+var lockDir = LOCK_DIR
+
+// SetDir change the dir of lock files, used by tests
+func SetDir(dir string) {
+	lockDir = dir
+}
+
+// Lock is an exclusive lock between the plugin processes of node. It is a flock on the lock file,
+// so it is released by kernel if the holder is killed; the file records the holder for diagnosis.
+type Lock struct {
+	name string
+	file *os.File
+}
+
+// holder is the process holding the lock, written in the lock file
+type holder struct {
+	Pid      int       `json:"pid"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// Volume lock the volume of driver for mount, unmount, attach and detach,
+// operations of different volumes run in parallel.
+func Volume(ctx context.Context, driver, volume string) (*Lock, error) {
+	return Acquire(ctx, "volume-"+driver+"-"+volume, LOCK_TIMEOUT)
+}
+
+// Node lock the resource shared by all volumes of node, eg: device discovery of disk
+func Node(ctx context.Context, name string) (*Lock, error) {
+	return Acquire(ctx, "node-"+name, LOCK_TIMEOUT)
+}
+
+// Acquire wait for the lock until timeout or the context is done
+func Acquire(ctx context.Context, name string, timeout time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(lockFile(name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	warned := false
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, err
+		}
+
+		owner := readHolder(file)
+		if !warned && owner != nil && time.Since(owner.Acquired) > STALE_PERIOD {
+			log.Warnf("Lock, %s is held by pid %d longer than %s, since %s", name, owner.Pid, STALE_PERIOD, owner.Acquired.Format(time.RFC3339))
+			warned = true
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, utils.NewCodeError(utils.CODE_LOCK_TIMEOUT, fmt.Sprintf("wait for lock %s timeout after %s, %s", name, timeout, owner))
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, utils.NewCodeError(utils.CODE_LOCK_TIMEOUT, fmt.Sprintf("wait for lock %s interrupted: %s, %s", name, ctx.Err(), owner))
+		case <-time.After(POLL_INTERVAL):
+		}
+	}
+
+	// the holder is left if the last one is killed, the lock is taken over as it is released by kernel
+	if owner := readHolder(file); owner != nil && owner.Pid != os.Getpid() {
+		log.Warnf("Lock, take over the stale lock %s of pid %d, acquired at %s", name, owner.Pid, owner.Acquired.Format(time.RFC3339))
+	}
+	if err := writeHolder(file, &holder{Pid: os.Getpid(), Acquired: time.Now()}); err != nil {
+		log.Warnf("Lock, record holder of %s error: %s", name, err.Error())
+	}
+	return &Lock{name: name, file: file}, nil
+}
+
+// Release clear the holder and unlock, the lock file is kept for the next holder
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	if err := l.file.Truncate(0); err != nil {
+		log.Warnf("Lock, clear holder of %s error: %s", l.name, err.Error())
+	}
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+	return err
+}
+
+// lock file of the name, the path separators in volume name are replaced
+func lockFile(name string) string {
+	return filepath.Join(lockDir, strings.Replace(name, string(filepath.Separator), "_", -1)+".lck")
+}
+
+// readHolder return nil if the lock is released or not recorded
+func readHolder(file *os.File) *holder {
+	raw, err := ioutil.ReadFile(file.Name())
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+	owner := &holder{}
+	if err := json.Unmarshal(raw, owner); err != nil {
+		return nil
+	}
+	return owner
+}
+
+func writeHolder(file *os.File, owner *holder) error {
+	raw, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(raw, 0)
+	return err
+}
+
+// String describe the holder in error
+func (h *holder) String() string {
+	if h == nil {
+		return "holder unknown"
+	}
+	return fmt.Sprintf("held by pid %d since %s", h.Pid, h.Acquired.Format(time.RFC3339))
+}
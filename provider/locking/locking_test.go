@@ -0,0 +1,102 @@
+package locking
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetDir(dir)
+	defer SetDir(LOCK_DIR)
+
+	ctx := context.Background()
+	lock, err := Volume(ctx, "disk", "pv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the other volume is not blocked
+	other, err := Volume(ctx, "disk", "pv-2")
+	if err != nil {
+		t.Fatalf("lock other volume: %s", err)
+	}
+	other.Release()
+
+	// the same volume wait until timeout, and report the holder
+	_, err = Acquire(ctx, "volume-disk-pv-1", 3*POLL_INTERVAL)
+	if utils.ErrorCodeOf(err, "") != utils.CODE_LOCK_TIMEOUT || !strings.Contains(err.Error(), "held by pid") {
+		t.Fatalf("expect lock timeout with holder, got: %v", err)
+	}
+
+	// the waiter get the lock after release
+	acquired := make(chan error, 1)
+	go func() {
+		lock, err := Volume(ctx, "disk", "pv-1")
+		if err == nil {
+			lock.Release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(2 * POLL_INTERVAL)
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("waiter failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waiter is not woken up after release")
+	}
+
+	// the wait is interrupted by context
+	lock, err = Node(ctx, "disk-devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := Node(cancelCtx, "disk-devices"); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expect interrupted, got: %v", err)
+	}
+}
+
+func TestStaleHolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetDir(dir)
+	defer SetDir(LOCK_DIR)
+
+	// the holder of a killed process is left in the file, but the flock is released
+	if err := ioutil.WriteFile(lockFile("volume-nas-pv"), []byte(`{"pid":-1,"acquired":"2020-01-01T00:00:00Z"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := Volume(context.Background(), "nas", "pv")
+	if err != nil {
+		t.Fatalf("stale lock should be taken over: %s", err)
+	}
+	if owner := readHolder(lock.file); owner == nil || owner.Pid != os.Getpid() {
+		t.Fatalf("holder is not updated: %v", owner)
+	}
+	lock.Release()
+	raw, _ := ioutil.ReadFile(lockFile("volume-nas-pv"))
+	if len(raw) != 0 {
+		t.Fatalf("holder should be cleared after release: %s", raw)
+	}
+}
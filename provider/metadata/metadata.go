@@ -0,0 +1,122 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+)
+
+// const values of the ecs metadata
+const (
+	// CACHE_DIR is on tmpfs of host, the cache is shared by the plugin processes of node
+	CACHE_DIR      = "/var/run/alicloud/metadata"
+	META_DATA_PATH = "/latest/meta-data/"
+	REGIONID_TAG   = "region-id"
+	INSTANCEID_TAG = "instance-id"
+	RAM_ROLE_TAG   = "ram/security-credentials/"
+	// interval between the retries, multiplied by the attempt
+	RETRY_INTERVAL = 200 * time.Millisecond
+)
+
+// Provider return the value of the metadata resource, eg: region-id, ram/security-credentials/<role>
+type Provider interface {
+	Get(ctx context.Context, resource string) (string, error)
+}
+
+// NotFoundError is returned if the resource is not provided, the next provider of chain is tried
+type NotFoundError struct {
+	Resource string
+}
+
+func (e *NotFoundError) Error() string {
+	return "metadata " + e.Resource + " not found"
+}
+
+// IsNotFound check the error is NotFoundError
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// RoleAuth is the sts token of the ram role of instance
+type RoleAuth struct {
+	AccessKeyId     string
+	AccessKeySecret string
+	SecurityToken   string
+	Expiration      time.Time
+	Code            string
+}
+
+// the provider of process, built from config once
+var (
+	mutex    sync.Mutex
+	provider Provider
+	cacheDir = CACHE_DIR
+)
+
+// New build the provider of config: the static file first, then the metadata server with cache
+func New(cfg *config.Config) Provider {
+	var server Provider = NewHTTP(cfg.MetadataURL, time.Duration(cfg.MetadataTimeout), cfg.MetadataRetries)
+	server = NewCached(server, cacheDir, time.Duration(cfg.MetadataCacheTTL))
+	if cfg.MetadataFile == "" {
+		return server
+	}
+	return NewChain(NewFile(cfg.MetadataFile), server)
+}
+
+// Default return the provider of process
+func Default() Provider {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if provider == nil {
+		provider = New(config.Get())
+	}
+	return provider
+}
+
+// Set replace the provider of process, nil rebuild it with the reloaded config
+func Set(p Provider) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	provider = p
+}
+
+// RegionID get the region of node
+func RegionID(ctx context.Context) (string, error) {
+	return Default().Get(ctx, REGIONID_TAG)
+}
+
+// InstanceID get the ecs instance of node
+func InstanceID(ctx context.Context) (string, error) {
+	return Default().Get(ctx, INSTANCEID_TAG)
+}
+
+// RoleToken get the sts token of the ram role attached to the instance
+func RoleToken(ctx context.Context) (*RoleAuth, error) {
+	roles, err := Default().Get(ctx, RAM_ROLE_TAG)
+	if err != nil {
+		return nil, fmt.Errorf("Get role name error: %s", err.Error())
+	}
+	role := strings.TrimSpace(strings.Split(roles, "\n")[0])
+	if role == "" {
+		return nil, fmt.Errorf("Get role name error: no ram role is attached to the instance")
+	}
+
+	raw, err := Default().Get(ctx, RAM_ROLE_TAG+role)
+	if err != nil {
+		return nil, fmt.Errorf("Get STS Token error: %s", err.Error())
+	}
+	auth := &RoleAuth{}
+	if err := json.Unmarshal([]byte(raw), auth); err != nil {
+		return nil, fmt.Errorf("Parse STS Token of role %s error: %s", role, err.Error())
+	}
+	if auth.Code != "" && auth.Code != "Success" {
+		return nil, fmt.Errorf("Get STS Token of role %s error: %s", role, auth.Code)
+	}
+	return auth, nil
+}
@@ -0,0 +1,117 @@
+package metadata
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeServer serve the resources, the first failures of every request return 500
+func fakeServer(resources map[string]string, failures int32, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		value, ok := resources[strings.TrimPrefix(r.URL.Path, META_DATA_PATH)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(value + "\n"))
+	}))
+}
+
+func TestHTTP(t *testing.T) {
+	requests := int32(0)
+	server := fakeServer(map[string]string{REGIONID_TAG: "cn-hangzhou"}, 2, &requests)
+	defer server.Close()
+	ctx := context.Background()
+
+	if value, err := NewHTTP(server.URL, time.Second, 2).Get(ctx, REGIONID_TAG); err != nil || value != "cn-hangzhou" {
+		t.Errorf("expect region after retries, got %s, %v", value, err)
+	}
+	if _, err := NewHTTP(server.URL, time.Second, 2).Get(ctx, INSTANCEID_TAG); !IsNotFound(err) {
+		t.Errorf("expect not found, got %v", err)
+	}
+	if requests != 4 {
+		t.Errorf("not found should not be retried, got %d requests", requests)
+	}
+
+	// the server is down
+	server.Close()
+	if _, err := NewHTTP(server.URL, 100*time.Millisecond, 1).Get(ctx, REGIONID_TAG); err == nil || !strings.Contains(err.Error(), "after 1 retries") {
+		t.Errorf("expect failure after retries, got %v", err)
+	}
+}
+
+func TestCachedAndFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requests := int32(0)
+	server := fakeServer(map[string]string{REGIONID_TAG: "cn-hangzhou", INSTANCEID_TAG: "i-1", RAM_ROLE_TAG: "role"}, 0, &requests)
+	defer server.Close()
+	ctx := context.Background()
+
+	cached := NewCached(NewHTTP(server.URL, time.Second, 0), filepath.Join(dir, "cache"), time.Hour)
+	for i := 0; i < 2; i++ {
+		if value, err := cached.Get(ctx, REGIONID_TAG); err != nil || value != "cn-hangzhou" {
+			t.Fatalf("get cached region: %s, %v", value, err)
+		}
+		cached.Get(ctx, RAM_ROLE_TAG)
+	}
+	if requests != 3 {
+		t.Errorf("expect region cached and role not, got %d requests", requests)
+	}
+
+	// the expired value is used if the server is down
+	expired := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "cache", REGIONID_TAG), expired, expired)
+	server.Close()
+	if value, err := cached.Get(ctx, REGIONID_TAG); err != nil || value != "cn-hangzhou" {
+		t.Errorf("expect expired cache, got %s, %v", value, err)
+	}
+
+	// the static file is used before the server
+	file := filepath.Join(dir, "metadata.yaml")
+	if err := ioutil.WriteFile(file, []byte("region-id: cn-beijing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	chain := NewChain(NewFile(file), cached)
+	if value, err := chain.Get(ctx, REGIONID_TAG); err != nil || value != "cn-beijing" {
+		t.Errorf("expect region of file, got %s, %v", value, err)
+	}
+	if value, err := chain.Get(ctx, INSTANCEID_TAG); err == nil || IsNotFound(err) {
+		t.Errorf("expect server error of resource not in file, got %s, %v", value, err)
+	}
+}
+
+func TestRoleToken(t *testing.T) {
+	requests := int32(0)
+	server := fakeServer(map[string]string{
+		RAM_ROLE_TAG:          "role",
+		RAM_ROLE_TAG + "role": `{"AccessKeyId":"id","AccessKeySecret":"secret","SecurityToken":"token","Expiration":"2020-01-01T00:00:00Z","Code":"Success"}`,
+	}, 0, &requests)
+	defer server.Close()
+	Set(NewHTTP(server.URL, time.Second, 0))
+	defer Set(nil)
+
+	auth, err := RoleToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessKeyId != "id" || auth.SecurityToken != "token" || auth.Expiration.Year() != 2020 {
+		t.Errorf("unexpected token: %+v", auth)
+	}
+}
@@ -0,0 +1,192 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// httpProvider get the metadata from the server, the failures of network and server are retried
+type httpProvider struct {
+	url     string
+	client  *http.Client
+	retries int
+}
+
+// NewHTTP create the provider of the metadata server, eg: http://100.100.100.200
+func NewHTTP(url string, timeout time.Duration, retries int) Provider {
+	return &httpProvider{
+		url:     strings.TrimSuffix(url, "/"),
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+	}
+}
+
+func (p *httpProvider) Get(ctx context.Context, resource string) (string, error) {
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			log.Debugf("Metadata, retry %s after error: %s", resource, err.Error())
+			select {
+			case <-ctx.Done():
+				return "", fmt.Errorf("get metadata %s interrupted: %s, last error: %s", resource, ctx.Err(), err.Error())
+			case <-time.After(time.Duration(attempt) * RETRY_INTERVAL):
+			}
+		}
+
+		var value string
+		var retry bool
+		if value, retry, err = p.get(ctx, resource); err == nil || !retry {
+			return value, err
+		}
+	}
+	return "", fmt.Errorf("get metadata %s failed after %d retries: %s", resource, p.retries, err.Error())
+}
+
+// get the resource once, return whether the error is retryable
+func (p *httpProvider) get(ctx context.Context, resource string) (string, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url+META_DATA_PATH+resource, nil)
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", true, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return strings.TrimSpace(string(body)), false, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return "", false, &NotFoundError{Resource: resource}
+	case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests:
+		return "", true, fmt.Errorf("get metadata %s: status %d", resource, resp.StatusCode)
+	}
+	return "", false, fmt.Errorf("get metadata %s: status %d", resource, resp.StatusCode)
+}
+
+// fileProvider read the metadata from the static yaml file of resources:
+//
+//	region-id: cn-hangzhou
+//	instance-id: i-xxx
+type fileProvider struct {
+	file string
+}
+
+// NewFile create the provider of the static file, it is read in every Get as the file is small
+func NewFile(file string) Provider {
+	return &fileProvider{file: file}
+}
+
+func (p *fileProvider) Get(ctx context.Context, resource string) (string, error) {
+	raw, err := ioutil.ReadFile(p.file)
+	if os.IsNotExist(err) {
+		return "", &NotFoundError{Resource: resource}
+	}
+	if err != nil {
+		return "", err
+	}
+	values := map[string]string{}
+	if err := yaml.Unmarshal(raw, &values); err != nil {
+		return "", fmt.Errorf("parse metadata file %s error: %s", p.file, err.Error())
+	}
+	value, ok := values[resource]
+	if !ok {
+		return "", &NotFoundError{Resource: resource}
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// chainProvider try the providers in order, until the resource is found
+type chainProvider struct {
+	providers []Provider
+}
+
+// NewChain create the provider of providers in order
+func NewChain(providers ...Provider) Provider {
+	return &chainProvider{providers: providers}
+}
+
+func (p *chainProvider) Get(ctx context.Context, resource string) (string, error) {
+	err := error(&NotFoundError{Resource: resource})
+	for _, provider := range p.providers {
+		var value string
+		if value, err = provider.Get(ctx, resource); !IsNotFound(err) {
+			return value, err
+		}
+	}
+	return "", err
+}
+
+// cachedProvider cache the values in files of node, as every plugin call is a new process.
+// The sts tokens are not cached, they are expired in the period.
+type cachedProvider struct {
+	provider Provider
+	dir      string
+	ttl      time.Duration
+}
+
+// NewCached cache the values of provider under dir, ttl 0 disable the cache
+func NewCached(provider Provider, dir string, ttl time.Duration) Provider {
+	return &cachedProvider{provider: provider, dir: dir, ttl: ttl}
+}
+
+func (p *cachedProvider) Get(ctx context.Context, resource string) (string, error) {
+	if p.ttl == 0 || strings.HasPrefix(resource, RAM_ROLE_TAG) {
+		return p.provider.Get(ctx, resource)
+	}
+
+	file := filepath.Join(p.dir, strings.Replace(resource, "/", "_", -1))
+	if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) < p.ttl {
+		if raw, err := ioutil.ReadFile(file); err == nil && len(raw) != 0 {
+			return string(raw), nil
+		}
+	}
+
+	value, err := p.provider.Get(ctx, resource)
+	if err != nil {
+		// the expired value is better than failure, the metadata of node is rarely changed
+		if raw, readErr := ioutil.ReadFile(file); readErr == nil && len(raw) != 0 && !IsNotFound(err) {
+			log.Warnf("Metadata, use the expired cache of %s: %s", resource, err.Error())
+			return string(raw), nil
+		}
+		return "", err
+	}
+	if err := writeCache(p.dir, file, value); err != nil {
+		log.Warnf("Metadata, cache %s error: %s", resource, err.Error())
+	}
+	return value, nil
+}
+
+// writeCache replace the cache file by rename, the readers never see a partial value
+func writeCache(dir, file, value string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
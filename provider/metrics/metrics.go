@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// METRICS_FILE is the prometheus textfile of the node, collected by node-exporter textfile collector
+const METRICS_FILE = "/var/log/alicloud/metrics/flexvolume.prom"
+
+// CALLS_TOTAL count the plugin calls by driver, verb, status and error code
+const CALLS_TOTAL = "flexvolume_calls_total"
+
+// help text of the counters written to the file
+var metricHelps = map[string]string{
+	CALLS_TOTAL: "Plugin calls by driver, verb, status and error code.",
+}
+
+// Label is a prometheus label of the counter
+type Label struct {
+	Name  string
+	Value string
+}
+
+// RecordCall count the plugin call in the metrics file
+func RecordCall(file, driver, verb, status, code string) error {
+	return Inc(file, CALLS_TOTAL, Label{"driver", driver}, Label{"verb", verb}, Label{"status", status}, Label{"code", code})
+}
+
+// Inc add 1 to the counter in the metrics file. Plugin processes update the same file,
+// so the counters are read and written with the lock file held, and the file is replaced atomically.
+func Inc(file, name string, labels ...Label) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(file+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	counters, err := Load(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if counters[name] == nil {
+		counters[name] = map[string]float64{}
+	}
+	counters[name][formatLabels(labels)]++
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".flexvolume-metrics")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp, counters); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// node-exporter read the file as other user
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), file)
+}
+
+// Load read the counters from metrics file: metric name -> formatted labels -> value.
+// Comments and broken lines are skipped.
+func Load(file string) (map[string]map[string]float64, error) {
+	counters := map[string]map[string]float64{}
+	f, err := os.Open(file)
+	if err != nil {
+		return counters, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		index := strings.LastIndex(line, " ")
+		if index < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[index+1:], 64)
+		if err != nil {
+			continue
+		}
+		name, labels := line[:index], ""
+		if start := strings.Index(name, "{"); start >= 0 && strings.HasSuffix(name, "}") {
+			name, labels = name[:start], name[start+1:len(name)-1]
+		}
+		if counters[name] == nil {
+			counters[name] = map[string]float64{}
+		}
+		counters[name][labels] = value
+	}
+	return counters, scanner.Err()
+}
+
+// write the counters in prometheus text format, sorted for stable output
+func write(f *os.File, counters map[string]map[string]float64) error {
+	writer := bufio.NewWriter(f)
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if help, ok := metricHelps[name]; ok {
+			fmt.Fprintf(writer, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(writer, "# TYPE %s counter\n", name)
+		series := make([]string, 0, len(counters[name]))
+		for labels := range counters[name] {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			value := strconv.FormatFloat(counters[name][labels], 'f', -1, 64)
+			if labels == "" {
+				fmt.Fprintf(writer, "%s %s\n", name, value)
+			} else {
+				fmt.Fprintf(writer, "%s{%s} %s\n", name, labels, value)
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+// labelEscaper escape the label value as prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []Label) string {
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		parts = append(parts, label.Name+`="`+labelEscaper.Replace(label.Value)+`"`)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordCall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "prom", "flexvolume.prom")
+
+	calls := [][]string{
+		{"disk", "attach", "Success", ""},
+		{"disk", "attach", "Success", ""},
+		{"disk", "attach", "Failure", "EcsThrottled"},
+		{"nas", "mount", "Failure", `Bad"Code`},
+	}
+	for _, call := range calls {
+		if err := RecordCall(file, call[0], call[1], call[2], call[3]); err != nil {
+			t.Fatalf("record call error: %v", err)
+		}
+	}
+
+	counters, err := Load(file)
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	expects := map[string]float64{
+		`driver="disk",verb="attach",status="Success",code=""`:             2,
+		`driver="disk",verb="attach",status="Failure",code="EcsThrottled"`: 1,
+		`driver="nas",verb="mount",status="Failure",code="Bad\"Code"`:      1,
+	}
+	if len(counters[CALLS_TOTAL]) != len(expects) {
+		t.Fatalf("expect %d series, got: %v", len(expects), counters)
+	}
+	for labels, expect := range expects {
+		if value := counters[CALLS_TOTAL][labels]; value != expect {
+			t.Errorf("%s: expect %v, got %v", labels, expect, value)
+		}
+	}
+
+	raw, _ := ioutil.ReadFile(file)
+	if !strings.Contains(string(raw), "# TYPE "+CALLS_TOTAL+" counter\n") {
+		t.Errorf("type line not found: %s", raw)
+	}
+}
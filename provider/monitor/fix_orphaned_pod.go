@@ -1,34 +1,25 @@
 package monitor
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/mountinfo"
 	"github.com/AliyunContainerService/flexvolume/provider/utils"
 	log "github.com/sirupsen/logrus"
 )
 
 // issue: https://github.com/kubernetes/kubernetes/issues/60987
-// global_config: true, host_config: true    --- running fix_issue_orphan_pod()
-// global_config: true, host_config: false   --- not running fix_issue_orphan_pod()
-// global_config: true, host_config: no_set  --- running fix_issue_orphan_pod()
-// global_config: false, host_config: true   --- running fix_issue_orphan_pod()
-// global_config: false, host_config: false  --- not running fix_issue_orphan_pod()
-// global_config: false, host_config: no_set --- not running fix_issue_orphan_pod()
+// running if fix_orphaned_pod is true in host flexvolume.conf, or FIX_ISSUES env contains it when not set in file.
 func fixIssueOrphanPod() {
-	SLEEP_SECOND := DEFAULT_SLEEP_SECOND
-
 	for {
-		if hostFixOrphanedPod == "false" {
-			time.Sleep(time.Duration(SLEEP_SECOND) * time.Second)
-			continue
-		}
-		if hostFixOrphanedPod == "no_set" && globalFixOrphanedPod == false {
-			time.Sleep(time.Duration(SLEEP_SECOND) * time.Second)
+		interval := time.Duration(config.Get().MonitorInterval)
+		if !config.Get().FixOrphanedPod {
+			time.Sleep(interval)
 			continue
 		}
 
@@ -92,15 +83,15 @@ func fixIssueOrphanPod() {
 						}
 					}
 				}
-				SLEEP_SECOND = DEFAULT_SLEEP_SECOND / 30
+				interval = time.Duration(config.Get().MonitorInterval) / 30
 			}
 		}
 
 		// if not orphan log in message, loop slower.
 		if flagOrphanExist == false {
-			SLEEP_SECOND = DEFAULT_SLEEP_SECOND
+			interval = time.Duration(config.Get().MonitorInterval)
 		}
-		time.Sleep(time.Duration(SLEEP_SECOND) * time.Second)
+		time.Sleep(interval)
 	}
 }
 
@@ -154,18 +145,17 @@ func ReadFileLines(fname string) []string {
 
 // IsHostMounted check directory mounted
 func IsHostMounted(mountPath string) bool {
-	cmd := fmt.Sprintf("%s mount | grep \"%s type\" | grep -v grep", NSENTER_CMD, mountPath)
-	out, err := utils.Run(cmd)
-	if err != nil || out == "" {
+	mounted, err := mountinfo.IsMountPoint(mountinfo.HOST_MOUNTINFO, mountPath)
+	if err != nil {
+		log.Errorf("Check host mount point %s error: %s", mountPath, err.Error())
 		return false
 	}
-	return true
+	return mounted
 }
 
 // HostUmount check directory in host mounted
 func HostUmount(mountPath string) bool {
-	cmd := fmt.Sprintf("%s umount %s", NSENTER_CMD, mountPath)
-	_, err := utils.Run(cmd)
+	_, err := runOnHost("umount", mountPath)
 	if err != nil {
 		return false
 	}
@@ -174,8 +164,7 @@ func HostUmount(mountPath string) bool {
 
 // IsHostEmpty check host mounted
 func IsHostEmpty(mountPath string) bool {
-	cmd := fmt.Sprintf("%s ls %s", NSENTER_CMD, mountPath)
-	out, err := utils.Run(cmd)
+	out, err := runOnHost("ls", mountPath)
 	if err != nil {
 		return false
 	}
@@ -187,6 +176,5 @@ func IsHostEmpty(mountPath string) bool {
 
 // RemoveHostPath remove host path
 func RemoveHostPath(mountPath string) {
-	cmd := fmt.Sprintf("%s mv %s /tmp/", NSENTER_CMD, mountPath)
-	utils.Run(cmd)
+	runOnHost("mv", mountPath, "/tmp/")
 }
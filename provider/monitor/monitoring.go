@@ -1,120 +1,134 @@
 package monitor
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/daemon"
+	"github.com/AliyunContainerService/flexvolume/provider/journal"
+	"github.com/AliyunContainerService/flexvolume/provider/metadata"
+	"github.com/AliyunContainerService/flexvolume/provider/transport"
 	"github.com/AliyunContainerService/flexvolume/provider/utils"
 	log "github.com/sirupsen/logrus"
 )
 
 // const values for monitoring
 const (
-	NSENTER_CMD = "/acs/nsenter --mount=/proc/1/ns/mnt "
+	NSENTER_BIN = "/acs/nsenter"
+	NSENTER_MNT = "--mount=/proc/1/ns/mnt"
 	DISK_BIN    = "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~disk/disk"
 	OSS_BIN     = "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss"
 	NAS_BIN     = "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~nas/nas"
 
-	FLEXVOLUME_CONFIG_FILE = "/host/etc/kubernetes/flexvolume.conf"
-	HOST_SYS_LOG           = "/host/var/log/messages"
-	DEFAULT_SLEEP_SECOND   = 60
+	HOST_SYS_LOG = "/host/var/log/messages"
 )
 
-// configs for orphan pod issue
-var globalFixOrphanedPod = false
-var hostFixOrphanedPod = "no_set"
+// the plugin binaries on host checked by monitor
+var pluginBins = map[string]string{
+	"disk": DISK_BIN,
+	"nas":  NAS_BIN,
+	"oss":  OSS_BIN,
+}
 
-// Monitoring running for plugin status check
-func Monitoring() {
-	// Parse fix_orphaned pod global env
-	processFixIssues := strings.ToLower(os.Getenv("FIX_ISSUES"))
-
-	// check global config for issues;
-	fixIssuesList := strings.Split(processFixIssues, ",")
-	for _, fixIssue := range fixIssuesList {
-		fixIssue = strings.ToLower(fixIssue)
-		if fixIssue == "fix_orphaned_pod" {
-			globalFixOrphanedPod = true
-		}
-	}
+// executor run commands in the host mount namespace
+var executor = utils.NewExecutor()
 
-	// parse host flexvolume config
-	go parseFlexvolumeHostConfig()
+// runOnHost run the command in host mount namespace with nsenter
+func runOnHost(name string, args ...string) (string, error) {
+	return utils.Run(context.Background(), executor, NSENTER_BIN, append([]string{NSENTER_MNT, name}, args...)...)
+}
+
+// Monitoring running for plugin status check, the config of host is reloaded when changed
+func Monitoring() {
+	setTransport(config.Get())
+	go config.Watch(config.RELOAD_PERIOD, reloadConfig)
 
 	// fix orphan pod with umounted path; github issue: https://github.com/kubernetes/kubernetes/issues/60987
 	go fixIssueOrphanPod()
 
+	// serve the cloud api for plugins on this node
+	go serveDaemon()
+
 	// monitoring in loop
 	for {
-		version := utils.PluginVersion()
-		// check Disk plugin status
-		if os.Getenv("ACS_DISK") == "true" {
-			mntCmd := fmt.Sprintf("%s %s --version", NSENTER_CMD, DISK_BIN)
-			if out, err := utils.Run(mntCmd); err != nil {
-				log.Printf("Warning, Monitoring disk error: %s", err.Error())
-			} else if out != version {
-				log.Printf("Warning, the disk plugin version is not right, running: %s, expect: %s", out, version)
+		// check plugin versions
+		cfg := config.Get()
+		for _, driver := range config.MONITOR_DRIVERS {
+			if cfg.Monitored(driver) {
+				checkPluginVersion(driver, pluginBins[driver])
 			}
 		}
 
-		if os.Getenv("ACS_NAS") == "true" {
-			mntCmd := fmt.Sprintf("%s %s --version", NSENTER_CMD, NAS_BIN)
-			if out, err := utils.Run(mntCmd); err != nil {
-				log.Printf("Warning, Monitoring nas error: %s", err.Error())
-			} else if out != version {
-				log.Printf("Warning, the nas plugin version is not right, running: %s, expect: %s", out, version)
-			}
-		}
+		// resume or roll back the operations abandoned by killed plugins
+		journal.Recover(context.Background(), daemon.HOST_ROOT)
 
-		if os.Getenv("ACS_OSS") == "true" {
-			mntCmd := fmt.Sprintf("%s %s --version", NSENTER_CMD, OSS_BIN)
-			if out, err := utils.Run(mntCmd); err != nil {
-				log.Printf("Warning, Monitoring oss error: %s", err.Error())
-			} else if out != version {
-				log.Printf("Warning, the Oss plugin version is not right, running: %s, expect: %s", out, version)
-			}
-		}
+		// renew the assumed tokens of the volumes before they expire
+		credentials.Refresh(context.Background(), daemon.HOST_ROOT)
+
+		time.Sleep(time.Duration(cfg.MonitorInterval))
+	}
+}
 
-		time.Sleep(DEFAULT_SLEEP_SECOND * time.Second)
+// reloadConfig apply the log level, transport and metadata provider of the reloaded config, the other keys are read in every loop
+func reloadConfig(cfg *config.Config) {
+	metadata.Set(nil)
+	setTransport(cfg)
+	if level, err := log.ParseLevel(cfg.LogLevel); err == nil && cfg.LogLevel != "" {
+		log.SetLevel(level)
 	}
 }
 
-// parse flexvolume global config
-func parseFlexvolumeHostConfig() {
+// setTransport use the ca bundle of host, the cloud api of daemon and the metadata use the transport
+func setTransport(cfg *config.Config) {
+	if err := transport.Setup(cfg, daemon.HOST_ROOT); err != nil {
+		log.Warnf("Transport of config error: %s, use the default", err.Error())
+	}
+}
+
+// serveDaemon keep the node daemon serving, plugins run in process while it is restarting
+func serveDaemon() {
 	for {
-		if utils.IsFileExisting(FLEXVOLUME_CONFIG_FILE) {
-			raw, err := ioutil.ReadFile(FLEXVOLUME_CONFIG_FILE)
-			if err != nil {
-				log.Errorf("Read flexvolume config file error: %s", err.Error())
-				continue
-			}
-			lines := strings.Split(string(raw), "\n")
-			setFlag := false
-			for _, line := range lines {
-				lowLine := strings.ToLower(line)
-
-				// Parse fix_orphaned_pod config
-				if strings.Contains(lowLine, "fix_orphaned_pod:") && strings.Contains(lowLine, "true") {
-					hostFixOrphanedPod = "true"
-					setFlag = true
-					break
-				} else if strings.Contains(lowLine, "fix_orphaned_pod:") && strings.Contains(lowLine, "false") {
-					hostFixOrphanedPod = "false"
-					setFlag = true
-					break
-				}
-			}
-			if !setFlag {
-				hostFixOrphanedPod = "no_set"
-			}
-		} else {
-			hostFixOrphanedPod = "no_set"
+		if err := daemon.Serve(daemon.SOCKET_FILE); err != nil {
+			log.Errorf("Daemon exit with error: %s, restart in %s", err.Error(), time.Duration(config.Get().MonitorInterval))
 		}
+		time.Sleep(time.Duration(config.Get().MonitorInterval))
+	}
+}
 
-		SLEEP_SECOND := DEFAULT_SLEEP_SECOND
-		time.Sleep(time.Duration(SLEEP_SECOND) * time.Second)
+// checkPluginVersion warn if the installed plugin is not the same build as the monitor
+func checkPluginVersion(driver, bin string) {
+	out, err := runOnHost(bin, "version", "--json")
+	if err != nil {
+		log.Printf("Warning, Monitoring %s error: %s", driver, err.Error())
+		return
+	}
+	if msg := compareBuild(utils.BuildInfo(), out); msg != "" {
+		log.Printf("Warning, the %s plugin %s", driver, msg)
+	}
+}
+
+// compareBuild compare the build info with the output of "version --json",
+// plugins before it only print the version string. Return empty if same build.
+func compareBuild(expect utils.VersionInfo, out string) string {
+	running := utils.VersionInfo{}
+	if err := json.Unmarshal([]byte(out), &running); err != nil {
+		running.Version = strings.TrimSpace(out)
+		if running.Version != expect.Version {
+			return fmt.Sprintf("version is not right, running: %s, expect: %s", running.Version, expect.Version)
+		}
+		return fmt.Sprintf("build is unknown, running: %s without build info, expect commit: %s", running.Version, expect.GitCommit)
+	}
+	if running.Version != expect.Version {
+		return fmt.Sprintf("version is not right, running: %s, expect: %s", running.Version, expect.Version)
+	}
+	if !running.SameBuild(expect) {
+		return fmt.Sprintf("build is mismatched with the same version %s, running commit: %s built at %s, expect commit: %s built at %s",
+			expect.Version, running.GitCommit, running.BuildDate, expect.GitCommit, expect.BuildDate)
 	}
+	return ""
 }
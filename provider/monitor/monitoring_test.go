@@ -0,0 +1,26 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+func TestCompareBuild(t *testing.T) {
+	expect := utils.VersionInfo{Version: "v1.12.6", GitCommit: "abc1234", BuildDate: "2018-08-01T00:00:00Z"}
+	cases := map[string]string{
+		`{"version":"v1.12.6","gitCommit":"abc1234","buildDate":"2018-08-01T00:00:00Z"}`: "",
+		`{"version":"v1.12.6","gitCommit":"def5678","buildDate":"2018-08-02T00:00:00Z"}`: "build is mismatched",
+		`{"version":"v1.12.6","gitCommit":"abc1234","buildDate":"2018-08-03T00:00:00Z"}`: "",
+		`{"version":"v1.12.5","gitCommit":"abc1234","buildDate":"2018-08-01T00:00:00Z"}`: "version is not right",
+		"v1.12.5":   "version is not right",
+		"v1.12.6\n": "build is unknown",
+	}
+	for out, msg := range cases {
+		got := compareBuild(expect, out)
+		if (msg == "" && got != "") || !strings.HasPrefix(got, msg) {
+			t.Errorf("%s: expect %q, got %q", out, msg, got)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package mountinfo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// const values for mountinfo
+const (
+	SELF_MOUNTINFO = "/proc/self/mountinfo"
+	HOST_MOUNTINFO = "/proc/1/mountinfo"
+)
+
+// Mount is one line of mountinfo, see proc(5)
+type Mount struct {
+	ID             int
+	Parent         int
+	Major          int
+	Minor          int
+	Root           string
+	MountPoint     string
+	Options        string
+	OptionalFields []string
+	FSType         string
+	Source         string
+	SuperOptions   string
+}
+
+// ReadOnly check the mount or its filesystem is read only
+func (m Mount) ReadOnly() bool {
+	for _, option := range strings.Split(m.Options+","+m.SuperOptions, ",") {
+		if option == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+// Table is all the mounts of a mount namespace, in mount order
+type Table []Mount
+
+// Load parse the mountinfo file, eg: /proc/self/mountinfo
+func Load(file string) (Table, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parse the mountinfo content
+func Parse(r io.Reader) (Table, error) {
+	table := Table{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		mount, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		table = append(table, mount)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseLine(line string) (Mount, error) {
+	mount := Mount{}
+	fields := strings.Fields(line)
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || len(fields) < sep+3 {
+		return mount, fmt.Errorf("Invalid mountinfo line: %s", line)
+	}
+
+	var err error
+	if mount.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return mount, fmt.Errorf("Invalid mount id in line: %s", line)
+	}
+	if mount.Parent, err = strconv.Atoi(fields[1]); err != nil {
+		return mount, fmt.Errorf("Invalid parent id in line: %s", line)
+	}
+	devs := strings.Split(fields[2], ":")
+	if len(devs) != 2 {
+		return mount, fmt.Errorf("Invalid major:minor in line: %s", line)
+	}
+	if mount.Major, err = strconv.Atoi(devs[0]); err != nil {
+		return mount, fmt.Errorf("Invalid major in line: %s", line)
+	}
+	if mount.Minor, err = strconv.Atoi(devs[1]); err != nil {
+		return mount, fmt.Errorf("Invalid minor in line: %s", line)
+	}
+	mount.Root = unescape(fields[3])
+	mount.MountPoint = unescape(fields[4])
+	mount.Options = fields[5]
+	mount.OptionalFields = fields[6:sep]
+	mount.FSType = unescape(fields[sep+1])
+	mount.Source = unescape(fields[sep+2])
+	if len(fields) > sep+3 {
+		mount.SuperOptions = fields[sep+3]
+	}
+	return mount, nil
+}
+
+// the kernel escapes space, tab, newline and backslash as \ooo
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			buf = append(buf, (s[i+1]-'0')<<6|(s[i+2]-'0')<<3|(s[i+3]-'0'))
+			i += 3
+			continue
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+// ByMountPoint return the top most mount on the path
+func (t Table) ByMountPoint(path string) (Mount, bool) {
+	path = filepath.Clean(path)
+	for i := len(t) - 1; i >= 0; i-- {
+		if t[i].MountPoint == path {
+			return t[i], true
+		}
+	}
+	return Mount{}, false
+}
+
+// BySource return the mounts with the source, eg: /dev/vdb, server:/path
+func (t Table) BySource(source string) Table {
+	result := Table{}
+	for _, mount := range t {
+		if mount.Source == source {
+			result = append(result, mount)
+		}
+	}
+	return result
+}
+
+// ByFSType return the mounts with any of the filesystem types
+func (t Table) ByFSType(fsTypes ...string) Table {
+	result := Table{}
+	for _, mount := range t {
+		for _, fsType := range fsTypes {
+			if mount.FSType == fsType {
+				result = append(result, mount)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Under return the mounts in the directory, not include the directory itself
+func (t Table) Under(dir string) Table {
+	prefix := strings.TrimSuffix(filepath.Clean(dir), "/") + "/"
+	result := Table{}
+	for _, mount := range t {
+		if strings.HasPrefix(mount.MountPoint, prefix) {
+			result = append(result, mount)
+		}
+	}
+	return result
+}
+
+// IsMountPoint check the path is a mount point in the mountinfo file
+func IsMountPoint(file, path string) (bool, error) {
+	table, err := Load(file)
+	if err != nil {
+		return false, err
+	}
+	_, ok := table.ByMountPoint(path)
+	return ok, nil
+}
@@ -0,0 +1,46 @@
+package mountinfo
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMountInfo = `22 1 253:1 / / rw,relatime shared:1 - ext4 /dev/vda1 rw,data=ordered
+30 22 253:16 / /var/lib/kubelet/plugins/kubernetes.io/flexvolume/alicloud/disk/mounts/d-1 rw,relatime shared:10 - ext4 /dev/vdb rw,data=ordered
+31 22 253:16 / /var/lib/kubelet/pods/uid-1/volumes/alicloud~disk/d-1 rw,relatime shared:10 - ext4 /dev/vdb rw,data=ordered
+40 22 0:45 / /var/lib/kubelet/pods/uid-1/volumes/alicloud~oss/oss10 rw,nosuid,nodev,relatime shared:20 - fuse.ossfs ossfs rw,user_id=0,group_id=0,allow_other
+41 22 0:46 / /mnt/nas\040data rw,relatime shared:21 - nfs4 nas.aliyuncs.com:/share rw,vers=4.0
+`
+
+func TestParse(t *testing.T) {
+	table, err := Parse(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(table) != 5 {
+		t.Fatalf("expect 5 mounts, got %d", len(table))
+	}
+
+	if _, ok := table.ByMountPoint("/var/lib/kubelet/pods/uid-1/volumes/alicloud~oss/oss1"); ok {
+		t.Errorf("oss1 should not match oss10")
+	}
+	mount, ok := table.ByMountPoint("/mnt/nas data/")
+	if !ok || mount.Source != "nas.aliyuncs.com:/share" || mount.FSType != "nfs4" {
+		t.Errorf("unexpected nas mount: %#v", mount)
+	}
+	if mounts := table.BySource("/dev/vdb"); len(mounts) != 2 {
+		t.Errorf("expect 2 mounts of /dev/vdb, got %d", len(mounts))
+	}
+	if mounts := table.ByFSType("nfs", "nfs4"); len(mounts) != 1 {
+		t.Errorf("expect 1 nfs mount, got %d", len(mounts))
+	}
+	if mounts := table.Under("/var/lib/kubelet/pods/uid-1"); len(mounts) != 2 {
+		t.Errorf("expect 2 mounts under pod, got %d", len(mounts))
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(strings.NewReader("22 1 253:1 / / rw\n")); err == nil {
+		t.Errorf("expect error for line without separator")
+	}
+}
@@ -1,22 +1,29 @@
 package nas
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/locking"
+	"github.com/AliyunContainerService/flexvolume/provider/mountinfo"
+	"github.com/AliyunContainerService/flexvolume/provider/registry"
 	"github.com/AliyunContainerService/flexvolume/provider/utils"
 	"github.com/denverdino/aliyungo/nas"
 	log "github.com/sirupsen/logrus"
 )
 
-// NasOptions nas options
+// NasOptions nas options, the mount target is authorized by vpc and permission group,
+// no access key is used by mount, so the roleArn of other account is not supported
 type NasOptions struct {
 	Server     string `json:"server"`
 	Path       string `json:"path"`
@@ -24,6 +31,8 @@ type NasOptions struct {
 	Mode       string `json:"mode"`
 	Opts       string `json:"options"`
 	VolumeName string `json:"kubernetes.io/pvOrVolumeName"`
+	ReadWrite  string `json:"kubernetes.io/readwrite"`
+	readOnly   bool
 }
 
 // const values
@@ -31,11 +40,65 @@ const (
 	NASPORTNUM     = "2049"
 	NASTEMPMNTPath = "/mnt/acs_mnt/k8s_nas/" // used for create sub directory;
 	MODECHAR       = "01234567"
+	CHMOD_TIMEOUT  = time.Hour
+	SUNRPC_CONFIG  = "/etc/modprobe.d/sunrpc.conf"
 )
 
+// sunRpcFile is the sunrpc module config, dialNas check the connection to nas server, changed by tests
+var (
+	sunRpcFile = SUNRPC_CONFIG
+	dialNas    = func(ctx context.Context, address string) (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: time.Second * time.Duration(3)}
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+)
+
+// Capabilities nas is not attachable, and relabel/chown on nfs is expensive
+var Capabilities = utils.Capabilities{
+	Attach:          false,
+	SELinuxRelabel:  false,
+	SupportsMetrics: true,
+	FSGroup:         false,
+}
+
+// register nas driver
+func init() {
+	registry.Register(registry.Driver{
+		Name:         "nas",
+		Capabilities: Capabilities,
+		Options: []registry.Option{
+			{Name: "server", Required: true, Description: "nas mount target domain"},
+			{Name: "path", Default: "/", Description: "sub directory in nas, created if not exist"},
+			{Name: "vers", Default: "3", Description: "nfs version, 3, 4.0 or 4.1"},
+			{Name: "mode", Description: "chmod mode of the mounted directory"},
+			{Name: "options", Description: "extra nfs mount options"},
+			{Name: "kubernetes.io/readwrite", Default: utils.READ_WRITE, Description: "access mode passed by kubelet, ro mounts the nfs read only"},
+		},
+		New: func() registry.Plugin { return &NasPlugin{} },
+	})
+}
+
 // NasPlugin nas plugin
 type NasPlugin struct {
-	client *nas.Client
+	client    *nas.Client
+	exec      utils.Executor
+	mountInfo string
+}
+
+// executor run the commands of plugin, default run on host
+func (p *NasPlugin) executor() utils.Executor {
+	if p.exec == nil {
+		p.exec = utils.NewExecutor()
+	}
+	return p.exec
+}
+
+// mountinfo file of plugin, default is the mount namespace of plugin
+func (p *NasPlugin) mountInfoFile() string {
+	if p.mountInfo == "" {
+		return mountinfo.SELF_MOUNTINFO
+	}
+	return p.mountInfo
 }
 
 // NewOptions new options.
@@ -44,21 +107,23 @@ func (p *NasPlugin) NewOptions() interface{} {
 }
 
 // Init plugin init
-func (p *NasPlugin) Init() utils.Result {
-	return utils.Succeed()
+func (p *NasPlugin) Init(ctx context.Context) utils.Result {
+	return utils.SucceedWithCapabilities(Capabilities)
 }
 
 // Mount nas support mount and umount
-func (p *NasPlugin) Mount(opts interface{}, mountPath string) utils.Result {
+func (p *NasPlugin) Mount(ctx context.Context, opts interface{}, mountPath string) utils.Result {
 
 	log.Infof("Nas Plugin Mount: %s", strings.Join(os.Args, ","))
 
 	opt := opts.(*NasOptions)
-	if err := p.checkOptions(opt); err != nil {
-		utils.FinishError("Nas, check option error: " + err.Error())
+	if err := p.checkOptions(ctx, opt); err != nil {
+		return utils.FailWithError(err, utils.CODE_INVALID_OPTIONS, "Nas, check option error: "+err.Error())
 	}
 
-	if utils.IsMounted(mountPath) {
+	if mounted, err := utils.CheckMountMode(p.mountInfoFile(), mountPath, opt.readOnly); err != nil {
+		return utils.FailWithError(err, utils.CODE_NAS_MOUNT_FAILED, "Nas, Mount failed: "+err.Error())
+	} else if mounted {
 		log.Infof("Nas, Mount Path Already Mount, options: %s", mountPath)
 		return utils.Result{Status: "Success"}
 	}
@@ -67,114 +132,111 @@ func (p *NasPlugin) Mount(opts interface{}, mountPath string) utils.Result {
 	// updateNasWhiteList(opt)
 
 	// if system not set nas, config it.
-	checkSystemNasConfig()
+	p.checkSystemNasConfig(ctx)
 
 	// Create Mount Path
 	if err := utils.CreateDest(mountPath); err != nil {
-		utils.FinishError("Nas, Mount error with create Path fail: " + mountPath)
+		return utils.FailWithCode(utils.CODE_MOUNT_FAILED, "Nas, Mount error with create Path fail: "+mountPath)
 	}
 
 	// Do mount
-	mntCmd := fmt.Sprintf("mount -t nfs -o vers=%s %s:%s %s", opt.Vers, opt.Server, opt.Path, mountPath)
+	mntOptions := "vers=" + opt.Vers
+	if opt.readOnly {
+		mntOptions = mntOptions + "," + utils.READ_ONLY
+	}
 	if opt.Opts != "" {
-		mntCmd = fmt.Sprintf("mount -t nfs -o vers=%s,%s %s:%s %s", opt.Vers, opt.Opts, opt.Server, opt.Path, mountPath)
+		mntOptions = mntOptions + "," + opt.Opts
 	}
+	mntCmd := utils.NewCommand("mount", "-t", "nfs", "-o", mntOptions, opt.Server+":"+opt.Path, mountPath)
 	log.Infof("Exec Nas Mount Cdm: %s", mntCmd)
-	_, err := utils.Run(mntCmd)
+	_, _, err := p.executor().Execute(ctx, mntCmd)
 
 	// Mount to nfs Sub-directory
 	if err != nil && opt.Path != "/" {
 		if strings.Contains(err.Error(), "reason given by server: No such file or directory") || strings.Contains(err.Error(), "access denied by server while mounting") {
-			p.createNasSubDir(opt)
-			if _, err := utils.Run(mntCmd); err != nil {
-				utils.FinishError("Nas, Mount Nfs sub directory fail: " + err.Error())
+			if err := p.createNasSubDir(ctx, opt); err != nil {
+				return utils.FailWithCode(utils.CODE_NAS_MOUNT_FAILED, "Nas, Create sub directory fail: "+err.Error())
+			}
+			if _, _, err := p.executor().Execute(ctx, mntCmd); err != nil {
+				return utils.FailWithCode(utils.CODE_NAS_MOUNT_FAILED, "Nas, Mount Nfs sub directory fail: "+err.Error())
 			}
 		} else {
-			utils.FinishError("Nas, Mount Nfs fail with error: " + err.Error())
+			return utils.FailWithCode(utils.CODE_NAS_MOUNT_FAILED, "Nas, Mount Nfs fail with error: "+err.Error())
 		}
 		// mount error
 	} else if err != nil {
-		utils.FinishError("Nas, Mount nfs fail: " + err.Error())
+		return utils.FailWithCode(utils.CODE_NAS_MOUNT_FAILED, "Nas, Mount nfs fail: "+err.Error())
 	}
 
-	// change the mode
-	if opt.Mode != "" && opt.Path != "/" {
-		var wg1 sync.WaitGroup
-		wg1.Add(1)
-
-		go func(*sync.WaitGroup) {
-			cmd := fmt.Sprintf("chmod -R %s %s", opt.Mode, mountPath)
-			if _, err := utils.Run(cmd); err != nil {
-				log.Errorf("Nas chmod cmd fail: %s %s", cmd, err)
-			} else {
-				log.Infof("Nas chmod cmd success: %s", cmd)
-			}
-			wg1.Done()
-		}(&wg1)
-
-		if waitTimeout(&wg1, 1) {
-			log.Infof("Chmod use more than 1s, running in Concurrency: %s", mountPath)
-		}
+	// change the mode, the read only volume is not changed
+	if opt.Mode != "" && opt.Path != "/" && opt.readOnly {
+		log.Warnf("Nas, Skip chmod %s of read only volume: %s", opt.Mode, mountPath)
+	} else if opt.Mode != "" && opt.Path != "/" {
+		p.changeMode(opt.Mode, mountPath)
 	}
 
 	// check mount
-	if !utils.IsMounted(mountPath) {
-		utils.FinishError("Check mount fail after mount:" + mountPath)
+	if !utils.IsMounted(p.mountInfoFile(), mountPath) {
+		return utils.FailWithCode(utils.CODE_NAS_MOUNT_FAILED, "Check mount fail after mount:"+mountPath)
 	}
 	log.Info("Mount success on: " + mountPath)
 	return utils.Result{Status: "Success"}
 }
 
 // check system config,
-// if tcp_slot_table_entries not set to 128, just config.
-func checkSystemNasConfig() {
-	updateNasConfig := false
-	sunRpcFile := "/etc/modprobe.d/sunrpc.conf"
-	if !utils.IsFileExisting(sunRpcFile) {
-		updateNasConfig = true
-	} else {
-		chkCmd := fmt.Sprintf("cat %s | grep tcp_slot_table_entries | grep 128 | grep -v grep | wc -l", sunRpcFile)
-		out, err := utils.Run(chkCmd)
+// if tcp_slot_table_entries not set to the nas_slot_table_entries of flexvolume.conf, just config.
+func (p *NasPlugin) checkSystemNasConfig(ctx context.Context) {
+	entries := strconv.Itoa(config.Get().NasSlotTableEntries)
+	if utils.IsFileExisting(sunRpcFile) {
+		raw, err := ioutil.ReadFile(sunRpcFile)
 		if err != nil {
-			log.Warnf("Update Nas system config check error: ", err.Error())
+			log.Warnf("Update Nas system config check error: %s", err.Error())
 			return
 		}
-		if strings.TrimSpace(out) == "0" {
-			updateNasConfig = true
+		for _, line := range strings.Split(string(raw), "\n") {
+			if strings.HasSuffix(strings.TrimSpace(line), " tcp_slot_table_entries="+entries) {
+				return
+			}
 		}
 	}
 
-	if updateNasConfig {
-		upCmd := fmt.Sprintf("echo \"options sunrpc tcp_slot_table_entries=128\" >> %s && echo \"options sunrpc tcp_max_slot_table_entries=128\" >> %s && sysctl -w sunrpc.tcp_slot_table_entries=128", sunRpcFile, sunRpcFile)
-		_, err := utils.Run(upCmd)
-		if err != nil {
-			log.Warnf("Update Nas system config error: ", err.Error())
-			return
-		}
-		log.Warnf("Successful update Nas system config")
+	f, err := os.OpenFile(sunRpcFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Warnf("Update Nas system config error: %s", err.Error())
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString("options sunrpc tcp_slot_table_entries=" + entries + "\noptions sunrpc tcp_max_slot_table_entries=" + entries + "\n"); err != nil {
+		log.Warnf("Update Nas system config error: %s", err.Error())
+		return
+	}
+	if _, err := utils.Run(ctx, p.executor(), "sysctl", "-w", "sunrpc.tcp_slot_table_entries="+entries); err != nil {
+		log.Warnf("Update Nas system config error: %s", err.Error())
+		return
 	}
+	log.Warnf("Successful update Nas system config")
 }
 
 // Unmount umount mnt
-func (p *NasPlugin) Unmount(mountPoint string) utils.Result {
+func (p *NasPlugin) Unmount(ctx context.Context, mountPoint string) utils.Result {
 	log.Infof("Nas Plugin Umount: %s", strings.Join(os.Args, ","))
 
-	if !utils.IsMounted(mountPoint) {
+	if !utils.IsMounted(p.mountInfoFile(), mountPoint) {
 		return utils.Succeed()
 	}
 
 	// do umount command
-	umntCmd := fmt.Sprintf("umount %s", mountPoint)
-	if _, err := utils.Run(umntCmd); err != nil {
+	umntCmd := utils.NewCommand("umount", mountPoint)
+	if _, _, err := p.executor().Execute(ctx, umntCmd); err != nil {
 		if strings.Contains(err.Error(), "device is busy") {
-			utils.FinishError("Nas, Umount nfs Fail with device busy: " + err.Error())
+			return utils.FailWithCode(utils.CODE_UNMOUNT_FAILED, "Nas, Umount nfs Fail with device busy: "+err.Error())
 		}
 
 		// check if need force umount
 		networkUnReachable := false
 		noOtherPodUsed := false
 		nfsServer := p.getNasServerInfo(mountPoint)
-		if nfsServer != "" && !p.isNasServerReachable(nfsServer) {
+		if nfsServer != "" && !p.isNasServerReachable(ctx, nfsServer) {
 			log.Warnf("NFS, Connect to server: %s failed, umount to %s", nfsServer, mountPoint)
 			networkUnReachable = true
 		}
@@ -184,10 +246,10 @@ func (p *NasPlugin) Unmount(mountPoint string) utils.Result {
 		}
 		// force umount need both network unreachable and no other user
 		if networkUnReachable && noOtherPodUsed {
-			umntCmd = fmt.Sprintf("umount -f %s", mountPoint)
+			umntCmd = utils.NewCommand("umount", "-f", mountPoint)
 		}
-		if _, err := utils.Run(umntCmd); err != nil {
-			utils.FinishError("Nas, Umount nfs Fail: " + err.Error())
+		if _, _, err := p.executor().Execute(ctx, umntCmd); err != nil {
+			return utils.FailWithCode(utils.CODE_UNMOUNT_FAILED, "Nas, Umount nfs Fail: "+err.Error())
 		}
 	}
 
@@ -196,9 +258,12 @@ func (p *NasPlugin) Unmount(mountPoint string) utils.Result {
 }
 
 func (p *NasPlugin) getNasServerInfo(mountPoint string) string {
-	getNasServerPath := fmt.Sprintf("findmnt %s | grep %s | grep -v grep | awk '{print $2}'", mountPoint, mountPoint)
-	serverAndPath, _ := utils.Run(getNasServerPath)
-	serverAndPath = strings.TrimSpace(serverAndPath)
+	serverAndPath := ""
+	if table, err := mountinfo.Load(p.mountInfoFile()); err == nil {
+		if mount, ok := table.ByMountPoint(mountPoint); ok {
+			serverAndPath = mount.Source
+		}
+	}
 
 	serverInfoPartList := strings.Split(serverAndPath, ":")
 	if len(serverInfoPartList) != 2 {
@@ -209,17 +274,21 @@ func (p *NasPlugin) getNasServerInfo(mountPoint string) string {
 }
 
 func (p *NasPlugin) noOtherNasUser(nfsServer, mountPoint string) bool {
-	checkCmd := fmt.Sprintf("mount | grep -v %s | grep %s | grep -v grep | wc -l", mountPoint, nfsServer)
-	if checkOut, err := utils.Run(checkCmd); err != nil {
-		return false
-	} else if strings.TrimSpace(checkOut) != "0" {
+	table, err := mountinfo.Load(p.mountInfoFile())
+	if err != nil {
 		return false
 	}
+	for _, mount := range table.ByFSType("nfs", "nfs4") {
+		if mount.MountPoint != filepath.Clean(mountPoint) && strings.HasPrefix(mount.Source, nfsServer+":") {
+			return false
+		}
+	}
 	return true
 }
 
-func (p *NasPlugin) isNasServerReachable(url string) bool {
-	conn, err := net.DialTimeout("tcp", url+":"+NASPORTNUM, time.Second*2)
+func (p *NasPlugin) isNasServerReachable(ctx context.Context, url string) bool {
+	dialer := &net.Dialer{Timeout: time.Second * 2}
+	conn, err := dialer.DialContext(ctx, "tcp", url+":"+NASPORTNUM)
 	if err != nil {
 		return false
 	}
@@ -228,17 +297,22 @@ func (p *NasPlugin) isNasServerReachable(url string) bool {
 }
 
 // Attach not support
-func (p *NasPlugin) Attach(opts interface{}, nodeName string) utils.Result {
+func (p *NasPlugin) Attach(ctx context.Context, opts interface{}, nodeName string) utils.Result {
+	return utils.NotSupport()
+}
+
+// Isattached not support
+func (p *NasPlugin) Isattached(ctx context.Context, opts interface{}, nodeName string) utils.Result {
 	return utils.NotSupport()
 }
 
 // Detach not support
-func (p *NasPlugin) Detach(device string, nodeName string) utils.Result {
+func (p *NasPlugin) Detach(ctx context.Context, device string, nodeName string) utils.Result {
 	return utils.NotSupport()
 }
 
 // Getvolumename Support
-func (p *NasPlugin) Getvolumename(opts interface{}) utils.Result {
+func (p *NasPlugin) Getvolumename(ctx context.Context, opts interface{}) utils.Result {
 	opt := opts.(*NasOptions)
 	return utils.Result{
 		Status:     "Success",
@@ -247,70 +321,92 @@ func (p *NasPlugin) Getvolumename(opts interface{}) utils.Result {
 }
 
 // Waitforattach no Support
-func (p *NasPlugin) Waitforattach(devicePath string, opts interface{}) utils.Result {
+func (p *NasPlugin) Waitforattach(ctx context.Context, devicePath string, opts interface{}) utils.Result {
 	return utils.NotSupport()
 }
 
 // Mountdevice Not Support
-func (p *NasPlugin) Mountdevice(mountPath string, opts interface{}) utils.Result {
+func (p *NasPlugin) Mountdevice(ctx context.Context, mountPath, devicePath string, opts interface{}) utils.Result {
+	return utils.NotSupport()
+}
+
+// Unmountdevice Not Support
+func (p *NasPlugin) Unmountdevice(ctx context.Context, mountPath string) utils.Result {
 	return utils.NotSupport()
 }
 
+// ExpandVolume nas capacity is not limited by volume, nothing to do
+func (p *NasPlugin) ExpandVolume(ctx context.Context, opts interface{}, devicePath, newSize, oldSize string) utils.Result {
+	return utils.Succeed()
+}
+
+// ExpandFS nas capacity is not limited by volume, nothing to do
+func (p *NasPlugin) ExpandFS(ctx context.Context, opts interface{}, devicePath, deviceMountPath, newSize, oldSize string) utils.Result {
+	return utils.Succeed()
+}
+
 // 1. mount to /mnt/acs_mnt/k8s_nas/volumename first
 // 2. run mkdir for sub directory
 // 3. umount the tmep directory
-func (p *NasPlugin) createNasSubDir(opt *NasOptions) {
+func (p *NasPlugin) createNasSubDir(ctx context.Context, opt *NasOptions) error {
+	// the temp path is shared by the pods of volume, wait for the other creating
+	lock, err := locking.Node(ctx, "nas-subdir-"+opt.VolumeName)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	// step 1: create mount path
 	nasTmpPath := filepath.Join(NASTEMPMNTPath, opt.VolumeName)
 	if err := utils.CreateDest(nasTmpPath); err != nil {
-		utils.FinishError("Create Nas temp Directory err: " + err.Error())
+		return errors.New("Create Nas temp Directory err: " + err.Error())
 	}
-	if utils.IsMounted(nasTmpPath) {
-		utils.Umount(nasTmpPath)
+	if utils.IsMounted(p.mountInfoFile(), nasTmpPath) {
+		utils.Umount(ctx, p.executor(), nasTmpPath)
 	}
 
 	// step 2: do mount
 	usePath := opt.Path
-	mntCmd := fmt.Sprintf("mount -t nfs -o vers=%s %s:%s %s", opt.Vers, opt.Server, "/", nasTmpPath)
-	_, err := utils.Run(mntCmd)
+	_, err = utils.Run(ctx, p.executor(), "mount", "-t", "nfs", "-o", "vers="+opt.Vers, opt.Server+":/", nasTmpPath)
 	if err != nil {
 		if strings.Contains(err.Error(), "reason given by server: No such file or directory") || strings.Contains(err.Error(), "access denied by server while mounting") {
 			if strings.HasPrefix(opt.Path, "/share/") {
 				usePath = usePath[6:]
-				mntCmd = fmt.Sprintf("mount -t nfs -o vers=%s %s:%s %s", opt.Vers, opt.Server, "/share", nasTmpPath)
-				_, err := utils.Run(mntCmd)
+				_, err := utils.Run(ctx, p.executor(), "mount", "-t", "nfs", "-o", "vers="+opt.Vers, opt.Server+":/share", nasTmpPath)
 				if err != nil {
-					utils.FinishError("Nas, Mount to temp directory(with /share) fail: " + err.Error())
+					return errors.New("Nas, Mount to temp directory(with /share) fail: " + err.Error())
 				}
 			} else {
-				utils.FinishError("Nas, maybe use fast nas, but path not startwith /share: " + err.Error())
+				return errors.New("Nas, maybe use fast nas, but path not startwith /share: " + err.Error())
 			}
 		} else {
-			utils.FinishError("Nas, Mount to temp directory fail: " + err.Error())
+			return errors.New("Nas, Mount to temp directory fail: " + err.Error())
 		}
 	}
-	subPath := path.Join(nasTmpPath, usePath)
 
+	// step 3: umount after create, even if mkdir failed
+	defer utils.Umount(ctx, p.executor(), nasTmpPath)
+
+	subPath := path.Join(nasTmpPath, usePath)
 	if err := utils.CreateDest(subPath); err != nil {
-		utils.FinishError("Nas, Create Sub Directory err: " + err.Error())
+		return errors.New("Nas, Create Sub Directory err: " + err.Error())
 	}
 
-	// step 3: umount after create
-	utils.Umount(nasTmpPath)
 	log.Info("Create Sub Directory success: ", opt.Path)
+	return nil
 }
 
 //
-func (p *NasPlugin) checkOptions(opt *NasOptions) error {
+func (p *NasPlugin) checkOptions(ctx context.Context, opt *NasOptions) error {
 	// NFS Server url
 	if opt.Server == "" {
 		return errors.New("NAS url is empty")
 	}
 	// check network connection
-	conn, err := net.DialTimeout("tcp", opt.Server+":"+NASPORTNUM, time.Second*time.Duration(3))
+	conn, err := dialNas(ctx, opt.Server+":"+NASPORTNUM)
 	if err != nil {
 		log.Errorf("NAS: Cannot connect to nas host: %s", opt.Server)
-		return errors.New("NAS: Cannot connect to nas host: " + opt.Server)
+		return utils.NewCodeError(utils.CODE_NAS_UNREACHABLE, "NAS: Cannot connect to nas host: "+opt.Server)
 	}
 	defer conn.Close()
 
@@ -361,9 +457,36 @@ func (p *NasPlugin) checkOptions(opt *NasOptions) error {
 		opt.Opts = ""
 	}
 
+	// access mode
+	readOnly, err := utils.IsReadOnly(opt.ReadWrite)
+	if err != nil {
+		return errors.New("NAS: " + err.Error())
+	}
+	opt.readOnly = readOnly
 	return nil
 }
 
+// changeMode run chmod -R in the background, it is not bound to the call deadline,
+// and keeps running after the call returns on the large directory.
+func (p *NasPlugin) changeMode(mode, mountPath string) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cmd := utils.NewCommand("chmod", "-R", mode, mountPath)
+		cmd.Timeout = CHMOD_TIMEOUT
+		if _, _, err := p.executor().Execute(context.Background(), cmd); err != nil {
+			log.Errorf("Nas chmod cmd fail: %s %s", cmd, err)
+		} else {
+			log.Infof("Nas chmod cmd success: %s", cmd)
+		}
+	}()
+
+	if waitTimeout(&wg, 1) {
+		log.Infof("Chmod use more than 1s, running in Concurrency: %s", mountPath)
+	}
+}
+
 func waitTimeout(wg *sync.WaitGroup, timeout int) bool {
 	c := make(chan struct{})
 	go func() {
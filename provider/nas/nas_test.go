@@ -1,9 +1,105 @@
 package nas
 
-import "testing"
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
 
 func TestCheckOptions(t *testing.T) {
 	plugin := &NasPlugin{}
 	optin := &NasOptions{Server: "", Path: "/k8s", Vers: "4.0", Mode: "755"}
-	plugin.checkOptions(optin)
+	plugin.checkOptions(context.Background(), optin)
+}
+
+// ctxExecutor block the command until released, and record its context
+type ctxExecutor struct {
+	release chan struct{}
+	done    chan error
+}
+
+func (e *ctxExecutor) Execute(ctx context.Context, cmd utils.Command) (string, string, error) {
+	<-e.release
+	e.done <- ctx.Err()
+	return "", "", nil
+}
+
+func TestChangeModeNotCancelled(t *testing.T) {
+	executor := &ctxExecutor{release: make(chan struct{}), done: make(chan error, 1)}
+	plugin := &NasPlugin{exec: executor}
+
+	// the call returns before chmod finished, and the call context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	plugin.changeMode("755", "/mnt/nas")
+	cancel()
+	if ctx.Err() == nil {
+		t.Fatal("expect call context cancelled")
+	}
+	close(executor.release)
+	if err := <-executor.done; err != nil {
+		t.Errorf("chmod should not be cancelled with the call: %v", err)
+	}
+}
+
+// fakeMount record the mount target in the mountinfo file like the kernel
+func fakeMount(mountInfo string) func(cmd utils.Command) (string, string, error) {
+	return func(cmd utils.Command) (string, string, error) {
+		if cmd.Name == "mount" {
+			target := strings.Replace(cmd.Args[len(cmd.Args)-1], " ", "\\040", -1)
+			raw, _ := ioutil.ReadFile(mountInfo)
+			ioutil.WriteFile(mountInfo, append(raw, "40 22 0:45 / "+target+" rw shared:20 - nfs server:/ rw\n"...), 0644)
+		}
+		return "", "", nil
+	}
+}
+
+func TestMountArgs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "nas")
+	defer os.RemoveAll(dir)
+	sunRpcFile = filepath.Join(dir, "sunrpc.conf")
+	dial := dialNas
+	dialNas = func(ctx context.Context, address string) (net.Conn, error) {
+		if address != "nas.example.com:2049" {
+			t.Errorf("dial address: %s", address)
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	defer func() {
+		sunRpcFile, dialNas = SUNRPC_CONFIG, dial
+	}()
+	mountInfo := filepath.Join(dir, "mountinfo")
+	ioutil.WriteFile(mountInfo, nil, 0644)
+
+	// the hostile values reach mount as single arguments, no shell is involved
+	executor := &utils.FakeExecutor{Handler: fakeMount(mountInfo)}
+	plugin := &NasPlugin{exec: executor, mountInfo: mountInfo}
+	mountPath := filepath.Join(dir, "pods", "vol $(id)")
+	opt := &NasOptions{Server: "nas.example.com", Path: "/a; rm -rf /", Opts: "noresvport,$(id)", ReadWrite: utils.READ_ONLY}
+	if result := plugin.Mount(context.Background(), opt, mountPath); result.Status != "Success" {
+		t.Fatalf("mount failed: %s", result.Message)
+	}
+
+	expect := map[string][]string{
+		"sysctl": {"-w", "sunrpc.tcp_slot_table_entries=128"},
+		"mount":  {"-t", "nfs", "-o", "vers=3,ro,noresvport,$(id)", "nas.example.com:/a; rm -rf /", mountPath},
+	}
+	if len(executor.Commands) != len(expect) {
+		t.Fatalf("commands: %q", executor.CommandLines())
+	}
+	for _, cmd := range executor.Commands {
+		if strings.Join(cmd.Args, "\x00") != strings.Join(expect[cmd.Name], "\x00") {
+			t.Errorf("%s args: %q, expect: %q", cmd.Name, cmd.Args, expect[cmd.Name])
+		}
+	}
+	if raw, _ := ioutil.ReadFile(sunRpcFile); !strings.Contains(string(raw), "tcp_slot_table_entries=128") {
+		t.Errorf("sunrpc config: %s", raw)
+	}
 }
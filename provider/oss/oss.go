@@ -1,14 +1,18 @@
 package oss
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/endpoint"
+	"github.com/AliyunContainerService/flexvolume/provider/mountinfo"
+	"github.com/AliyunContainerService/flexvolume/provider/registry"
 	"github.com/AliyunContainerService/flexvolume/provider/utils"
 	"github.com/denverdino/aliyungo/ecs"
 	log "github.com/sirupsen/logrus"
@@ -24,6 +28,22 @@ type OssOptions struct {
 	VolumeName  string `json:"kubernetes.io/pvOrVolumeName"`
 	SecretAkId  string `json:"kubernetes.io/secret/akId"`
 	SecretAkSec string `json:"kubernetes.io/secret/akSecret"`
+	ReadWrite   string `json:"kubernetes.io/readwrite"`
+	// RoleArn and RoleSessionName is the ram role of the account owning the bucket
+	RoleArn         string `json:"roleArn"`
+	RoleSessionName string `json:"roleSessionName"`
+	readOnly        bool
+	// record and token are the role assumed for ossfs
+	record *roleRecord
+	token  *credentials.Credential
+}
+
+// role return the ram role to assume, nil for the bucket of the access key
+func (opt *OssOptions) role() *credentials.Role {
+	if opt.RoleArn == "" {
+		return nil
+	}
+	return &credentials.Role{Arn: opt.RoleArn, SessionName: opt.RoleSessionName}
 }
 
 // const values
@@ -31,9 +51,57 @@ const (
 	CredentialFile = "/etc/passwd-ossfs"
 )
 
+// credentialFile is the passwd file of ossfs, changed by tests
+var credentialFile = CredentialFile
+
+// Capabilities ossfs is not attachable, and not support relabel/chown
+var Capabilities = utils.Capabilities{
+	Attach:          false,
+	SELinuxRelabel:  false,
+	SupportsMetrics: true,
+	FSGroup:         false,
+}
+
+// register oss driver
+func init() {
+	registry.Register(registry.Driver{
+		Name:         "oss",
+		Capabilities: Capabilities,
+		Options: []registry.Option{
+			{Name: "bucket", Required: true, Description: "oss bucket name"},
+			{Name: "url", Description: "oss endpoint, default the internal endpoint of node region"},
+			{Name: "otherOpts", Description: "extra ossfs options"},
+			{Name: "akId", Description: "access key id, default use the node access key"},
+			{Name: "akSecret", Description: "access key secret, default use the node access key"},
+			{Name: "roleArn", Description: "ram role of the bucket owner, assumed by the access key or node credential, the token is passed to ossfs by -o passwd_file"},
+			{Name: "roleSessionName", Default: credentials.DEFAULT_SESSION_NAME, Description: "session name of the assumed role"},
+			{Name: "kubernetes.io/readwrite", Default: utils.READ_WRITE, Description: "access mode passed by kubelet, ro mounts the bucket by ossfs -o ro"},
+		},
+		New: func() registry.Plugin { return &OssPlugin{} },
+	})
+}
+
 // OssPlugin oss plugin
 type OssPlugin struct {
-	client *ecs.Client
+	client    *ecs.Client
+	exec      utils.Executor
+	mountInfo string
+}
+
+// executor run the commands of plugin, default run on host
+func (p *OssPlugin) executor() utils.Executor {
+	if p.exec == nil {
+		p.exec = utils.NewExecutor()
+	}
+	return p.exec
+}
+
+// mountinfo file of plugin, default is the mount namespace of plugin
+func (p *OssPlugin) mountInfoFile() string {
+	if p.mountInfo == "" {
+		return mountinfo.SELF_MOUNTINFO
+	}
+	return p.mountInfo
 }
 
 // NewOptions plugin new options
@@ -42,8 +110,8 @@ func (p *OssPlugin) NewOptions() interface{} {
 }
 
 // Init oss plugin init
-func (p *OssPlugin) Init() utils.Result {
-	return utils.Succeed()
+func (p *OssPlugin) Init(ctx context.Context) utils.Result {
+	return utils.SucceedWithCapabilities(Capabilities)
 }
 
 // Mount Paras format:
@@ -64,7 +132,7 @@ func (p *OssPlugin) Init() utils.Result {
 //   "otherOpts":"-o max_stat_cache_size=0 -o allow_other",
 //   "url":"oss-cn-hangzhou.aliyuncs.com"
 // }
-func (p *OssPlugin) Mount(opts interface{}, mountPath string) utils.Result {
+func (p *OssPlugin) Mount(ctx context.Context, opts interface{}, mountPath string) utils.Result {
 
 	// logout oss paras
 	opt := opts.(*OssOptions)
@@ -77,33 +145,45 @@ func (p *OssPlugin) Mount(opts interface{}, mountPath string) utils.Result {
 	argStr = argStr + "VolumeName: " + opt.VolumeName + ", AkId: " + opt.AkId + ", Bucket: " + opt.Bucket + ", url: " + opt.Url + ", OtherOpts: " + opt.OtherOpts
 	log.Infof("Oss Plugin Mount: %s", argStr)
 
-	if err := p.checkOptions(opt); err != nil {
-		utils.FinishError("OSS: check option error: " + err.Error())
+	if err := p.checkOptions(ctx, opt); err != nil {
+		return utils.FailWithError(err, utils.CODE_INVALID_OPTIONS, "OSS: check option error: "+err.Error())
 	}
 
-	if utils.IsMounted(mountPath) {
+	if mounted, err := utils.CheckMountMode(p.mountInfoFile(), mountPath, opt.readOnly); err != nil {
+		return utils.FailWithError(err, utils.CODE_OSS_MOUNT_FAILED, "Oss, Mount failed: "+err.Error())
+	} else if mounted {
 		return utils.Result{Status: "Success"}
 	}
 
 	// Create Mount Path
 	if err := utils.CreateDest(mountPath); err != nil {
-		utils.FinishError("Oss, Mount fail with create Path error: " + err.Error() + mountPath)
-	}
-
-	// Save ak file for ossfs
-	if err := p.saveCredential(opt); err != nil {
-		utils.FinishError("Oss, Save AK file fail: " + err.Error())
+		return utils.FailWithCode(utils.CODE_MOUNT_FAILED, "Oss, Mount fail with create Path error: "+err.Error()+mountPath)
 	}
 
 	// default use allow_other
-	mntCmd := fmt.Sprintf("systemd-run --scope -- ossfs %s %s -ourl=%s -o allow_other %s", opt.Bucket, mountPath, opt.Url, opt.OtherOpts)
-	systemdCmd := fmt.Sprintf("which systemd-run")
-	if _, err := utils.Run(systemdCmd); err != nil {
-		mntCmd = fmt.Sprintf("ossfs %s %s -ourl=%s -o allow_other %s", opt.Bucket, mountPath, opt.Url, opt.OtherOpts)
+	mntArgs := []string{opt.Bucket, mountPath, "-ourl=" + opt.Url, "-o", "allow_other"}
+
+	// Save ak file for ossfs, the token of role is saved in its own dir and refreshed by monitor
+	if opt.token != nil {
+		dir, err := saveToken("", opt.record, opt.token)
+		if err != nil {
+			return utils.FailWithCode(utils.CODE_OSS_CREDENTIAL_MISSING, "Oss, Save sts token fail: "+err.Error())
+		}
+		mntArgs = append(mntArgs, "-o", PASSWD_FILE_OPTION+dir)
+	} else if err := p.saveCredential(opt); err != nil {
+		return utils.FailWithCode(utils.CODE_OSS_CREDENTIAL_MISSING, "Oss, Save AK file fail: "+err.Error())
+	}
+	mntArgs = append(mntArgs, strings.Fields(opt.OtherOpts)...)
+	if opt.readOnly {
+		mntArgs = append(mntArgs, "-o", utils.READ_ONLY)
+	}
+	mntCmd := utils.NewCommand("systemd-run", append([]string{"--scope", "--", "ossfs"}, mntArgs...)...)
+	if _, err := utils.Run(ctx, p.executor(), "which", "systemd-run"); err != nil {
+		mntCmd = utils.NewCommand("ossfs", mntArgs...)
 		log.Infof("Mount oss bucket without systemd-run")
 	}
-	if out, err := utils.Run(mntCmd); err != nil {
-		utils.FinishError("Create OSS volume fail: " + err.Error() + ", out: " + out)
+	if out, _, err := p.executor().Execute(ctx, mntCmd); err != nil {
+		return utils.FailWithCode(utils.CODE_OSS_MOUNT_FAILED, "Create OSS volume fail: "+err.Error()+", out: "+out)
 	}
 
 	log.Info("Mount Oss successful: ", mountPath)
@@ -114,28 +194,26 @@ func (p *OssPlugin) Mount(opts interface{}, mountPath string) utils.Result {
 // /usr/libexec/kubernetes/kubelet-plugins/volume/exec/alicloud~oss/oss
 // unmount
 // /var/lib/kubelet/pods/e000259c-4dac-11e8-a884-00163e0f011e/volumes/alicloud~oss/oss1
-func (p *OssPlugin) Unmount(mountPoint string) utils.Result {
+func (p *OssPlugin) Unmount(ctx context.Context, mountPoint string) utils.Result {
 	log.Infof("Oss Plugin Umount: %s", strings.Join(os.Args, ","))
 
 	// check subpath volume umount if exist.
-	checkSubpathVolumes(mountPoint)
+	p.checkSubpathVolumes(ctx, mountPoint)
 
-	if !utils.IsMounted(mountPoint) {
+	if !utils.IsMounted(p.mountInfoFile(), mountPoint) {
 		return utils.Succeed()
 	}
 
 	// do umount
-	umntCmd := fmt.Sprintf("fusermount -u %s", mountPoint)
-	if _, err := utils.Run(umntCmd); err != nil {
+	if _, err := utils.Run(ctx, p.executor(), "fusermount", "-u", mountPoint); err != nil {
 		if strings.Contains(err.Error(), "Device or resource busy") {
-			lazyUmntCmd := fmt.Sprintf("fusermount -uz %s", mountPoint)
-			if _, err := utils.Run(lazyUmntCmd); err != nil {
-				utils.FinishError("Lazy Umount OSS Fail: " + err.Error())
+			if _, err := utils.Run(ctx, p.executor(), "fusermount", "-uz", mountPoint); err != nil {
+				return utils.FailWithCode(utils.CODE_UNMOUNT_FAILED, "Lazy Umount OSS Fail: "+err.Error())
 			}
 			log.Infof("Lazy umount Oss path successful: %s", mountPoint)
 			return utils.Succeed()
 		}
-		utils.FinishError("Umount OSS Fail: " + err.Error())
+		return utils.FailWithCode(utils.CODE_UNMOUNT_FAILED, "Umount OSS Fail: "+err.Error())
 	}
 
 	log.Info("Umount Oss path successful: ", mountPoint)
@@ -145,7 +223,7 @@ func (p *OssPlugin) Unmount(mountPoint string) utils.Result {
 // check if subPath volume exist, if subpath is mounted, umount it;
 // /var/lib/kubelet/pods/6dd977d1-302a-11e9-b51c-00163e0cd246/volumes/alicloud~oss/oss1
 // /var/lib/kubelet/pods/6dd977d1-302a-11e9-b51c-00163e0cd246/volume-subpaths/oss1/nginx-flexvolume-oss/0
-func checkSubpathVolumes(mountPoint string) {
+func (p *OssPlugin) checkSubpathVolumes(ctx context.Context, mountPoint string) {
 	podId := ""
 	volumeName := filepath.Base(mountPoint)
 	podsSplit := strings.Split(mountPoint, "pods")
@@ -161,17 +239,10 @@ func checkSubpathVolumes(mountPoint string) {
 		if !utils.IsFileExisting(subPathRootDir) {
 			return
 		}
-		checkCmd := fmt.Sprintf("mount | grep %s", subPathRootDir)
-		if out, err := utils.Run(checkCmd); err == nil {
-			subMntList := strings.Split(out, "\n")
-			for _, mntItem := range subMntList {
-				strList := strings.Split(mntItem, " ")
-				if len(strList) > 3 {
-					mntPoint := strList[2]
-					umntCmd := fmt.Sprintf("fusermount -u %s", mntPoint)
-					if _, err := utils.Run(umntCmd); err != nil {
-						log.Info("Umount Oss path failed: with error:", mntPoint, err.Error())
-					}
+		if table, err := mountinfo.Load(p.mountInfoFile()); err == nil {
+			for _, mount := range table.Under(subPathRootDir) {
+				if _, err := utils.Run(ctx, p.executor(), "fusermount", "-u", mount.MountPoint); err != nil {
+					log.Info("Umount Oss path failed: with error:", mount.MountPoint, err.Error())
 				}
 			}
 		}
@@ -179,17 +250,22 @@ func checkSubpathVolumes(mountPoint string) {
 }
 
 // Attach not supported
-func (p *OssPlugin) Attach(opts interface{}, nodeName string) utils.Result {
+func (p *OssPlugin) Attach(ctx context.Context, opts interface{}, nodeName string) utils.Result {
+	return utils.NotSupport()
+}
+
+// Isattached not support
+func (p *OssPlugin) Isattached(ctx context.Context, opts interface{}, nodeName string) utils.Result {
 	return utils.NotSupport()
 }
 
 // Detach not support
-func (p *OssPlugin) Detach(device string, nodeName string) utils.Result {
+func (p *OssPlugin) Detach(ctx context.Context, device string, nodeName string) utils.Result {
 	return utils.NotSupport()
 }
 
 // Getvolumename Support
-func (p *OssPlugin) Getvolumename(opts interface{}) utils.Result {
+func (p *OssPlugin) Getvolumename(ctx context.Context, opts interface{}) utils.Result {
 	opt := opts.(*OssOptions)
 	return utils.Result{
 		Status:     "Success",
@@ -198,21 +274,36 @@ func (p *OssPlugin) Getvolumename(opts interface{}) utils.Result {
 }
 
 // Waitforattach Not Support
-func (p *OssPlugin) Waitforattach(devicePath string, opts interface{}) utils.Result {
+func (p *OssPlugin) Waitforattach(ctx context.Context, devicePath string, opts interface{}) utils.Result {
 	return utils.NotSupport()
 }
 
 // Mountdevice Not Support
-func (p *OssPlugin) Mountdevice(mountPath string, opts interface{}) utils.Result {
+func (p *OssPlugin) Mountdevice(ctx context.Context, mountPath, devicePath string, opts interface{}) utils.Result {
+	return utils.NotSupport()
+}
+
+// Unmountdevice Not Support
+func (p *OssPlugin) Unmountdevice(ctx context.Context, mountPath string) utils.Result {
 	return utils.NotSupport()
 }
 
+// ExpandVolume oss bucket capacity is not limited by volume, nothing to do
+func (p *OssPlugin) ExpandVolume(ctx context.Context, opts interface{}, devicePath, newSize, oldSize string) utils.Result {
+	return utils.Succeed()
+}
+
+// ExpandFS oss bucket capacity is not limited by volume, nothing to do
+func (p *OssPlugin) ExpandFS(ctx context.Context, opts interface{}, devicePath, deviceMountPath, newSize, oldSize string) utils.Result {
+	return utils.Succeed()
+}
+
 // save ak file: bucket:ak_id:ak_secret
 func (p *OssPlugin) saveCredential(options *OssOptions) error {
 
 	oldContentByte := []byte{}
-	if utils.IsFileExisting(CredentialFile) {
-		tmpValue, err := ioutil.ReadFile(CredentialFile)
+	if utils.IsFileExisting(credentialFile) {
+		tmpValue, err := ioutil.ReadFile(credentialFile)
 		if err != nil {
 			return err
 		}
@@ -230,7 +321,7 @@ func (p *OssPlugin) saveCredential(options *OssOptions) error {
 	}
 
 	newContentStr = options.Bucket + ":" + options.AkId + ":" + options.AkSecret + "\n" + newContentStr
-	if err := ioutil.WriteFile(CredentialFile, []byte(newContentStr), 0640); err != nil {
+	if err := ioutil.WriteFile(credentialFile, []byte(newContentStr), 0640); err != nil {
 		log.Errorf("Save Credential File failed, %s, %s", newContentStr, err)
 		return err
 	}
@@ -238,9 +329,9 @@ func (p *OssPlugin) saveCredential(options *OssOptions) error {
 }
 
 // Check oss options
-func (p *OssPlugin) checkOptions(opt *OssOptions) error {
-	if opt.Url == "" || opt.Bucket == "" {
-		return errors.New("Oss: Url or bucket is empty")
+func (p *OssPlugin) checkOptions(ctx context.Context, opt *OssOptions) error {
+	if opt.Bucket == "" {
+		return errors.New("Oss: bucket is empty")
 	}
 
 	if opt.SecretAkId != "" && opt.SecretAkSec != "" {
@@ -255,9 +346,31 @@ func (p *OssPlugin) checkOptions(opt *OssOptions) error {
 		}
 		opt.AkSecret = string(tmpSec)
 	}
-	// if not input ak from user, use the default ak value
-	if opt.AkId == "" || opt.AkSecret == "" {
-		opt.AkId, opt.AkSecret = utils.GetLocalAK()
+	// the role is assumed by the ak of volume or the node credential, the token is saved in the passwd dir of ossfs
+	var err error
+	if role := opt.role(); role != nil {
+		if err := role.Validate(); err != nil {
+			return utils.NewCodeError(utils.CODE_INVALID_OPTIONS, "Oss: "+err.Error())
+		}
+		opt.record = &roleRecord{Bucket: opt.Bucket, Role: *role, AkId: opt.AkId, AkSecret: opt.AkSecret}
+		if opt.token, err = assumeRole(ctx, "", opt.record); err != nil {
+			return utils.NewCodeError(utils.CODE_OSS_CREDENTIAL_MISSING, "Oss: Assume role error: "+err.Error())
+		}
+	} else {
+		// if not input ak from user, use the node credential, ossfs passwd file not support sts token
+		cred, err := credentials.Resolve(ctx, credentials.Options{Driver: "oss", AccessKeyID: opt.AkId, AccessKeySecret: opt.AkSecret, NoToken: true})
+		if err != nil {
+			return utils.NewCodeError(utils.CODE_OSS_CREDENTIAL_MISSING, "Oss: Get default ak error: "+err.Error())
+		}
+		opt.AkId, opt.AkSecret = cred.AccessKeyID, cred.AccessKeySecret
+	}
+
+	// the endpoint of node region, internal in vpc
+	if opt.Url == "" {
+		if opt.Url, err = endpoint.Resolve(ctx, endpoint.SERVICE_OSS, endpoint.Options{}); err != nil {
+			return errors.New("Oss: Url is empty and resolve endpoint error: " + err.Error())
+		}
+		log.Infof("Oss, use the endpoint %s of node region", opt.Url)
 	}
 
 	if opt.OtherOpts != "" {
@@ -265,5 +378,9 @@ func (p *OssPlugin) checkOptions(opt *OssOptions) error {
 			return errors.New("Oss: OtherOpts format error: " + opt.OtherOpts)
 		}
 	}
+
+	if opt.readOnly, err = utils.IsReadOnly(opt.ReadWrite); err != nil {
+		return errors.New("Oss: " + err.Error())
+	}
 	return nil
 }
@@ -1,9 +1,202 @@
 package oss
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
 
 func TestCheckOptions(t *testing.T) {
 	plugin := &OssPlugin{}
 	optin := &OssOptions{Bucket: "aliyun", Url: "oss-cn-hangzhou.aliyuncs.com", OtherOpts: "-o max_stat_cache_size=0 -o allow_other", AkId: "1223455", AkSecret: "22334567"}
-	plugin.checkOptions(optin)
+	plugin.checkOptions(context.Background(), optin)
+}
+
+func TestUnmountArgs(t *testing.T) {
+	mountPoint := "/mnt/oss data;reboot"
+	file, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		t.Fatalf("create mountinfo error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("40 22 0:45 / /mnt/oss\\040data;reboot rw shared:20 - fuse.ossfs ossfs rw\n")
+	file.Close()
+
+	executor := &utils.FakeExecutor{}
+	plugin := &OssPlugin{exec: executor, mountInfo: file.Name()}
+	if result := plugin.Unmount(context.Background(), mountPoint); result.Status != "Success" {
+		t.Fatalf("unmount failed: %s", result.Message)
+	}
+
+	last := executor.Commands[len(executor.Commands)-1]
+	if last.Name != "fusermount" || len(last.Args) != 2 || last.Args[1] != mountPoint {
+		t.Errorf("unexpected umount command: %#v", last)
+	}
+}
+
+// fakeSts assume the role for the access key of bucket owner, the token expires in ttl
+func fakeSts(ttl time.Duration) (*httptest.Server, *int32) {
+	requests := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		query := r.URL.Query()
+		if query.Get("Action") != "AssumeRole" || query.Get("AccessKeyId") != "owner-id" || query.Get("RoleArn") != "acs:ram::1234:role/oss" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"Code":"NoPermission","Message":"unexpected query %s"}`, r.URL.RawQuery)
+			return
+		}
+		fmt.Fprintf(w, `{"RequestId":"req","Credentials":{"AccessKeyId":"STS.id-%d","AccessKeySecret":"sts-secret","SecurityToken":"sts-token-%d","Expiration":"%s"}}`,
+			n, n, time.Now().Add(ttl).UTC().Format(time.RFC3339))
+	}))
+	cfg := config.Default()
+	cfg.Endpoints["sts"] = server.URL
+	config.Set(cfg)
+	return server, &requests
+}
+
+func readTokenFile(t *testing.T, dir, name string) string {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("read token file %s error: %v", name, err)
+	}
+	return string(raw)
+}
+
+func TestMountWithRole(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "oss")
+	defer os.RemoveAll(dir)
+	credentials.SetCacheDir(filepath.Join(dir, "cache"))
+	defer credentials.SetCacheDir(credentials.STS_CACHE_DIR)
+	tokenDir = filepath.Join(dir, "sts")
+	defer func() { tokenDir = STS_TOKEN_DIR }()
+	server, _ := fakeSts(time.Hour)
+	defer server.Close()
+	defer config.Set(nil)
+	mountInfo := filepath.Join(dir, "mountinfo")
+	ioutil.WriteFile(mountInfo, nil, 0644)
+
+	executor := &utils.FakeExecutor{}
+	plugin := &OssPlugin{exec: executor, mountInfo: mountInfo}
+	mountPath := filepath.Join(dir, "mnt")
+	opt := &OssOptions{Bucket: "shared", Url: "oss-cn-hangzhou-internal.aliyuncs.com", OtherOpts: "-o max_stat_cache_size=0",
+		AkId: "owner-id", AkSecret: "owner-secret", RoleArn: "acs:ram::1234:role/oss", ReadWrite: utils.READ_WRITE}
+	if result := plugin.Mount(context.Background(), opt, mountPath); result.Status != "Success" {
+		t.Fatalf("mount failed: %s", result.Message)
+	}
+
+	// the token is written to the passwd dir of ossfs, not the passwd file of access keys
+	tokens, _ := filepath.Glob(filepath.Join(tokenDir, "*"))
+	if len(tokens) != 1 {
+		t.Fatalf("expect one token dir, got %v", tokens)
+	}
+	expect := map[string]string{
+		TOKEN_ACCESS_KEY_ID:     "STS.id-1",
+		TOKEN_ACCESS_KEY_SECRET: "sts-secret",
+		TOKEN_SECURITY_TOKEN:    "sts-token-1",
+	}
+	for name, value := range expect {
+		if got := readTokenFile(t, tokens[0], name); got != value {
+			t.Errorf("token file %s: %q, expect %q", name, got, value)
+		}
+	}
+	if expiration, err := time.Parse(time.RFC3339, readTokenFile(t, tokens[0], TOKEN_EXPIRATION)); err != nil || time.Until(expiration) < 50*time.Minute {
+		t.Errorf("token expiration: %v, %v", expiration, err)
+	}
+	if info, _ := os.Stat(filepath.Join(tokens[0], TOKEN_ACCESS_KEY_SECRET)); info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode: %s", info.Mode())
+	}
+
+	lines := executor.CommandLines()
+	mount := "systemd-run --scope -- ossfs shared " + mountPath + " -ourl=oss-cn-hangzhou-internal.aliyuncs.com -o allow_other -o passwd_file=" + tokens[0] + " -o max_stat_cache_size=0"
+	if lines[len(lines)-1] != mount {
+		t.Errorf("mount command: %s, expect: %s", lines[len(lines)-1], mount)
+	}
+
+	// the role is validated before sts is called
+	opt = &OssOptions{Bucket: "shared", Url: "oss", AkId: "owner-id", AkSecret: "owner-secret", RoleArn: "oss"}
+	if result := plugin.Mount(context.Background(), opt, mountPath); result.Code != utils.CODE_INVALID_OPTIONS {
+		t.Errorf("mount with illegal role: %+v", result)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	root, _ := ioutil.TempDir("", "oss")
+	defer os.RemoveAll(root)
+	credentials.SetCacheDir(filepath.Join(root, "cache"))
+	defer credentials.SetCacheDir(credentials.STS_CACHE_DIR)
+	server, requests := fakeSts(time.Hour)
+	defer server.Close()
+	defer config.Set(nil)
+
+	role := credentials.Role{Arn: "acs:ram::1234:role/oss"}
+	expiring := &roleRecord{Bucket: "expiring", Role: role, AkId: "owner-id", AkSecret: "owner-secret"}
+	valid := &roleRecord{Bucket: "valid", Role: role, AkId: "owner-id", AkSecret: "owner-secret"}
+	unused := &roleRecord{Bucket: "unused", Role: role, AkId: "owner-id", AkSecret: "owner-secret"}
+	saveToken(root, expiring, &credentials.Credential{AccessKeyID: "STS.old", Expiration: time.Now().Add(5 * time.Minute)})
+	saveToken(root, valid, &credentials.Credential{AccessKeyID: "STS.valid", Expiration: time.Now().Add(time.Hour)})
+	saveToken(root, unused, &credentials.Credential{AccessKeyID: "STS.unused", Expiration: time.Now().Add(time.Hour)})
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(root, unused.dir()), old, old)
+
+	// the running ossfs processes use the expiring and valid tokens
+	for pid, record := range map[string]*roleRecord{"100": expiring, "101": valid} {
+		os.MkdirAll(filepath.Join(root, "proc", pid), 0755)
+		cmdline := strings.Join([]string{"ossfs", record.Bucket, "/mnt", "-ourl=oss", "-o", "allow_other", "-o", "passwd_file=" + record.dir()}, "\x00")
+		ioutil.WriteFile(filepath.Join(root, "proc", pid, "cmdline"), []byte(cmdline+"\x00"), 0644)
+	}
+
+	refreshTokens(context.Background(), root)
+	if got := readTokenFile(t, filepath.Join(root, expiring.dir()), TOKEN_ACCESS_KEY_ID); got != "STS.id-1" {
+		t.Errorf("expiring token not refreshed: %s", got)
+	}
+	if got := readTokenFile(t, filepath.Join(root, valid.dir()), TOKEN_ACCESS_KEY_ID); got != "STS.valid" {
+		t.Errorf("valid token refreshed: %s", got)
+	}
+	if utils.IsFileExisting(filepath.Join(root, unused.dir())) {
+		t.Errorf("unused token dir not removed")
+	}
+	if *requests != 1 {
+		t.Errorf("expect one sts request, got %d", *requests)
+	}
+}
+
+func TestMountArgs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "oss")
+	defer os.RemoveAll(dir)
+	credentialFile = filepath.Join(dir, "passwd-ossfs")
+	defer func() { credentialFile = CredentialFile }()
+	mountInfo := filepath.Join(dir, "mountinfo")
+	ioutil.WriteFile(mountInfo, nil, 0644)
+
+	// the hostile values reach ossfs as single arguments, no shell is involved
+	executor := &utils.FakeExecutor{}
+	plugin := &OssPlugin{exec: executor, mountInfo: mountInfo}
+	mountPath := filepath.Join(dir, "pods", "vol $(id)")
+	opt := &OssOptions{Bucket: "bucket;reboot", Url: "oss.example.com$(id)", OtherOpts: "-o max_stat_cache_size=0 -o $(id);reboot",
+		AkId: "id", AkSecret: "secret", ReadWrite: utils.READ_ONLY}
+	if result := plugin.Mount(context.Background(), opt, mountPath); result.Status != "Success" {
+		t.Fatalf("mount failed: %s", result.Message)
+	}
+
+	mount := executor.Commands[len(executor.Commands)-1]
+	expect := []string{"--scope", "--", "ossfs", "bucket;reboot", mountPath, "-ourl=oss.example.com$(id)", "-o", "allow_other",
+		"-o", "max_stat_cache_size=0", "-o", "$(id);reboot", "-o", "ro"}
+	if mount.Name != "systemd-run" || strings.Join(mount.Args, "\x00") != strings.Join(expect, "\x00") {
+		t.Errorf("mount command: %q %q, expect: %q", mount.Name, mount.Args, expect)
+	}
+	if raw, _ := ioutil.ReadFile(credentialFile); string(raw) != "bucket;reboot:id:secret\n" {
+		t.Errorf("passwd file: %q", raw)
+	}
 }
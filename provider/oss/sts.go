@@ -0,0 +1,183 @@
+package oss
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/credentials"
+	"github.com/AliyunContainerService/flexvolume/provider/endpoint"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values of the assumed token for ossfs
+const (
+	// STS_TOKEN_DIR hold a dir for every bucket and role, ossfs is mounted with -o passwd_file=<dir>
+	// and reload the token files in it, so the token is renewed without remount
+	STS_TOKEN_DIR = "/etc/ossfs/sts"
+	ROLE_FILE     = "role.json"
+	// the token files read by ossfs
+	TOKEN_ACCESS_KEY_ID     = "AccessKeyId"
+	TOKEN_ACCESS_KEY_SECRET = "AccessKeySecret"
+	TOKEN_SECURITY_TOKEN    = "SecurityToken"
+	TOKEN_EXPIRATION        = "Expiration"
+	PASSWD_FILE_OPTION      = "passwd_file="
+)
+
+// tokenDir is the dir of token dirs on host, changed by tests
+var tokenDir = STS_TOKEN_DIR
+
+func init() {
+	credentials.RegisterRefresher("oss", refreshTokens)
+}
+
+// roleRecord is the role of token dir, the monitor assume it again with the same access key
+type roleRecord struct {
+	Bucket string `json:"bucket"`
+	credentials.Role
+	// AkId and AkSecret are given by the volume, empty for the node credential
+	AkId     string `json:"akId,omitempty"`
+	AkSecret string `json:"akSecret,omitempty"`
+}
+
+// dir return the token dir on host, shared by the volumes of the same bucket and role
+func (r *roleRecord) dir() string {
+	sum := sha1.Sum([]byte(r.Bucket + "/" + r.Arn + "/" + r.Session() + "/" + r.AkId))
+	return filepath.Join(tokenDir, hex.EncodeToString(sum[:]))
+}
+
+// assumeRole assume the role by the access key of volume or the credential of node under root
+func assumeRole(ctx context.Context, root string, record *roleRecord) (*credentials.Credential, error) {
+	base, err := credentials.Resolve(ctx, credentials.Options{Root: root, Driver: "oss", AccessKeyID: record.AkId, AccessKeySecret: record.AkSecret})
+	if err != nil {
+		return nil, err
+	}
+	stsEndpoint, err := endpoint.Resolve(ctx, endpoint.SERVICE_STS, endpoint.Options{Credential: base})
+	if err != nil {
+		return nil, fmt.Errorf("resolve sts endpoint error: %s", err.Error())
+	}
+	return credentials.AssumeRole(ctx, base, record.Role, endpoint.URL(stsEndpoint))
+}
+
+// saveToken write the role and token files to the token dir under root, return the dir on host
+func saveToken(root string, record *roleRecord, cred *credentials.Credential) (string, error) {
+	dir := record.dir()
+	hostDir := filepath.Join("/", root, dir)
+	if err := os.MkdirAll(hostDir, 0700); err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	files := map[string]string{
+		ROLE_FILE:               string(raw),
+		TOKEN_ACCESS_KEY_ID:     cred.AccessKeyID,
+		TOKEN_ACCESS_KEY_SECRET: cred.AccessKeySecret,
+		TOKEN_SECURITY_TOKEN:    cred.SecurityToken,
+		TOKEN_EXPIRATION:        cred.Expiration.UTC().Format(time.RFC3339),
+	}
+	// every file is replaced by rename, ossfs never read a partial token
+	for name, content := range files {
+		file := filepath.Join(hostDir, name)
+		if err := ioutil.WriteFile(file+".tmp", []byte(content), 0600); err != nil {
+			return "", err
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// readRole return the role and expiration of the token dir
+func readRole(hostDir string) (*roleRecord, time.Time, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(hostDir, ROLE_FILE))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	record := &roleRecord{}
+	if err := json.Unmarshal(raw, record); err != nil {
+		return nil, time.Time{}, err
+	}
+	raw, err = ioutil.ReadFile(filepath.Join(hostDir, TOKEN_EXPIRATION))
+	if err != nil {
+		return record, time.Time{}, nil
+	}
+	expiration, _ := time.Parse(time.RFC3339, strings.TrimSpace(string(raw)))
+	return record, expiration, nil
+}
+
+// refreshTokens renew the tokens used by ossfs before they expire, and remove the token dirs not used by ossfs.
+// The dir is kept for an assumed duration after it is written, so the mounting ossfs is not affected.
+func refreshTokens(ctx context.Context, root string) {
+	dirs, err := ioutil.ReadDir(filepath.Join("/", root, tokenDir))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Oss, List sts token dirs error: %s", err.Error())
+		}
+		return
+	}
+	inUse := passwdDirsInUse(root)
+	for _, info := range dirs {
+		dir := filepath.Join(tokenDir, info.Name())
+		hostDir := filepath.Join("/", root, dir)
+		if !inUse[dir] {
+			if time.Since(info.ModTime()) > credentials.ASSUME_ROLE_DURATION {
+				log.Infof("Oss, Remove the sts token dir not used by ossfs: %s", dir)
+				os.RemoveAll(hostDir)
+			}
+			continue
+		}
+
+		record, expiration, err := readRole(hostDir)
+		if err != nil {
+			log.Warnf("Oss, Read the role of sts token dir %s error: %s", dir, err.Error())
+			continue
+		}
+		if time.Until(expiration) > credentials.STS_REFRESH_BEFORE {
+			continue
+		}
+		cred, err := assumeRole(ctx, root, record)
+		if err != nil {
+			log.Errorf("Oss, Refresh the sts token of bucket %s error: %s", record.Bucket, err.Error())
+			continue
+		}
+		if _, err := saveToken(root, record, cred); err != nil {
+			log.Errorf("Oss, Save the sts token of bucket %s error: %s", record.Bucket, err.Error())
+			continue
+		}
+		log.Infof("Oss, Refresh the sts token of bucket %s with %s, expire at %s", record.Bucket, cred.Source, cred.Expiration.Format(time.RFC3339))
+	}
+}
+
+// passwdDirsInUse return the passwd_file options of the running ossfs processes
+func passwdDirsInUse(root string) map[string]bool {
+	dirs := map[string]bool{}
+	procs, _ := filepath.Glob(filepath.Join("/", root, "proc", "[0-9]*", "cmdline"))
+	for _, proc := range procs {
+		raw, err := ioutil.ReadFile(proc)
+		if err != nil {
+			continue
+		}
+		args := strings.Split(string(raw), "\x00")
+		if len(args) == 0 || filepath.Base(args[0]) != "ossfs" {
+			continue
+		}
+		for _, arg := range args {
+			for _, opt := range strings.Split(strings.TrimPrefix(arg, "-o"), ",") {
+				if strings.HasPrefix(opt, PASSWD_FILE_OPTION) {
+					dirs[filepath.Clean(strings.TrimPrefix(opt, PASSWD_FILE_OPTION))] = true
+				}
+			}
+		}
+	}
+	return dirs
+}
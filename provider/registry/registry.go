@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+// Plugin is the kubelet flexvolume call interface implemented by drivers
+type Plugin interface {
+	NewOptions() interface{} // not called by kubelet
+	Init(ctx context.Context) utils.Result
+	Getvolumename(ctx context.Context, opt interface{}) utils.Result
+	Attach(ctx context.Context, opt interface{}, nodeName string) utils.Result
+	Isattached(ctx context.Context, opt interface{}, nodeName string) utils.Result
+	Waitforattach(ctx context.Context, devicePath string, opt interface{}) utils.Result
+	Mountdevice(ctx context.Context, mountPath string, devicePath string, opt interface{}) utils.Result
+	Unmountdevice(ctx context.Context, mountPath string) utils.Result
+	Detach(ctx context.Context, volumeName string, nodeName string) utils.Result
+	Mount(ctx context.Context, opt interface{}, mountPath string) utils.Result
+	Unmount(ctx context.Context, mountPoint string) utils.Result
+	ExpandVolume(ctx context.Context, opt interface{}, devicePath, newSize, oldSize string) utils.Result
+	ExpandFS(ctx context.Context, opt interface{}, devicePath, deviceMountPath, newSize, oldSize string) utils.Result
+}
+
+// Option describe one of the volume options accepted by driver
+type Option struct {
+	Name        string `json:"name"`
+	Required    bool   `json:"required"`
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description"`
+}
+
+// Driver is the metadata and factory of a volume driver
+type Driver struct {
+	Name         string             `json:"name"`
+	Capabilities utils.Capabilities `json:"capabilities"`
+	Options      []Option           `json:"options"`
+	New          func() Plugin      `json:"-"`
+}
+
+var (
+	mutex   sync.RWMutex
+	drivers = map[string]Driver{}
+)
+
+// Register add the driver, called in init() of the driver package.
+// Panic if the name is empty, duplicated or the factory is nil.
+func Register(driver Driver) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if driver.Name == "" || driver.New == nil {
+		panic("registry: driver name and factory are required")
+	}
+	if _, ok := drivers[driver.Name]; ok {
+		panic(fmt.Sprintf("registry: driver %s registered twice", driver.Name))
+	}
+	drivers[driver.Name] = driver
+}
+
+// Lookup return the driver registered with the name
+func Lookup(name string) (Driver, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	driver, ok := drivers[name]
+	return driver, ok
+}
+
+// NewPlugin create plugin of the driver, nil for unknown driver
+func NewPlugin(name string) Plugin {
+	driver, ok := Lookup(name)
+	if !ok {
+		return nil
+	}
+	return driver.New()
+}
+
+// Drivers return all the registered drivers sorted by name
+func Drivers() []Driver {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	list := make([]Driver, 0, len(drivers))
+	for _, driver := range drivers {
+		list = append(list, driver)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,30 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/AliyunContainerService/flexvolume/provider/utils"
+)
+
+func TestRegister(t *testing.T) {
+	Register(Driver{Name: "fake-b", New: func() Plugin { return nil }})
+	Register(Driver{Name: "fake-a", Capabilities: utils.Capabilities{Attach: true}, New: func() Plugin { return nil }})
+
+	if driver, ok := Lookup("fake-a"); !ok || !driver.Capabilities.Attach {
+		t.Errorf("fake-a should be registered as attachable")
+	}
+	if NewPlugin("unknown") != nil {
+		t.Errorf("unknown driver should return nil plugin")
+	}
+	drivers := Drivers()
+	if len(drivers) != 2 || drivers[0].Name != "fake-a" || drivers[1].Name != "fake-b" {
+		t.Errorf("drivers should be sorted by name: %v", drivers)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("register twice should panic")
+		}
+	}()
+	Register(Driver{Name: "fake-a", New: func() Plugin { return nil }})
+}
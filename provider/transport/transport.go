@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// const values of the transport, the same as http.DefaultTransport
+const (
+	DIAL_TIMEOUT          = 30 * time.Second
+	KEEP_ALIVE            = 30 * time.Second
+	TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+	IDLE_CONN_TIMEOUT     = 90 * time.Second
+	MAX_IDLE_CONNS        = 100
+)
+
+// TLS_VERSIONS are the names of tls_min_version
+var TLS_VERSIONS = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// Setup replace http.DefaultTransport by the transport of config. The aliyungo clients and
+// the metadata provider use the default transport, except the sdk clients with TLSHandshakeTimeout env.
+// The monitor read the ca bundle of host under root.
+func Setup(cfg *config.Config, root string) error {
+	transport, err := New(cfg, root)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = transport
+	return nil
+}
+
+// New build the transport with the ca bundle, proxy, tls min version and request timeout of config
+func New(cfg *config.Config, root string) (*http.Transport, error) {
+	tlsConfig := &tls.Config{}
+	if cfg.TLSMinVersion != "" {
+		version, ok := TLS_VERSIONS[cfg.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("illegal tls_min_version: %s", cfg.TLSMinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if cfg.CABundle != "" {
+		pool, err := loadCABundle(filepath.Join("/", root, cfg.CABundle))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	proxy, err := proxyFunc(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   DIAL_TIMEOUT,
+			KeepAlive: KEEP_ALIVE,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   TLS_HANDSHAKE_TIMEOUT,
+		ResponseHeaderTimeout: time.Duration(cfg.RequestTimeout),
+		IdleConnTimeout:       IDLE_CONN_TIMEOUT,
+		MaxIdleConns:          MAX_IDLE_CONNS,
+	}, nil
+}
+
+// loadCABundle add the certificates of bundle to the system pool
+func loadCABundle(file string) (*x509.CertPool, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read ca_bundle error: %s", err.Error())
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.Warnf("Transport, load system certificates error: %s, use ca_bundle only", err.Error())
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("no certificate in ca_bundle %s", file)
+	}
+	return pool, nil
+}
+
+// proxyFunc use the proxies of config, or the env HTTP_PROXY/HTTPS_PROXY/NO_PROXY if not set.
+// The metadata server is never proxied.
+func proxyFunc(cfg *config.Config) (func(*http.Request) (*url.URL, error), error) {
+	if cfg.HTTPProxy == "" && cfg.HTTPSProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxies := map[string]*url.URL{}
+	for scheme, proxy := range map[string]string{"http": cfg.HTTPProxy, "https": cfg.HTTPSProxy} {
+		if proxy == "" {
+			continue
+		}
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("illegal %s_proxy: %s", scheme, err.Error())
+		}
+		proxies[scheme] = proxyURL
+	}
+
+	noProxy := strings.Split(cfg.NoProxy, ",")
+	if metadataURL, err := url.Parse(cfg.MetadataURL); err == nil {
+		noProxy = append(noProxy, metadataURL.Hostname())
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if bypass(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxies[req.URL.Scheme], nil
+	}, nil
+}
+
+// bypass check the host matches the no_proxy list: the host, or the domain suffix like .aliyuncs.com
+func bypass(host string, noProxy []string) bool {
+	for _, item := range noProxy {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case item == "*" || item == host:
+			return true
+		case strings.HasPrefix(item, ".") && strings.HasSuffix(host, item):
+			return true
+		case strings.HasSuffix(host, "."+item):
+			return true
+		}
+	}
+	return false
+}
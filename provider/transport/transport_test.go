@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AliyunContainerService/flexvolume/provider/config"
+)
+
+func TestCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the ca bundle is read under root
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.crt"), bundle, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.CABundle = "/ca.crt"
+	cfg.TLSMinVersion = "1.2"
+	transport, err := New(cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.TLSClientConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expect tls 1.2, got %d", transport.TLSClientConfig.MinVersion)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with ca bundle: %s", err)
+	}
+	resp.Body.Close()
+
+	// the server is not trusted without the bundle
+	transport, _ = New(config.Default(), dir)
+	if _, err := (&http.Client{Transport: transport}).Get(server.URL); err == nil {
+		t.Errorf("expect certificate error without ca bundle")
+	}
+
+	cfg.CABundle = "/missing.crt"
+	if _, err := New(cfg, dir); err == nil {
+		t.Errorf("expect error of missing ca bundle")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	cfg := config.Default()
+	cfg.HTTPProxy = "http://proxy.local:3128"
+	cfg.HTTPSProxy = "http://proxy.local:3129"
+	cfg.NoProxy = ".internal, 10.0.0.1,vpc.aliyuncs.com"
+	transport, err := New(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"https://ecs.aliyuncs.com":                     "http://proxy.local:3129",
+		"http://oss-cn-hangzhou.aliyuncs.com/":         "http://proxy.local:3128",
+		"https://ecs.internal/":                        "",
+		"http://10.0.0.1:8080/":                        "",
+		"https://ecs.vpc.aliyuncs.com/":                "",
+		"http://100.100.100.200/latest/meta-data/":     "",
+		"https://nas-vpc.cn-hangzhou.aliyuncs.com/api": "http://proxy.local:3129",
+	}
+	for target, expect := range cases {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		proxy, err := transport.Proxy(req)
+		got := ""
+		if proxy != nil {
+			got = proxy.String()
+		}
+		if err != nil || got != expect {
+			t.Errorf("proxy of %s: expect %q, got %q, %v", target, expect, got, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/denverdino/aliyungo/common"
+)
+
+// ErrorCode is the stable error taxonomy of failure result, used by tools instead of the message
+type ErrorCode string
+
+// error codes of failure result
+const (
+	CODE_INVALID_ARGUMENTS      ErrorCode = "InvalidArguments"
+	CODE_INVALID_OPTIONS        ErrorCode = "InvalidOptions"
+	CODE_CALL_TIMEOUT           ErrorCode = "CallTimeout"
+	CODE_CREDENTIAL_MISSING     ErrorCode = "CredentialMissing"
+	CODE_METADATA_UNAVAILABLE   ErrorCode = "MetadataUnavailable"
+	CODE_MOUNT_FAILED           ErrorCode = "MountFailed"
+	CODE_UNMOUNT_FAILED         ErrorCode = "UnmountFailed"
+	CODE_MOUNT_MODE_MISMATCH    ErrorCode = "MountModeMismatch"
+	CODE_INTERNAL               ErrorCode = "InternalError"
+	CODE_LOCK_TIMEOUT           ErrorCode = "LockTimeout"
+	CODE_ECS_THROTTLED          ErrorCode = "EcsThrottled"
+	CODE_ECS_FORBIDDEN          ErrorCode = "EcsForbidden"
+	CODE_ECS_CONFLICT           ErrorCode = "EcsConflict"
+	CODE_ECS_UNAVAILABLE        ErrorCode = "EcsUnavailable"
+	CODE_ECS_ERROR              ErrorCode = "EcsError"
+	CODE_DISK_NOT_FOUND         ErrorCode = "DiskNotFound"
+	CODE_DISK_ATTACH_FAILED     ErrorCode = "DiskAttachFailed"
+	CODE_DISK_ATTACH_TIMEOUT    ErrorCode = "DiskAttachTimeout"
+	CODE_DISK_DETACH_FAILED     ErrorCode = "DiskDetachFailed"
+	CODE_DISK_DETACH_TIMEOUT    ErrorCode = "DiskDetachTimeout"
+	CODE_DISK_DEVICE_NOT_FOUND  ErrorCode = "DiskDeviceNotFound"
+	CODE_DISK_DEVICE_IN_USE     ErrorCode = "DiskDeviceInUse"
+	CODE_DISK_FORMAT_FAILED     ErrorCode = "DiskFormatFailed"
+	CODE_DISK_RESIZE_FAILED     ErrorCode = "DiskResizeFailed"
+	CODE_DISK_RESIZE_TIMEOUT    ErrorCode = "DiskResizeTimeout"
+	CODE_DISK_LOCKED            ErrorCode = "DiskLocked"
+	CODE_NAS_UNREACHABLE        ErrorCode = "NasUnreachable"
+	CODE_NAS_MOUNT_FAILED       ErrorCode = "NasMountFailed"
+	CODE_OSS_CREDENTIAL_MISSING ErrorCode = "OssCredentialMissing"
+	CODE_OSS_MOUNT_FAILED       ErrorCode = "OssMountFailed"
+	CODE_CPFS_MOUNT_FAILED      ErrorCode = "CpfsMountFailed"
+)
+
+// the errors may disappear when the call is retried later
+var retryableCodes = map[ErrorCode]bool{
+	CODE_CALL_TIMEOUT:          true,
+	CODE_LOCK_TIMEOUT:          true,
+	CODE_METADATA_UNAVAILABLE:  true,
+	CODE_ECS_THROTTLED:         true,
+	CODE_ECS_CONFLICT:          true,
+	CODE_ECS_UNAVAILABLE:       true,
+	CODE_DISK_ATTACH_TIMEOUT:   true,
+	CODE_DISK_DETACH_TIMEOUT:   true,
+	CODE_DISK_DEVICE_NOT_FOUND: true,
+	CODE_DISK_RESIZE_TIMEOUT:   true,
+	CODE_DISK_LOCKED:           true,
+	CODE_NAS_UNREACHABLE:       true,
+}
+
+// ecs api error codes, see the error center of ecs openapi
+var ecsErrorCodes = map[string]ErrorCode{
+	"Throttling":                   CODE_ECS_THROTTLED,
+	"Throttling.User":              CODE_ECS_THROTTLED,
+	"Throttling.Api":               CODE_ECS_THROTTLED,
+	"ServiceUnavailable":           CODE_ECS_UNAVAILABLE,
+	"InternalError":                CODE_ECS_UNAVAILABLE,
+	"InvalidDiskId.NotFound":       CODE_DISK_NOT_FOUND,
+	"InvalidDisk.NotFound":         CODE_DISK_NOT_FOUND,
+	"IncorrectDiskStatus":          CODE_ECS_CONFLICT,
+	"IncorrectInstanceStatus":      CODE_ECS_CONFLICT,
+	"OperationConflict":            CODE_ECS_CONFLICT,
+	"LastTokenProcessing":          CODE_ECS_CONFLICT,
+	"Forbidden":                    CODE_ECS_FORBIDDEN,
+	"Forbidden.RAM":                CODE_ECS_FORBIDDEN,
+	"Forbidden.NotAuthorized":      CODE_ECS_FORBIDDEN,
+	"InvalidAccessKeyId.NotFound":  CODE_CREDENTIAL_MISSING,
+	"InvalidAccessKeyId.Inactive":  CODE_CREDENTIAL_MISSING,
+	"SignatureDoesNotMatch":        CODE_CREDENTIAL_MISSING,
+	"InvalidSecurityToken.Expired": CODE_CREDENTIAL_MISSING,
+}
+
+// IsRetryable check the error may disappear if retry later
+func IsRetryable(code ErrorCode) bool {
+	return retryableCodes[code]
+}
+
+// CodeError is an error with the error code, returned by the helpers which know the reason
+type CodeError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *CodeError) Error() string {
+	return e.Message
+}
+
+// NewCodeError create error with code
+func NewCodeError(code ErrorCode, a ...interface{}) error {
+	return &CodeError{Code: code, Message: fmt.Sprint(a...)}
+}
+
+// ErrorCodeOf classify the error, return fallback if not recognized
+func ErrorCodeOf(err error, fallback ErrorCode) ErrorCode {
+	switch e := err.(type) {
+	case nil:
+		return fallback
+	case *CodeError:
+		return e.Code
+	case *common.Error:
+		if code, ok := ecsErrorCodes[e.Code]; ok {
+			return code
+		}
+		if strings.HasPrefix(e.Code, "Throttling") {
+			return CODE_ECS_THROTTLED
+		}
+		if e.StatusCode >= 500 {
+			return CODE_ECS_UNAVAILABLE
+		}
+		return fallback
+	case net.Error:
+		if e.Timeout() || e.Temporary() {
+			return CODE_ECS_UNAVAILABLE
+		}
+	}
+	return fallback
+}
+
+// FailWithCode fail the flexvolume call with error code
+func FailWithCode(code ErrorCode, a ...interface{}) Result {
+	return Result{
+		Status:    "Failure",
+		Message:   fmt.Sprint(a...),
+		Code:      code,
+		Retryable: IsRetryable(code),
+	}
+}
+
+// FailWithError fail the flexvolume call with the code of error, fallback if the error not recognized
+func FailWithError(err error, fallback ErrorCode, a ...interface{}) Result {
+	return FailWithCode(ErrorCodeOf(err, fallback), a...)
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCommandTimeout is used when command not set timeout
+const DefaultCommandTimeout = 2 * time.Minute
+
+// Command is the argument vector of a command, never interpreted by shell
+type Command struct {
+	Name    string
+	Args    []string
+	Timeout time.Duration
+}
+
+// NewCommand create command with default timeout
+func NewCommand(name string, args ...string) Command {
+	return Command{Name: name, Args: args}
+}
+
+// String return the command line, only used for logging
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Executor run the command and return stdout, stderr separately,
+// the command is killed when the context is done
+type Executor interface {
+	Execute(ctx context.Context, cmd Command) (string, string, error)
+}
+
+// CommandExecutor run the command as child process
+type CommandExecutor struct{}
+
+// NewExecutor create executor running commands on host
+func NewExecutor() Executor {
+	return &CommandExecutor{}
+}
+
+// Execute run the command, kill it if timeout or the call deadline exceeded
+func (e *CommandExecutor) Execute(parent context.Context, cmd Command) (string, string, error) {
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = DefaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err := c.Run()
+	if parent.Err() != nil {
+		return stdout.String(), stderr.String(), fmt.Errorf("Failed to run cmd: %s, call deadline exceeded", cmd)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return stdout.String(), stderr.String(), fmt.Errorf("Failed to run cmd: %s, timeout after %s", cmd, timeout)
+	}
+	if err != nil {
+		return stdout.String(), stderr.String(), fmt.Errorf("Failed to run cmd: %s, with out: %s, with error: %s", cmd, strings.TrimSpace(stderr.String()+stdout.String()), err.Error())
+	}
+	return stdout.String(), stderr.String(), nil
+}
+
+// Run run command with the executor and return stdout, stderr is included in the error
+func Run(ctx context.Context, executor Executor, name string, args ...string) (string, error) {
+	stdout, _, err := executor.Execute(ctx, NewCommand(name, args...))
+	return stdout, err
+}
+
+// FakeExecutor record the commands instead of running them, used in tests
+type FakeExecutor struct {
+	mutex    sync.Mutex
+	Commands []Command
+	// Handler return the outputs of the command, succeed with empty output if not set
+	Handler func(cmd Command) (string, string, error)
+}
+
+// Execute record the command and call the handler
+func (e *FakeExecutor) Execute(ctx context.Context, cmd Command) (string, string, error) {
+	e.mutex.Lock()
+	e.Commands = append(e.Commands, cmd)
+	e.mutex.Unlock()
+	if e.Handler == nil {
+		return "", "", nil
+	}
+	return e.Handler(cmd)
+}
+
+// CommandLines return the recorded commands as command lines
+func (e *FakeExecutor) CommandLines() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	lines := []string{}
+	for _, cmd := range e.Commands {
+		lines = append(lines, cmd.String())
+	}
+	return lines
+}
@@ -1,6 +1,9 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"runtime"
+)
 
 var (
 	// VERSION should be updated by hand at each release
@@ -8,13 +11,45 @@ var (
 
 	// GITCOMMIT will be overwritten automatically by the build system
 	GITCOMMIT = "HEAD"
+
+	// BUILDDATE will be overwritten automatically by the build system
+	BUILDDATE = "unknown"
 )
 
+// VersionInfo is the build info printed by "version --json"
+type VersionInfo struct {
+	Version   string   `json:"version"`
+	GitCommit string   `json:"gitCommit"`
+	BuildDate string   `json:"buildDate"`
+	GoVersion string   `json:"goVersion"`
+	Platform  string   `json:"platform"`
+	Drivers   []string `json:"drivers,omitempty"`
+	Verbs     []string `json:"verbs,omitempty"`
+}
+
 // PluginVersion
 func PluginVersion() string {
 	return VERSION
 }
 
+// BuildInfo return the build info of the binary, drivers and verbs are filled by the caller
+func BuildInfo() VersionInfo {
+	return VersionInfo{
+		Version:   VERSION,
+		GitCommit: GITCOMMIT,
+		BuildDate: BUILDDATE,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// SameBuild check the two binaries are built from the same source,
+// binaries of the same version may be built from different commits.
+// The build date is ignored, the same commit is rebuilt by every image build.
+func (v VersionInfo) SameBuild(other VersionInfo) bool {
+	return v.Version == other.Version && v.GitCommit == other.GitCommit
+}
+
 // Usage help
 func Usage() {
 	fmt.Printf("In K8s Mode: " +
@@ -22,7 +57,27 @@ func Usage() {
 		"    plugin init: \n" +
 		"    plugin attach: for alicloud disk plugin\n" +
 		"    plugin detach: for alicloud disk plugin\n" +
+		"    plugin isattached: for alicloud disk plugin\n" +
+		"    plugin mountdevice: for alicloud disk plugin\n" +
+		"    plugin unmountdevice: for alicloud disk plugin\n" +
+		"    plugin expandvolume: for alicloud disk plugin\n" +
+		"    plugin expandfs: for alicloud disk plugin\n" +
 		"    plugin mount:  for nas, oss plugin\n" +
 		"    plugin umount: for nas, oss plugin\n\n" +
-		"You can refer to K8s flexvolume docs: \n")
+		"You can refer to K8s flexvolume docs: \n\n" +
+		"Version: " +
+		"flexvolume version [--json], print the version, or the build info with drivers and verbs in json\n\n" +
+		"List drivers: " +
+		"flexvolume drivers [--names], print the drivers with capabilities and options\n\n" +
+		"Audit journal: " +
+		"flexvolume audit [--volume v] [--pod uid] [--driver d] [--since 24h] [--until t], print the recorded calls\n\n" +
+		"Operation journal: " +
+		"flexvolume journal [--volume v] [--driver d], print the unfinished volume operations\n\n" +
+		"Node config: " +
+		"flexvolume config [--file f], print the config of flexvolume.conf with defaults and env applied\n\n" +
+		"Credentials: " +
+		"flexvolume credentials [--driver d] [--root r] [--no-token], print the credential sources in order and which one is used\n\n" +
+		"In Swarm Mode: " +
+		"Set ACS_PLATFORM=swarm (or platform: swarm in flexvolume.conf) and run without parameter, docker volume plugins are served on:\n" +
+		"    /run/docker/plugins/alicloud-<driver>.sock\n")
 }
@@ -1,42 +1,21 @@
 package utils
 
 import (
-	b64 "encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"os/exec"
 	"reflect"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/denverdino/aliyungo/metadata"
+	"github.com/AliyunContainerService/flexvolume/provider/metadata"
+	"github.com/AliyunContainerService/flexvolume/provider/mountinfo"
 	log "github.com/sirupsen/logrus"
 )
 
-// DefaultOptions used for global ak
-type DefaultOptions struct {
-	Global struct {
-		KubernetesClusterTag string
-		AccessKeyID          string `json:"accessKeyID"`
-		AccessKeySecret      string `json:"accessKeySecret"`
-		Region               string `json:"region"`
-	}
-}
-
-// const values
-const (
-	encodedCredPath = "/etc/kubernetes/cloud-config.alicloud"
-	credPath        = "/etc/kubernetes/cloud-config"
-	USER_AKID       = "/etc/.volumeak/akId"
-	USER_AKSECRET   = "/etc/.volumeak/akSecret"
-	METADATA_URL    = "http://100.100.100.200/latest/meta-data/"
-	REGIONID_TAG    = "region-id"
-	INSTANCEID_TAG  = "instance-id"
-)
-
 // Succeed successful action
 func Succeed(a ...interface{}) Result {
 	return Result{
@@ -45,6 +24,14 @@ func Succeed(a ...interface{}) Result {
 	}
 }
 
+// SucceedWithCapabilities successful init action with driver capabilities
+func SucceedWithCapabilities(capabilities Capabilities) Result {
+	return Result{
+		Status:       "Success",
+		Capabilities: &capabilities,
+	}
+}
+
 // NotSupport not support action
 func NotSupport(a ...interface{}) Result {
 	return Result{
@@ -61,11 +48,33 @@ func Fail(a ...interface{}) Result {
 	}
 }
 
+// Timeout fail the flexvolume call which not finished before the deadline
+func Timeout(call string, timeout time.Duration, a ...interface{}) Result {
+	message := fmt.Sprintf("Timeout: %s not finished in %s", call, timeout)
+	if detail := fmt.Sprint(a...); detail != "" {
+		message += ", " + detail
+	}
+	return Result{
+		Status:    "Failure",
+		Message:   message,
+		Code:      CODE_CALL_TIMEOUT,
+		Retryable: true,
+	}
+}
+
+// ExitCode return the process exit code of the result
+func ExitCode(result Result) int {
+	if result.Status == "Success" {
+		return 0
+	}
+	return 1
+}
+
 // Finish finish call
 func Finish(result Result) {
-	code := 1
-	if result.Status == "Success" {
-		code = 0
+	code := ExitCode(result)
+	if result.Status == "Failure" {
+		log.WithFields(log.Fields{"code": result.Code, "retryable": result.Retryable}).Info("Exit with Error: ", result.Message)
 	}
 	res, err := json.Marshal(result)
 	if err != nil {
@@ -76,27 +85,30 @@ func Finish(result Result) {
 	os.Exit(code)
 }
 
-// FinishError print error info
-func FinishError(message string) {
-	log.Info("Exit with Error: ", message)
-	Finish(Fail(message))
-}
-
 // Result of flexvolume
 type Result struct {
-	Status     string `json:"status"`
-	Message    string `json:"message,omitempty"`
-	Device     string `json:"device,omitempty"`
-	VolumeName string `json:"volumeName"`
+	Status       string        `json:"status"`
+	Message      string        `json:"message,omitempty"`
+	Device       string        `json:"device,omitempty"`
+	VolumeName   string        `json:"volumeName"`
+	Attached     bool          `json:"attached,omitempty"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	Code         ErrorCode     `json:"code,omitempty"`
+	Retryable    bool          `json:"retryable,omitempty"`
 }
 
-// Run run shell command
-func Run(cmd string) (string, error) {
-	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with out: " + string(out) + ", with error: " + err.Error())
-	}
-	return string(out), nil
+// access modes of kubernetes.io/readwrite, passed by kubelet
+const (
+	READ_WRITE = "rw"
+	READ_ONLY  = "ro"
+)
+
+// Capabilities of flexvolume driver, returned by init call
+type Capabilities struct {
+	Attach          bool `json:"attach"`
+	SELinuxRelabel  bool `json:"selinuxRelabel"`
+	SupportsMetrics bool `json:"supportsMetrics"`
+	FSGroup         bool `json:"fsGroup"`
 }
 
 // CreateDest create directory
@@ -117,26 +129,72 @@ func CreateDest(dest string) error {
 	return nil
 }
 
-// IsMounted check directory is mounted or not.
-func IsMounted(mountPath string) bool {
-	cmd := fmt.Sprintf("mount | grep \"%s type\" | grep -v grep", mountPath)
-	out, err := Run(cmd)
-	if err != nil || out == "" {
+// IsMounted check directory is mounted or not in the mountinfo file.
+func IsMounted(mountInfoFile, mountPath string) bool {
+	mounted, err := mountinfo.IsMountPoint(mountInfoFile, mountPath)
+	if err != nil {
+		log.Errorf("Check mount point %s error: %s", mountPath, err.Error())
 		return false
 	}
-	return true
+	return mounted
 }
 
-// Umount umount path.
-func Umount(mountPath string) bool {
-	cmd := fmt.Sprintf("umount -f %s", mountPath)
-	_, err := Run(cmd)
+// CheckMountMode check the mount of path has the access mode of volume, return false if it is not mounted.
+// The mount in the other mode is not reused, kubelet should unmount it first.
+func CheckMountMode(mountInfoFile, mountPath string, readOnly bool) (bool, error) {
+	table, err := mountinfo.Load(mountInfoFile)
 	if err != nil {
+		log.Errorf("Check mount point %s error: %s", mountPath, err.Error())
+		return false, nil
+	}
+	mount, ok := table.ByMountPoint(mountPath)
+	if !ok {
+		return false, nil
+	}
+	if mount.ReadOnly() != readOnly {
+		return true, NewCodeError(CODE_MOUNT_MODE_MISMATCH, fmt.Sprintf("%s is mounted %s, but the volume is %s", mountPath, accessMode(mount.ReadOnly()), accessMode(readOnly)))
+	}
+	return true, nil
+}
+
+// IsReadOnly parse the access mode of kubernetes.io/readwrite, empty is read write
+func IsReadOnly(mode string) (bool, error) {
+	switch mode {
+	case "", READ_WRITE:
+		return false, nil
+	case READ_ONLY:
+		return true, nil
+	}
+	return false, fmt.Errorf("illegal kubernetes.io/readwrite: %s, expect %s or %s", mode, READ_WRITE, READ_ONLY)
+}
+
+func accessMode(readOnly bool) string {
+	if readOnly {
+		return READ_ONLY
+	}
+	return READ_WRITE
+}
+
+// Umount umount path.
+func Umount(ctx context.Context, executor Executor, mountPath string) bool {
+	if _, err := Run(ctx, executor, "umount", "-f", mountPath); err != nil {
 		return false
 	}
 	return true
 }
 
+// Sleep wait for the duration, return the context error if the deadline exceeded before.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // IsFileExisting check file exist in volume driver;
 func IsFileExisting(filename string) bool {
 	_, err := os.Stat(filename)
@@ -149,31 +207,22 @@ func IsFileExisting(filename string) bool {
 	return true
 }
 
-// GetRegionAndInstanceId Get regionid instanceid;
-func GetRegionAndInstanceId() (string, string, error) {
-	regionId, err := GetMetaData(REGIONID_TAG)
+// GetRegionAndInstanceId Get regionid instanceid in the call deadline;
+func GetRegionAndInstanceId(ctx context.Context) (string, string, error) {
+	regionId, err := metadata.RegionID(ctx)
 	if err != nil {
 		return "", "", err
 	}
-	instanceId, err := GetMetaData(INSTANCEID_TAG)
+	instanceId, err := metadata.InstanceID(ctx)
 	if err != nil {
 		return "", "", err
 	}
 	return regionId, instanceId, nil
 }
 
-// GetMetaData get metadata
-func GetMetaData(resource string) (string, error) {
-	resp, err := http.Get(METADATA_URL + resource)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	return string(body), nil
+// GetMetaData get metadata of the node provider, with timeouts, retries and cache
+func GetMetaData(ctx context.Context, resource string) (string, error) {
+	return metadata.Default().Get(ctx, resource)
 }
 
 // GetRegionIdAndInstanceId get region id instance id
@@ -216,105 +265,6 @@ func ReadJsonFile(file string) (map[string]string, error) {
 	return jsonObj, nil
 }
 
-// GetLocalAK read ossfs ak from local or from secret file
-func GetLocalAK() (string, string) {
-	accessKeyID, accessSecret := "", ""
-	//accessKeyID, accessSecret = GetLocalAK()
-	//if accessKeyID == "" || accessSecret == "" {
-	if IsFileExisting(USER_AKID) && IsFileExisting(USER_AKSECRET) {
-		raw, err := ioutil.ReadFile(USER_AKID)
-		if err != nil {
-			log.Error("Read User AK ID file error:", err.Error())
-			return "", ""
-		}
-		accessKeyID = string(raw)
-
-		raw, err = ioutil.ReadFile(USER_AKSECRET)
-		if err != nil {
-			log.Error("Read User AK Secret file error:", err.Error())
-			return "", ""
-		}
-		accessSecret = string(raw)
-	} else {
-		accessKeyID, accessSecret = GetLocalSystemAK()
-	}
-	//}
-	return strings.TrimSpace(accessKeyID), strings.TrimSpace(accessSecret)
-}
-
-// GetDefaultAK read default ak from local file or from STS
-func GetDefaultAK() (string, string, string) {
-	accessKeyID, accessSecret := GetLocalAK()
-
-	accessToken := ""
-	if accessKeyID == "" || accessSecret == "" {
-		accessKeyID, accessSecret, accessToken = GetSTSAK()
-	}
-
-	return accessKeyID, accessSecret, accessToken
-
-}
-
-// GetSTSAK get STS AK
-func GetSTSAK() (string, string, string) {
-	m := metadata.NewMetaData(nil)
-
-	rolename := ""
-	var err error
-	if rolename, err = m.Role(); err != nil {
-		log.Fatal("Get role name error: ", err.Error())
-		return "", "", ""
-	}
-	role, err := m.RamRoleToken(rolename)
-	if err != nil {
-		log.Fatal("Get STS Token error, " + err.Error())
-		return "", "", ""
-	}
-	return role.AccessKeyId, role.AccessKeySecret, role.SecurityToken
-}
-
-// GetLocalSystemAK get local access key
-func GetLocalSystemAK() (string, string) {
-	var accessKeyID, accessSecret string
-	var defaultOpt DefaultOptions
-
-	if IsFileExisting(encodedCredPath) {
-		raw, err := ioutil.ReadFile(encodedCredPath)
-		if err != nil {
-			FinishError("Read cred file failed: " + err.Error())
-		}
-		err = json.Unmarshal(raw, &defaultOpt)
-		if err != nil {
-			FinishError("Parse json cert file error: " + err.Error())
-		}
-		keyID, err := b64.StdEncoding.DecodeString(defaultOpt.Global.AccessKeyID)
-		if err != nil {
-			FinishError("Decode accesskeyid failed: " + err.Error())
-		}
-		secret, err := b64.StdEncoding.DecodeString(defaultOpt.Global.AccessKeySecret)
-		if err != nil {
-			FinishError("Decode secret failed: " + err.Error())
-		}
-		accessKeyID = string(keyID)
-		accessSecret = string(secret)
-	} else if IsFileExisting(credPath) {
-		raw, err := ioutil.ReadFile(credPath)
-		if err != nil {
-			FinishError("Read cred file failed: " + err.Error())
-		}
-		err = json.Unmarshal(raw, &defaultOpt)
-		if err != nil {
-			FinishError("New Ecs Client error json, " + err.Error())
-		}
-		accessKeyID = defaultOpt.Global.AccessKeyID
-		accessSecret = defaultOpt.Global.AccessKeySecret
-
-	} else {
-		return "", ""
-	}
-	return accessKeyID, accessSecret
-}
-
 // PathExists returns true if the specified path exists.
 func PathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denverdino/aliyungo/common"
+)
+
+func TestSucceedWithCapabilities(t *testing.T) {
+	result := SucceedWithCapabilities(Capabilities{Attach: false, SupportsMetrics: true})
+	out, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("marshal result error: %v", err)
+	}
+	if !strings.Contains(string(out), `"capabilities":{"attach":false,"selinuxRelabel":false,"supportsMetrics":true,"fsGroup":false}`) {
+		t.Errorf("capabilities not serialized: %s", out)
+	}
+
+	out, _ = json.Marshal(Succeed())
+	if strings.Contains(string(out), "capabilities") {
+		t.Errorf("capabilities should be omitted: %s", out)
+	}
+}
+
+func TestIsMounted(t *testing.T) {
+	file, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		t.Fatalf("create mountinfo error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("30 22 253:16 / /mnt/disk10 rw,relatime shared:10 - ext4 /dev/vdb rw\n")
+	file.Close()
+
+	if !IsMounted(file.Name(), "/mnt/disk10") {
+		t.Errorf("/mnt/disk10 should be mounted")
+	}
+	if IsMounted(file.Name(), "/mnt/disk1") {
+		t.Errorf("/mnt/disk1 should not be mounted")
+	}
+}
+
+func TestCheckMountMode(t *testing.T) {
+	file, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		t.Fatalf("create mountinfo error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("30 22 253:16 / /mnt/rw rw,relatime shared:10 - ext4 /dev/vdb rw\n")
+	file.WriteString("31 22 0:45 / /mnt/nfs ro,relatime shared:11 - nfs nas:/ rw,vers=3\n")
+	file.WriteString("32 22 253:32 / /mnt/bind rw,relatime shared:12 - ext4 /dev/vdc ro\n")
+	file.Close()
+
+	cases := []struct {
+		path     string
+		readOnly bool
+		mounted  bool
+		mismatch bool
+	}{
+		{"/mnt/rw", false, true, false},
+		{"/mnt/rw", true, true, true},
+		{"/mnt/nfs", true, true, false},
+		{"/mnt/nfs", false, true, true},
+		// the filesystem is read only
+		{"/mnt/bind", false, true, true},
+		{"/mnt/none", true, false, false},
+	}
+	for _, c := range cases {
+		mounted, err := CheckMountMode(file.Name(), c.path, c.readOnly)
+		if mounted != c.mounted || (err != nil) != c.mismatch {
+			t.Errorf("check %s read only %t: expect %t, %t, got %t, %v", c.path, c.readOnly, c.mounted, c.mismatch, mounted, err)
+		}
+		if err != nil && ErrorCodeOf(err, CODE_INTERNAL) != CODE_MOUNT_MODE_MISMATCH {
+			t.Errorf("expect code %s, got %v", CODE_MOUNT_MODE_MISMATCH, err)
+		}
+	}
+
+	for mode, expect := range map[string]bool{"": false, READ_WRITE: false, READ_ONLY: true} {
+		if readOnly, err := IsReadOnly(mode); err != nil || readOnly != expect {
+			t.Errorf("access mode %q: expect %t, got %t, %v", mode, expect, readOnly, err)
+		}
+	}
+	if _, err := IsReadOnly("readonly"); err == nil {
+		t.Errorf("expect illegal access mode")
+	}
+}
+
+func TestFailWithError(t *testing.T) {
+	cases := []struct {
+		err    error
+		code   ErrorCode
+		retry  bool
+		source string
+	}{
+		{&common.Error{ErrorResponse: common.ErrorResponse{Code: "Throttling.User"}, StatusCode: 400}, CODE_ECS_THROTTLED, true, "throttling"},
+		{&common.Error{ErrorResponse: common.ErrorResponse{Code: "InvalidDiskId.NotFound"}, StatusCode: 404}, CODE_DISK_NOT_FOUND, false, "not found"},
+		{&common.Error{ErrorResponse: common.ErrorResponse{Code: "UnknownError"}, StatusCode: 503}, CODE_ECS_UNAVAILABLE, true, "5xx"},
+		{&common.Error{ErrorResponse: common.ErrorResponse{Code: "InvalidParameter"}, StatusCode: 400}, CODE_DISK_ATTACH_FAILED, false, "unknown ecs code"},
+		{NewCodeError(CODE_NAS_UNREACHABLE, "dial timeout"), CODE_NAS_UNREACHABLE, true, "code error"},
+		{errors.New("plain"), CODE_DISK_ATTACH_FAILED, false, "plain error"},
+	}
+	for _, c := range cases {
+		result := FailWithError(c.err, CODE_DISK_ATTACH_FAILED, "attach failed")
+		if result.Status != "Failure" || result.Code != c.code || result.Retryable != c.retry {
+			t.Errorf("%s: expect %s/%t, got: %+v", c.source, c.code, c.retry, result)
+		}
+	}
+
+	out, _ := json.Marshal(Timeout("attach", time.Second))
+	if !strings.Contains(string(out), `"code":"CallTimeout","retryable":true`) {
+		t.Errorf("timeout code not serialized: %s", out)
+	}
+	if out, _ := json.Marshal(Succeed()); strings.Contains(string(out), "code") {
+		t.Errorf("code serialized for success: %s", out)
+	}
+}
+
+func TestExecuteLiteralArgs(t *testing.T) {
+	// the command is not run by shell, every argument reaches it literally
+	args := []string{"$(id)", "/a; rm -rf /", "`reboot`", "a b"}
+	stdout, _, err := NewExecutor().Execute(context.Background(), NewCommand("printf", append([]string{"[%s]\n"}, args...)...))
+	if err != nil {
+		t.Fatalf("printf error: %v", err)
+	}
+	if expect := "[$(id)]\n[/a; rm -rf /]\n[`reboot`]\n[a b]\n"; stdout != expect {
+		t.Errorf("printf output: %q, expect: %q", stdout, expect)
+	}
+}